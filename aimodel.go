@@ -4,28 +4,30 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
-	"runtime"
 	"sort"
 	"strings"
 	"sync"
 	"time"
-
-	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // AIModelService handles AI model downloads and packaging
 type AIModelService struct {
 	ctx                context.Context
 	downloadLocation   string
-	activeDownloads    map[string]*exec.Cmd
-	downloadsMutex     sync.Mutex
 	cancelChannels     map[string]chan bool
 	cancelChannelMutex sync.Mutex
+	events             EventHandler  // Receives events instead of the frontend in headless mode
+	activeProfile      func() string // Names the profile events are tagged with
+
+	transport func() (*http.Transport, error)                        // Proxy and TLS settings for Hub requests
+	layout    func(root string, fields layoutFields) (string, error) // Places models below the download location
 }
 
 // ModelType represents the type of AI model
@@ -39,8 +41,7 @@ const (
 // NewAIModelService creates a new AI model service
 func NewAIModelService() *AIModelService {
 	return &AIModelService{
-		activeDownloads: make(map[string]*exec.Cmd),
-		cancelChannels:  make(map[string]chan bool),
+		cancelChannels: make(map[string]chan bool),
 	}
 }
 
@@ -54,24 +55,65 @@ func (a *AIModelService) SetDownloadLocation(location string) {
 	a.downloadLocation = location
 }
 
-// getHuggingFaceCLI returns the correct CLI command based on platform
-// macOS uses 'hf' (installed via brew), others use 'huggingface-cli' (installed via pip)
-func getHuggingFaceCLI() (string, error) {
-	// Check for 'hf' first (macOS brew installation)
-	if _, err := exec.LookPath("hf"); err == nil {
-		return "hf", nil
+// modelPath returns where a model file or directory named name is stored,
+// following the download layout when one is set
+func (a *AIModelService) modelPath(ref hfRepoRef, name string) (string, error) {
+	if a.layout == nil {
+		return filepath.Join(a.downloadLocation, name), nil
 	}
+	return a.layout(a.downloadLocation, layoutFields{
+		Kind:        KindAIModel,
+		ProductSlug: ref.Repo,
+		Version:     ref.Revision,
+		File:        name,
+	})
+}
 
-	// Check for 'huggingface-cli' (pip installation)
-	if _, err := exec.LookPath("huggingface-cli"); err == nil {
-		return "huggingface-cli", nil
-	}
+// registerCancel creates the channel CancelModelDownload uses to stop a model
+func (a *AIModelService) registerCancel(modelName string) chan bool {
+	cancelChan := make(chan bool, 1)
+	a.cancelChannelMutex.Lock()
+	a.cancelChannels[modelName] = cancelChan
+	a.cancelChannelMutex.Unlock()
+	return cancelChan
+}
+
+// unregisterCancel forgets the cancel channel of a finished model
+func (a *AIModelService) unregisterCancel(modelName string) {
+	a.cancelChannelMutex.Lock()
+	delete(a.cancelChannels, modelName)
+	a.cancelChannelMutex.Unlock()
+}
+
+// watchCancel returns a context that is cancelled with errDownloadCancelled
+// when CancelModelDownload fires, covering listing, download and packaging
+func watchCancel(cancelChan chan bool) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		select {
+		case <-cancelChan:
+			cancel(errDownloadCancelled)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
 
-	// Return appropriate error message based on platform
-	if runtime.GOOS == "darwin" {
-		return "", fmt.Errorf("HuggingFace CLI not found. Please install: brew install huggingface-cli")
+// modelCancelled reports whether ctx was stopped through CancelModelDownload
+func modelCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errDownloadCancelled)
+}
+
+// huggingFaceClient creates a Hub client with the configured proxy and TLS settings
+func (a *AIModelService) huggingFaceClient() (*hfClient, error) {
+	if a.transport == nil {
+		return newHuggingFaceClient(nil), nil
+	}
+	transport, err := a.transport()
+	if err != nil {
+		return nil, err
 	}
-	return "", fmt.Errorf("huggingface-cli not found. Please install: pip install huggingface-hub[cli]")
+	return newHuggingFaceClient(transport), nil
 }
 
 // DownloadOllamaModel downloads GGUF files from HuggingFace
@@ -82,286 +124,183 @@ func (a *AIModelService) DownloadOllamaModel(repoURL string, modelName string) e
 
 	// Parse HuggingFace URL to get repo and path
 	// Example: https://huggingface.co/unsloth/Llama-3.3-70B-Instruct-GGUF/tree/main/UD-Q6_K_XL
-	parts := strings.Split(strings.TrimPrefix(repoURL, "https://huggingface.co/"), "/")
-	if len(parts) < 4 {
-		return fmt.Errorf("invalid HuggingFace URL format")
+	client, err := a.huggingFaceClient()
+	if err != nil {
+		return err
+	}
+	ref, err := client.parseHuggingFaceURL(repoURL)
+	if err != nil {
+		return err
 	}
-
-	owner := parts[0]
-	repo := parts[1]
-	// Skip "tree/main" or "tree/master"
-	subPath := strings.Join(parts[4:], "/")
 
 	// Create model directory
-	modelDir := filepath.Join(a.downloadLocation, modelName)
+	modelDir, err := a.modelPath(ref, modelName)
+	if err != nil {
+		return err
+	}
 	if err := os.MkdirAll(modelDir, 0755); err != nil {
 		return fmt.Errorf("failed to create model directory: %w", err)
 	}
 
-	wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
+	a.emit("ai-model-status", map[string]interface{}{
 		"modelName": modelName,
-		"status":    "Starting download...",
-		"progress":  10,
+		"status":    "Listing model files...",
+		"progress":  0,
 	})
 
-	// Use huggingface-cli to download the files
-	return a.downloadWithHuggingFaceCLI(owner+"/"+repo, subPath, modelDir, modelName, "*.gguf")
-}
-
-// DownloadVLLMModel downloads safetensors and config files, then packages as tar.gz
-func (a *AIModelService) DownloadVLLMModel(repoURL string, modelName string) error {
-	if a.downloadLocation == "" {
-		return fmt.Errorf("download location not set")
-	}
-
-	// Parse HuggingFace URL
-	// Example: https://huggingface.co/openai/gpt-oss-120b
-	// or: https://huggingface.co/openai/gpt-oss-120b/tree/main
-	repoPath := strings.TrimPrefix(repoURL, "https://huggingface.co/")
-	repoPath = strings.TrimSuffix(repoPath, "/")
+	cancelChan := a.registerCancel(modelName)
+	defer a.unregisterCancel(modelName)
+	ctx, cancel := watchCancel(cancelChan)
+	defer cancel()
 
-	// Remove /tree/main or /tree/master if present (vLLM downloads from root)
-	if idx := strings.Index(repoPath, "/tree/"); idx != -1 {
-		repoPath = repoPath[:idx]
+	files, err := client.listFiles(ctx, ref, true)
+	if err != nil {
+		if modelCancelled(ctx) {
+			return nil
+		}
+		return err
 	}
-
-	// Create temp directory for downloads (visible in Downloads folder)
-	tempDir := filepath.Join(a.downloadLocation, modelName+"_temp")
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+	files = matchHuggingFaceFiles(files, []string{"*.gguf"}, false)
+	if len(files) == 0 {
+		return fmt.Errorf("no GGUF files found in %s", repoURL)
 	}
-	defer os.RemoveAll(tempDir) // Clean up temp directory
-
-	wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
-		"modelName": modelName,
-		"status":    "Downloading model files...",
-		"progress":  10,
-	})
 
-	// Download using huggingface-cli
-	// First, download .safetensors files
-	if err := a.downloadVLLMFiles(repoPath, tempDir, modelName); err != nil {
-		// Check if this was a cancellation (not an error)
-		if strings.Contains(err.Error(), "cancelled") {
-			// Don't return error for cancellation - it's expected behavior
+	if err := a.downloadHuggingFaceFiles(ctx, client, ref, files, modelDir, modelName); err != nil {
+		if errors.Is(err, errDownloadCancelled) {
 			return nil
 		}
 		return err
 	}
 
-	wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
+	// After download completes, merge split GGUF files
+	a.emit("ai-model-status", map[string]interface{}{
 		"modelName": modelName,
-		"status":    "Packaging model...",
-		"progress":  80,
+		"status":    "Merging GGUF files...",
+		"progress":  -1, // Keep the download progress
 	})
 
-	// Package as tar.gz with files at root level
-	// Get the cancel channel for this download
-	a.cancelChannelMutex.Lock()
-	cancelChan, exists := a.cancelChannels[modelName]
-	a.cancelChannelMutex.Unlock()
-
-	tarGzPath := filepath.Join(a.downloadLocation, modelName+".tar.gz")
-	if err := a.packageVLLMModel(tempDir, tarGzPath, modelName, cancelChan); err != nil {
-		if exists {
-			a.cancelChannelMutex.Lock()
-			delete(a.cancelChannels, modelName)
-			a.cancelChannelMutex.Unlock()
-		}
-		// Check if this was a cancellation (not an error)
-		if strings.Contains(err.Error(), "cancelled") {
-			// Don't return error for cancellation - it's expected behavior
-			return nil
-		}
-		return err
+	if err := a.mergeGGUFFiles(modelDir, modelName); err != nil {
+		return fmt.Errorf("failed to merge GGUF files: %w", err)
 	}
 
-	wailsruntime.EventsEmit(a.ctx, "ai-model-complete", map[string]interface{}{
+	a.emit("ai-model-complete", map[string]interface{}{
 		"modelName": modelName,
-		"path":      tarGzPath,
+		"path":      modelDir,
 	})
 
 	return nil
 }
 
-// downloadWithHuggingFaceCLI uses huggingface-cli to download files
-func (a *AIModelService) downloadWithHuggingFaceCLI(repo string, pattern string, destDir string, modelName string, filePattern string) error {
-	// Get the correct CLI command for the platform
-	cliCmd, err := getHuggingFaceCLI()
+// DownloadVLLMModel downloads safetensors and config files, then packages as tar.gz
+func (a *AIModelService) DownloadVLLMModel(repoURL string, modelName string) error {
+	if a.downloadLocation == "" {
+		return fmt.Errorf("download location not set")
+	}
+
+	// Parse HuggingFace URL
+	// Example: https://huggingface.co/openai/gpt-oss-120b
+	// or: https://huggingface.co/openai/gpt-oss-120b/tree/main
+	client, err := a.huggingFaceClient()
 	if err != nil {
 		return err
 	}
-
-	// Create cancel channel for this download
-	cancelChan := make(chan bool, 1)
-	a.cancelChannelMutex.Lock()
-	a.cancelChannels[modelName] = cancelChan
-	a.cancelChannelMutex.Unlock()
-
-	// Download with huggingface-cli or hf
-	// Use --local-dir-use-symlinks=False to download directly to target folder
-	args := []string{
-		"download",
-		repo,
-		"--include", pattern + "/*",
-		"--local-dir", destDir,
-		"--local-dir-use-symlinks", "False",
+	ref, err := client.parseHuggingFaceURL(repoURL)
+	if err != nil {
+		return err
 	}
+	// vLLM downloads from the repository root
+	ref.Path = ""
 
-	cmd := exec.Command(cliCmd, args...)
-
-	// Capture output for debugging
-	var stderr strings.Builder
-	cmd.Stderr = &stderr
+	tarGzPath, err := a.modelPath(ref, modelName+".tar.gz")
+	if err != nil {
+		return err
+	}
 
-	// Store in active downloads
-	a.downloadsMutex.Lock()
-	a.activeDownloads[modelName] = cmd
-	a.downloadsMutex.Unlock()
+	// Create temp directory for downloads (visible in Downloads folder)
+	tempDir := filepath.Join(filepath.Dir(tarGzPath), modelName+"_temp")
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	// The temp directory is kept until packaging succeeds so that a failed or
+	// cancelled download resumes from the files and partial files it holds
 
-	// Emit status before starting
-	wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
+	a.emit("ai-model-status", map[string]interface{}{
 		"modelName": modelName,
-		"status":    "Downloading files from HuggingFace...",
-		"progress":  20,
+		"status":    "Listing model files...",
+		"progress":  0,
 	})
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start download: %w", err)
-	}
-
-	// Monitor progress by checking directory size
-	progressDone := make(chan bool)
-	go a.monitorDownloadProgress(destDir, modelName, cancelChan, progressDone)
-
-	// Wait for completion or cancellation
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
-
-	select {
-	case <-cancelChan:
-		close(progressDone)
-		if cmd.Process != nil {
-			cmd.Process.Kill()
-		}
-		return fmt.Errorf("download cancelled")
-	case err := <-done:
-		close(progressDone)
-
-		a.downloadsMutex.Lock()
-		delete(a.activeDownloads, modelName)
-		a.downloadsMutex.Unlock()
-
-		a.cancelChannelMutex.Lock()
-		delete(a.cancelChannels, modelName)
-		a.cancelChannelMutex.Unlock()
+	cancelChan := a.registerCancel(modelName)
+	defer a.unregisterCancel(modelName)
+	ctx, cancel := watchCancel(cancelChan)
+	defer cancel()
 
-		if err != nil {
-			errMsg := stderr.String()
-			if errMsg != "" {
-				return fmt.Errorf("download failed: %w - %s", err, errMsg)
-			}
-			return fmt.Errorf("download failed: %w", err)
+	// Download safetensors, json, and jinja files (root level only)
+	files, err := client.listFiles(ctx, ref, false)
+	if err != nil {
+		if modelCancelled(ctx) {
+			// Don't return error for cancellation - it's expected behavior
+			return nil
 		}
+		return err
+	}
+	files = matchHuggingFaceFiles(files, []string{"*.safetensors", "*.json", "*.jinja"}, true)
+	if len(files) == 0 {
+		return fmt.Errorf("no model files found in %s", repoURL)
 	}
 
-	// After download completes, concatenate GGUF files if this is an Ollama model
-	if filePattern == "*.gguf" {
-		wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
-			"modelName": modelName,
-			"status":    "Concatenating GGUF files...",
-			"progress":  90,
-		})
-
-		if err := a.concatenateGGUFFiles(destDir, modelName); err != nil {
-			return fmt.Errorf("failed to concatenate GGUF files: %w", err)
+	if err := a.downloadHuggingFaceFiles(ctx, client, ref, files, tempDir, modelName); err != nil {
+		if errors.Is(err, errDownloadCancelled) {
+			// Don't return error for cancellation - it's expected behavior
+			return nil
 		}
+		return err
 	}
 
-	wailsruntime.EventsEmit(a.ctx, "ai-model-complete", map[string]interface{}{
+	a.emit("ai-model-status", map[string]interface{}{
 		"modelName": modelName,
-		"path":      destDir,
+		"status":    "Packaging model...",
+		"progress":  -1, // Keep the download progress
 	})
 
-	return nil
-}
+	// Package as tar.gz with files at root level
+	if err := a.packageVLLMModel(ctx, tempDir, tarGzPath); err != nil {
+		// Don't leave a half-written archive behind
+		os.Remove(tarGzPath)
 
-// downloadVLLMFiles downloads safetensors and config files for vLLM
-func (a *AIModelService) downloadVLLMFiles(repo string, destDir string, modelName string) error {
-	// Get the correct CLI command for the platform
-	cliCmd, err := getHuggingFaceCLI()
-	if err != nil {
+		// Check if this was a cancellation (not an error)
+		if errors.Is(err, errDownloadCancelled) {
+			// Don't return error for cancellation - it's expected behavior
+			return nil
+		}
 		return err
 	}
+	os.RemoveAll(tempDir) // Clean up temp directory
 
-	// Create cancel channel
-	cancelChan := make(chan bool, 1)
-	a.cancelChannelMutex.Lock()
-	a.cancelChannels[modelName] = cancelChan
-	a.cancelChannelMutex.Unlock()
-
-	// Download safetensors, json, and jinja files (root level only)
-	// Exclude ALL subdirectories - only get files from repository root
-	args := []string{
-		"download",
-		repo,
-		"--include", "*.safetensors",
-		"--include", "*.json",
-		"--include", "*.jinja",
-		"--exclude", "*/*",  // Exclude all files in subdirectories
-		"--local-dir", destDir,
-	}
-
-	cmd := exec.Command(cliCmd, args...)
-
-	// Capture output for debugging
-	var stderr strings.Builder
-	cmd.Stderr = &stderr
-
-	a.downloadsMutex.Lock()
-	a.activeDownloads[modelName] = cmd
-	a.downloadsMutex.Unlock()
-
-	// Emit status before starting
-	wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
+	a.emit("ai-model-complete", map[string]interface{}{
 		"modelName": modelName,
-		"status":    "Downloading model files from HuggingFace...",
-		"progress":  30,
+		"path":      tarGzPath,
 	})
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start download: %w", err)
-	}
-
-	// Monitor progress by checking directory size
-	progressDone := make(chan bool)
-	go a.monitorDownloadProgress(destDir, modelName, cancelChan, progressDone)
-
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Wait()
-	}()
+	return nil
+}
 
-	select {
-	case <-cancelChan:
-		close(progressDone)
-		if cmd.Process != nil {
-			cmd.Process.Kill()
+// downloadHuggingFaceFiles downloads repository files into destDir, keeping
+// their paths relative to the requested folder. It returns errDownloadCancelled
+// when ctx is cancelled through CancelModelDownload.
+func (a *AIModelService) downloadHuggingFaceFiles(ctx context.Context, client *hfClient, ref hfRepoRef, files []hfTreeEntry, destDir string, modelName string) error {
+	progress := newModelProgress(a, modelName, files)
+	for i, file := range files {
+		progress.startFile(i)
+		rel := file.Path
+		if ref.Path != "" {
+			rel = strings.TrimPrefix(rel, strings.TrimSuffix(ref.Path, "/")+"/")
 		}
-		return fmt.Errorf("download cancelled")
-	case err := <-done:
-		close(progressDone)
-		a.downloadsMutex.Lock()
-		delete(a.activeDownloads, modelName)
-		a.downloadsMutex.Unlock()
+		dest := filepath.Join(destDir, filepath.FromSlash(rel))
 
-		if err != nil {
-			errMsg := stderr.String()
-			if errMsg != "" {
-				return fmt.Errorf("download failed: %w - %s", err, errMsg)
+		if err := client.downloadFile(ctx, ref, file, dest, progress.update); err != nil {
+			if modelCancelled(ctx) {
+				return errDownloadCancelled
 			}
 			return fmt.Errorf("download failed: %w", err)
 		}
@@ -370,8 +309,9 @@ func (a *AIModelService) downloadVLLMFiles(repo string, destDir string, modelNam
 	return nil
 }
 
-// packageVLLMModel creates a tar.gz with files at root level
-func (a *AIModelService) packageVLLMModel(sourceDir string, tarGzPath string, modelName string, cancelChan chan bool) error {
+// packageVLLMModel creates a tar.gz with files at root level. It returns
+// errDownloadCancelled when ctx is cancelled.
+func (a *AIModelService) packageVLLMModel(ctx context.Context, sourceDir string, tarGzPath string) error {
 	// Create the tar.gz file
 	outFile, err := os.Create(tarGzPath)
 	if err != nil {
@@ -397,13 +337,9 @@ func (a *AIModelService) packageVLLMModel(sourceDir string, tarGzPath string, mo
 			return err
 		}
 
-		// Check for cancellation (handle nil channel)
-		if cancelChan != nil {
-			select {
-			case <-cancelChan:
-				return fmt.Errorf("packaging cancelled")
-			default:
-			}
+		// Check for cancellation
+		if ctx.Err() != nil {
+			return errDownloadCancelled
 		}
 
 		// Skip directories
@@ -445,12 +381,8 @@ func (a *AIModelService) packageVLLMModel(sourceDir string, tarGzPath string, mo
 		buf := make([]byte, 32*1024*1024)
 		for {
 			// Check for cancellation before each read
-			if cancelChan != nil {
-				select {
-				case <-cancelChan:
-					return fmt.Errorf("packaging cancelled")
-				default:
-				}
+			if ctx.Err() != nil {
+				return errDownloadCancelled
 			}
 
 			n, err := file.Read(buf)
@@ -477,93 +409,130 @@ func (a *AIModelService) packageVLLMModel(sourceDir string, tarGzPath string, mo
 	return nil
 }
 
-// monitorDownloadProgress monitors the download directory and emits progress updates
-func (a *AIModelService) monitorDownloadProgress(destDir string, modelName string, cancelChan chan bool, done chan bool) {
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+// modelProgress turns byte counts from the Hub client into ai-model-status
+// events with overall and per-file percentage, throughput and ETA
+type modelProgress struct {
+	a         *AIModelService
+	modelName string
+	files     []hfTreeEntry
+	total     int64
+
+	finished     int64 // Bytes of files that are complete
+	fileIndex    int
+	fileWritten  int64
+	fileComplete bool
+	sessionStart int64 // Bytes already on disk when this run started, excluded from throughput
+	started      time.Time
+	lastEmit     time.Time
+	lastBytes    int64
+	speed        float64 // Smoothed bytes per second
+}
+
+// newModelProgress creates a tracker for the given files
+func newModelProgress(a *AIModelService, modelName string, files []hfTreeEntry) *modelProgress {
+	p := &modelProgress{a: a, modelName: modelName, files: files, started: time.Now()}
+	for _, file := range files {
+		p.total += file.Size
+	}
+	p.sessionStart = -1
+	return p
+}
 
-	lastSize := int64(0)
-	noChangeTicks := 0
-	const maxNoChangeTicks = 3 // If no change for 3 seconds, might be between files
+// startFile moves the tracker to the next file
+func (p *modelProgress) startFile(index int) {
+	p.fileIndex = index
+	p.fileWritten = 0
+	p.fileComplete = false
+}
 
-	for {
-		select {
-		case <-done:
-			return
-		case <-cancelChan:
-			return
-		case <-ticker.C:
-			// Calculate directory size
-			currentSize := getDirSize(destDir)
-			sizeGB := float64(currentSize) / (1024 * 1024 * 1024)
-
-			// Check if size is changing
-			if currentSize > lastSize {
-				// Size is growing - calculate progress dynamically
-				// Use logarithmic scale for progress: 20% + (log growth)
-				// This provides smoother progress without knowing total size
-				sizeMB := float64(currentSize) / (1024 * 1024)
-
-				// Progress based on downloaded size (rough estimate)
-				// 100MB = ~25%, 500MB = ~40%, 1GB = ~50%, 5GB = ~65%, 10GB+ = ~80%
-				var progress int
-				if sizeMB < 100 {
-					progress = 20 + int(sizeMB/100*5) // 20-25%
-				} else if sizeMB < 500 {
-					progress = 25 + int((sizeMB-100)/400*15) // 25-40%
-				} else if sizeMB < 1024 {
-					progress = 40 + int((sizeMB-500)/524*10) // 40-50%
-				} else if sizeMB < 5120 {
-					progress = 50 + int((sizeMB-1024)/4096*15) // 50-65%
-				} else if sizeMB < 10240 {
-					progress = 65 + int((sizeMB-5120)/5120*15) // 65-80%
-				} else {
-					progress = 80 + int((sizeMB-10240)/10240*5) // 80-85% (cap)
-					if progress > 85 {
-						progress = 85
-					}
-				}
+// update records the bytes written to the current file and emits at most one
+// event per progressInterval, plus one when the file completes
+func (p *modelProgress) update(written int64) {
+	if p.fileComplete {
+		return
+	}
+	p.fileWritten = written
+	done := p.finished + written
+	if p.sessionStart < 0 {
+		// The first report includes bytes resumed from a partial file
+		p.sessionStart = done
+		p.lastBytes = done
+		p.lastEmit = time.Now()
+	}
 
-				wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
-					"modelName": modelName,
-					"status":    fmt.Sprintf("Downloading... (%.2f GB)", sizeGB),
-					"progress":  progress,
-				})
-
-				lastSize = currentSize
-				noChangeTicks = 0
-			} else if currentSize == lastSize && currentSize > 0 {
-				// No change but files exist - might be processing between files
-				noChangeTicks++
-				if noChangeTicks <= maxNoChangeTicks {
-					wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
-						"modelName": modelName,
-						"status":    fmt.Sprintf("Processing... (%.2f GB)", sizeGB),
-						"progress":  -1, // Keep current progress
-					})
-				}
-			}
+	fileDone := written >= p.files[p.fileIndex].Size
+	if fileDone {
+		p.fileComplete = true
+		p.finished += p.files[p.fileIndex].Size
+		p.fileWritten = p.files[p.fileIndex].Size
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(p.lastEmit)
+	if elapsed < progressInterval && !fileDone {
+		return
+	}
+
+	if elapsed > 0 && done > p.lastBytes {
+		instant := float64(done-p.lastBytes) / elapsed.Seconds()
+		if p.speed == 0 {
+			p.speed = instant
+		} else {
+			p.speed = 0.3*instant + 0.7*p.speed
 		}
 	}
+	p.lastEmit = now
+	p.lastBytes = done
+	p.emit(done)
 }
 
-// getDirSize calculates the total size of all files in a directory
-func getDirSize(path string) int64 {
-	var size int64
-	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if !info.IsDir() {
-			size += info.Size()
-		}
-		return nil
+// emit publishes the current progress
+func (p *modelProgress) emit(done int64) {
+	file := p.files[p.fileIndex]
+	fileName := path.Base(file.Path)
+
+	progress := 100.0
+	if p.total > 0 {
+		progress = float64(done) / float64(p.total) * 100
+	}
+	fileProgress := 100.0
+	if file.Size > 0 {
+		fileProgress = float64(p.fileWritten) / float64(file.Size) * 100
+	}
+
+	var eta, fileEta float64
+	status := fmt.Sprintf("Downloading %s (%d of %d): %s of %s", fileName, p.fileIndex+1, len(p.files), formatBytes(done), formatBytes(p.total))
+	if p.speed > 0 {
+		eta = float64(p.total-done) / p.speed
+		fileEta = float64(file.Size-p.fileWritten) / p.speed
+		status += fmt.Sprintf(" at %s/s, %s left", formatBytes(int64(p.speed)), formatETA(eta))
+	}
+
+	p.a.emit("ai-model-status", map[string]interface{}{
+		"modelName":      p.modelName,
+		"status":         status,
+		"progress":       progress,
+		"downloaded":     done,
+		"total":          p.total,
+		"speed":          p.speed,
+		"eta":            eta,
+		"file":           fileName,
+		"fileIndex":      p.fileIndex + 1,
+		"fileCount":      len(p.files),
+		"fileDownloaded": p.fileWritten,
+		"fileTotal":      file.Size,
+		"fileProgress":   fileProgress,
+		"fileEta":        fileEta,
 	})
-	return size
 }
 
-// concatenateGGUFFiles finds all GGUF part files and concatenates them into a single file
-func (a *AIModelService) concatenateGGUFFiles(destDir string, modelName string) error {
+// formatETA renders a number of seconds as a short duration
+func formatETA(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).Round(time.Second).String()
+}
+
+// mergeGGUFFiles finds gguf-split shards and merges each sharded model into a single file
+func (a *AIModelService) mergeGGUFFiles(destDir string, modelName string) error {
 	// Find all .gguf files in the directory
 	var ggufFiles []string
 	err := filepath.Walk(destDir, func(path string, info os.FileInfo, err error) error {
@@ -583,43 +552,37 @@ func (a *AIModelService) concatenateGGUFFiles(destDir string, modelName string)
 		return fmt.Errorf("no GGUF files found in %s", destDir)
 	}
 
-	// If there's only one file, we're done (no need to concatenate)
-	if len(ggufFiles) == 1 {
-		return nil
+	// Models that are not split are already complete
+	groups := ggufShardGroups(ggufFiles)
+	prefixes := make([]string, 0, len(groups))
+	for prefix := range groups {
+		prefixes = append(prefixes, prefix)
 	}
+	sort.Strings(prefixes)
 
-	// Sort files alphabetically to ensure correct order
-	sort.Strings(ggufFiles)
+	for _, prefix := range prefixes {
+		parts := groups[prefix]
 
-	// Create output file
-	outputPath := filepath.Join(destDir, modelName+".gguf")
-	outputFile, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
-	}
-	defer outputFile.Close()
+		outputPath := filepath.Join(destDir, prefix+".gguf")
+		if len(groups) == 1 {
+			outputPath = filepath.Join(destDir, modelName+".gguf")
+		}
 
-	// Concatenate all part files
-	for i, partFile := range ggufFiles {
-		wailsruntime.EventsEmit(a.ctx, "ai-model-status", map[string]interface{}{
-			"modelName": modelName,
-			"status":    fmt.Sprintf("Concatenating file %d of %d...", i+1, len(ggufFiles)),
-			"progress":  90 + (i * 5 / len(ggufFiles)),
+		err := mergeGGUFShards(parts, outputPath, func(index int, total int) {
+			a.emit("ai-model-status", map[string]interface{}{
+				"modelName": modelName,
+				"status":    fmt.Sprintf("Merging shard %d of %d...", index, total),
+				"progress":  -1,
+			})
 		})
-
-		partData, err := os.Open(partFile)
 		if err != nil {
-			return fmt.Errorf("failed to open part file %s: %w", partFile, err)
+			return fmt.Errorf("failed to merge %s: %w", prefix, err)
 		}
 
-		if _, err := io.Copy(outputFile, partData); err != nil {
-			partData.Close()
-			return fmt.Errorf("failed to concatenate file %s: %w", partFile, err)
+		// Delete the shards only once the merged file has been validated
+		for _, part := range parts {
+			os.Remove(part)
 		}
-		partData.Close()
-
-		// Delete the part file after concatenation
-		os.Remove(partFile)
 	}
 
 	return nil
@@ -635,10 +598,13 @@ func (a *AIModelService) CancelModelDownload(modelName string) error {
 		return fmt.Errorf("no active download found for model: %s", modelName)
 	}
 
-	// Signal cancellation
-	cancelChan <- true
+	// Signal cancellation; a cancellation already pending is enough
+	select {
+	case cancelChan <- true:
+	default:
+	}
 
-	wailsruntime.EventsEmit(a.ctx, "ai-model-cancelled", map[string]interface{}{
+	a.emit("ai-model-cancelled", map[string]interface{}{
 		"modelName": modelName,
 	})
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCancelModelDownloadWhileListing(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("HF_TOKEN", "")
+
+	// The Hub holds the listing open until the client gives up
+	listing := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listing <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("HF_ENDPOINT", srv.URL)
+
+	a := NewAIModelService()
+	a.events = func(string, map[string]interface{}) {}
+	a.SetDownloadLocation(t.TempDir())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.DownloadOllamaModel(srv.URL+"/owner/model-GGUF/tree/main/Q4_K_M", "model")
+	}()
+
+	select {
+	case <-listing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("model files were not listed")
+	}
+
+	// Repeated cancellations must not block once one is pending
+	cancelled := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			a.CancelModelDownload("model")
+		}
+		close(cancelled)
+	}()
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CancelModelDownload blocked")
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("cancelled download returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("listing was not cancelled")
+	}
+}
+
+func TestVLLMModelKeepsFilesUntilPackaged(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("HF_TOKEN", "")
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	failWeights := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		fail := failWeights
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/api/models/owner/model/tree/main":
+			w.Write([]byte(`[{"type":"file","path":"config.json","size":2},{"type":"file","path":"model.safetensors","size":4}]`))
+		case "/owner/model/resolve/main/config.json":
+			w.Write([]byte("{}"))
+		case "/owner/model/resolve/main/model.safetensors":
+			if fail {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("data"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("HF_ENDPOINT", srv.URL)
+
+	location := t.TempDir()
+	a := NewAIModelService()
+	a.events = func(string, map[string]interface{}) {}
+	a.SetDownloadLocation(location)
+
+	tempDir := filepath.Join(location, "model_temp")
+	if err := a.DownloadVLLMModel(srv.URL+"/owner/model", "model"); err == nil {
+		t.Fatal("expected the failed download to return an error")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "config.json")); err != nil {
+		t.Fatalf("downloaded file was removed after a failure: %v", err)
+	}
+
+	mu.Lock()
+	failWeights = false
+	mu.Unlock()
+	if err := a.DownloadVLLMModel(srv.URL+"/owner/model", "model"); err != nil {
+		t.Fatalf("DownloadVLLMModel: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(location, "model.tar.gz")); err != nil {
+		t.Errorf("archive missing: %v", err)
+	}
+	if _, err := os.Stat(tempDir); !os.IsNotExist(err) {
+		t.Errorf("temp directory kept after packaging: %v", err)
+	}
+	if n := requests["/owner/model/resolve/main/config.json"]; n != 1 {
+		t.Errorf("config.json downloaded %d times, want 1", n)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// apiRequestTimeout bounds a single API request attempt
+	apiRequestTimeout = 60 * time.Second
+	// apiMaxAttempts is how often a request is tried before giving up
+	apiMaxAttempts = 4
+	// apiMaxBackoff caps the wait between attempts, including Retry-After
+	apiMaxBackoff = 60 * time.Second
+	// apiMaxPages stops runaway pagination; a longer collection is an error
+	apiMaxPages = 1000
+)
+
+// linkNextPattern extracts the next page from a Link response header
+var linkNextPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// APIErrorKind classifies failed API requests
+type APIErrorKind string
+
+const (
+	APIErrorUnauthorized APIErrorKind = "unauthorized"  // Token missing, expired or lacking access
+	APIErrorEULARequired APIErrorKind = "eula_required" // The release EULA has not been accepted
+	APIErrorNotFound     APIErrorKind = "not_found"     // Product, release or file does not exist
+	APIErrorRateLimited  APIErrorKind = "rate_limited"  // Too many requests, still failing after retries
+	APIErrorServer       APIErrorKind = "server_error"  // 5xx response, still failing after retries
+	APIErrorRequest      APIErrorKind = "request_failed"
+)
+
+// APIError is returned for unsuccessful Broadcom API responses. Its message
+// starts with the kind, so the frontend can branch on it as well.
+type APIError struct {
+	Kind       APIErrorKind
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%s: %s (status %d)", e.Kind, e.Message, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+}
+
+// Is matches APIErrors of the same kind, so errors.Is(err, ErrNotFound) works
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Kind == e.Kind
+}
+
+// Sentinels for errors.Is
+var (
+	ErrUnauthorized = &APIError{Kind: APIErrorUnauthorized}
+	ErrEULARequired = &APIError{Kind: APIErrorEULARequired}
+	ErrNotFound     = &APIError{Kind: APIErrorNotFound}
+	ErrRateLimited  = &APIError{Kind: APIErrorRateLimited}
+)
+
+// errAPITokenNotSet is returned before any request is made without a token
+var errAPITokenNotSet = &APIError{Kind: APIErrorUnauthorized, Message: "API token not set"}
+
+// newAPIError builds a typed error from a failed response
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	message := strings.TrimSpace(string(body))
+
+	// The API usually answers with {"status": ..., "message": "..."}
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Message != "" {
+		message = payload.Message
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+
+	apiErr := &APIError{StatusCode: resp.StatusCode, Message: message}
+	switch {
+	case resp.StatusCode == http.StatusUnavailableForLegalReasons:
+		apiErr.Kind = APIErrorEULARequired
+	case resp.StatusCode == http.StatusForbidden && strings.Contains(strings.ToLower(message), "eula"):
+		apiErr.Kind = APIErrorEULARequired
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		apiErr.Kind = APIErrorUnauthorized
+	case resp.StatusCode == http.StatusNotFound:
+		apiErr.Kind = APIErrorNotFound
+	case resp.StatusCode == http.StatusTooManyRequests:
+		apiErr.Kind = APIErrorRateLimited
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	case resp.StatusCode >= 500:
+		apiErr.Kind = APIErrorServer
+		apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	default:
+		apiErr.Kind = APIErrorRequest
+	}
+	return apiErr
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(when); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// isRetryable reports whether a failed attempt is worth repeating
+func isRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind == APIErrorRateLimited || apiErr.Kind == APIErrorServer
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// backoff returns how long to wait before the given retry attempt (1-based)
+func backoff(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > apiMaxBackoff {
+			return apiMaxBackoff
+		}
+		return apiErr.RetryAfter
+	}
+
+	wait := time.Second << uint(attempt-1)
+	if wait > apiMaxBackoff {
+		wait = apiMaxBackoff
+	}
+	// Add up to 20% jitter so parallel downloads do not retry in lockstep
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+// requestContext returns the context API calls derive from
+func (b *BroadcomService) requestContext() context.Context {
+	if b.ctx != nil {
+		return b.ctx
+	}
+	return context.Background()
+}
+
+// apiDo sends an authenticated request to the API, retrying rate limits,
+// server errors and network failures with backoff. Unsuccessful responses are
+// returned as *APIError. A rejected access token is refreshed once. With
+// followRedirects false a 3xx response is returned to the caller. The caller
+// closes the response body; its context stays valid until then.
+func (b *BroadcomService) apiDo(ctx context.Context, method string, url string, followRedirects bool) (*http.Response, error) {
+	if b.currentToken() == "" {
+		return nil, b.missingTokenError()
+	}
+
+	client, err := b.createHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	if !followRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	for refreshed := false; ; refreshed = true {
+		accessToken, err := b.getAccessToken(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := b.sendWithRetry(ctx, client, func(ctx context.Context) (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, method, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			req.Header.Set("Accept", "application/json")
+			return req, nil
+		})
+		if err == nil || refreshed || !isAuthFailure(err) {
+			return resp, err
+		}
+
+		// The access token expired or was revoked early; exchange the refresh token again
+		b.invalidateAccessToken(accessToken)
+	}
+}
+
+// isAuthFailure reports whether the API rejected the credentials of a request
+func isAuthFailure(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized
+}
+
+// sendWithRetry sends the request built by newRequest, retrying rate limits,
+// server errors and network failures with backoff. Every attempt gets its own
+// timeout and a fresh request.
+func (b *BroadcomService) sendWithRetry(ctx context.Context, client *http.Client, newRequest func(ctx context.Context) (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; attempt <= apiMaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff(attempt-1, lastErr)):
+			}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, apiRequestTimeout)
+		req, err := newRequest(attemptCtx)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err == nil && resp.StatusCode >= 400 {
+			err = newAPIError(resp)
+			resp.Body.Close()
+		}
+		if err == nil {
+			resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		}
+		cancel()
+
+		lastErr = err
+		if !isRetryable(err) {
+			return nil, err
+		}
+	}
+	return nil, lastErr
+}
+
+// cancelOnClose releases a request context once the response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// apiJSON sends a request to an API path and decodes the JSON response into out.
+// out may be nil when the response body is not needed.
+func (b *BroadcomService) apiJSON(method string, path string, out interface{}) error {
+	resp, err := b.apiDo(b.requestContext(), method, b.apiBaseURL()+path, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// apiList fetches every page of a collection endpoint and returns the items
+// stored under key. The next page comes from the Link header or _links.next,
+// and is only followed on the API host, which gets the access token.
+func apiList[T any](b *BroadcomService, path string, key string) ([]T, error) {
+	items := []T{}
+	baseURL := b.apiBaseURL()
+	next := baseURL + path
+	for page := 0; next != ""; page++ {
+		if page == apiMaxPages {
+			return nil, fmt.Errorf("%s has more than %d pages", path, apiMaxPages)
+		}
+		if !sameOrigin(next, baseURL) {
+			return nil, fmt.Errorf("refusing to follow the next page of %s to %s: it is not on %s", path, next, baseURL)
+		}
+		current := next
+		resp, err := b.apiDo(b.requestContext(), http.MethodGet, current, true)
+		if err != nil {
+			return nil, err
+		}
+
+		var body map[string]json.RawMessage
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response from %s: %w", path, err)
+		}
+
+		var pageItems []T
+		if raw, exists := body[key]; exists {
+			if err := json.Unmarshal(raw, &pageItems); err != nil {
+				return nil, fmt.Errorf("failed to decode %s: %w", key, err)
+			}
+		}
+		items = append(items, pageItems...)
+
+		next = ""
+		if matches := linkNextPattern.FindStringSubmatch(resp.Header.Get("Link")); matches != nil {
+			next = matches[1]
+		} else if raw, exists := body["_links"]; exists {
+			var links struct {
+				Next struct {
+					Href string `json:"href"`
+				} `json:"next"`
+			}
+			if json.Unmarshal(raw, &links) == nil {
+				next = links.Next.Href
+			}
+		}
+		if strings.HasPrefix(next, "/") {
+			next = baseURL + next
+		}
+		if next == current {
+			break
+		}
+	}
+	return items, nil
+}
+
+// sameOrigin reports whether two URLs have the same scheme and host
+func sameOrigin(a string, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIListFollowsPages(t *testing.T) {
+	api := newFakeAPI(t)
+	all, err := api.b.ListProducts()
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	if len(all) < 2 {
+		t.Fatalf("fixtures list %d products, want several to page through", len(all))
+	}
+
+	api.fake.PageSize = 1
+	var pages int
+	api.before = func(r *http.Request) {
+		if r.URL.Path == "/api/v2/products" {
+			pages++
+		}
+	}
+	paged, err := api.b.ListProducts()
+	if err != nil {
+		t.Fatalf("ListProducts with one product per page: %v", err)
+	}
+	if pages != len(all) || len(paged) != len(all) {
+		t.Fatalf("read %d products in %d pages, want %d in %d", len(paged), pages, len(all), len(all))
+	}
+	for i := range all {
+		if paged[i].Slug != all[i].Slug {
+			t.Errorf("product %d is %s, want %s", i, paged[i].Slug, all[i].Slug)
+		}
+	}
+}
+
+func TestAPIListRefusesPagesOffTheAPIHost(t *testing.T) {
+	tests := []struct {
+		name string
+		next func(api *fakeAPI) string
+	}{
+		{name: "other host", next: func(*fakeAPI) string { return "http://attacker.example/api/v2/products?page=2" }},
+		{name: "other scheme", next: func(api *fakeAPI) string {
+			return strings.Replace(api.url, "http://", "https://", 1) + "/api/v2/products?page=2"
+		}},
+		{name: "other port", next: func(*fakeAPI) string { return "http://127.0.0.1:1/api/v2/products?page=2" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			next := tt.next(api)
+			api.handle = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path != "/api/v2/products" {
+					return false
+				}
+				fmt.Fprintf(w, `{"products": [], "_links": {"next": {"href": %q}}}`, next)
+				return true
+			}
+			if _, err := api.b.ListProducts(); err == nil || !strings.Contains(err.Error(), "refusing to follow") {
+				t.Errorf("ListProducts: got %v, want a refused next page", err)
+			}
+		})
+	}
+}
+
+func TestAPIListFailsAfterTooManyPages(t *testing.T) {
+	api := newFakeAPI(t)
+	api.handle = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/api/v2/products" {
+			return false
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		w.Header().Set("Link", fmt.Sprintf(`</api/v2/products?page=%d>; rel="next"`, page+1))
+		fmt.Fprint(w, `{"products": [{"slug": "p-rabbitmq"}]}`)
+		return true
+	}
+
+	products, err := api.b.ListProducts()
+	if err == nil || !strings.Contains(err.Error(), "more than") {
+		t.Errorf("ListProducts of an endless collection = %d products, %v; want an error", len(products), err)
+	}
+}
+
+func TestAPIRetriesHonourRetryAfter(t *testing.T) {
+	api := newFakeAPI(t)
+	var attempts []time.Time
+	api.handle = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/api/v2/products" {
+			return false
+		}
+		attempts = append(attempts, time.Now())
+		if len(attempts) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return true
+		}
+		return false
+	}
+
+	if _, err := api.b.ListProducts(); err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("made %d attempts, want 2", len(attempts))
+	}
+	if wait := attempts[1].Sub(attempts[0]); wait < time.Second {
+		t.Errorf("retried after %v, want at least the second the server asked for", wait)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{value: ""},
+		{value: "garbage"},
+		{value: "-5"},
+		{value: "0"},
+		{value: "7", wantMin: 7 * time.Second, wantMax: 7 * time.Second},
+		{value: " 7 ", wantMin: 7 * time.Second, wantMax: 7 * time.Second},
+		{value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat)},
+		{value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), wantMin: 58 * time.Second, wantMax: time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value); got < tt.wantMin || got > tt.wantMax {
+			t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.wantMin, tt.wantMax)
+		}
+	}
+
+	// The wait the server asks for replaces the backoff, up to its cap
+	if got := backoff(1, &APIError{Kind: APIErrorRateLimited, RetryAfter: 3 * time.Second}); got != 3*time.Second {
+		t.Errorf("backoff with Retry-After 3s = %v", got)
+	}
+	if got := backoff(1, &APIError{Kind: APIErrorRateLimited, RetryAfter: time.Hour}); got != apiMaxBackoff {
+		t.Errorf("backoff with Retry-After 1h = %v, want %v", got, apiMaxBackoff)
+	}
+}
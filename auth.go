@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAccessTokenLifetime is assumed when the access token does not carry an expiry
+	defaultAccessTokenLifetime = time.Hour
+	// accessTokenRefreshMargin renews access tokens shortly before they expire
+	accessTokenRefreshMargin = 5 * time.Minute
+)
+
+// accessTokenCache holds the short-lived access token exchanged for the
+// saved refresh token
+type accessTokenCache struct {
+	mu           sync.Mutex
+	refreshToken string // The refresh token the access token was issued for
+	baseURL      string // The API endpoint that issued it
+	accessToken  string
+	expiresAt    time.Time
+	exchange     *tokenExchange // Exchange in progress that other requests wait for, nil when none
+}
+
+// tokenExchange is an exchange of a refresh token at an API endpoint that has
+// not returned yet. done is closed once it has.
+type tokenExchange struct {
+	refreshToken string
+	baseURL      string
+	done         chan struct{}
+}
+
+// errTokenRevoked is returned when the API refuses to exchange the refresh token
+func errTokenRevoked(statusCode int) error {
+	return &APIError{
+		Kind:       APIErrorUnauthorized,
+		StatusCode: statusCode,
+		Message:    "API token is expired or revoked; generate a new one on the Broadcom Support Portal",
+	}
+}
+
+// tokenExpiry reads the exp claim of a JWT access token
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
+
+// exchangeRefreshToken trades a refresh token for an access token and its expiry
+// at the API endpoint baseURL
+func (b *BroadcomService) exchangeRefreshToken(ctx context.Context, client *http.Client, baseURL string, refreshToken string) (string, time.Time, error) {
+	body, err := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	resp, err := b.sendWithRetry(ctx, client, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/api/v2/authentication/access_tokens", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500 && apiErr.Kind != APIErrorRateLimited {
+			return "", time.Time{}, errTokenRevoked(apiErr.StatusCode)
+		}
+		return "", time.Time{}, fmt.Errorf("failed to exchange API token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to decode access token: %w", err)
+	}
+	if result.AccessToken == "" {
+		return "", time.Time{}, errTokenRevoked(resp.StatusCode)
+	}
+
+	expiresAt, ok := tokenExpiry(result.AccessToken)
+	if !ok {
+		if result.ExpiresIn > 0 {
+			expiresAt = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+		} else {
+			expiresAt = time.Now().Add(defaultAccessTokenLifetime)
+		}
+	}
+	return result.AccessToken, expiresAt, nil
+}
+
+// getAccessToken returns a cached access token for the saved refresh token,
+// exchanging it when there is none or it is about to expire
+func (b *BroadcomService) getAccessToken(ctx context.Context) (string, error) {
+	refreshToken := b.currentToken()
+	if refreshToken == "" {
+		return "", b.missingTokenError()
+	}
+	baseURL := b.apiBaseURL()
+
+	// The lock is not held during the exchange, so requests that find a valid
+	// token are not held up by it
+	cache := &b.accessTokens
+	cache.mu.Lock()
+	for {
+		if cache.refreshToken == refreshToken && cache.baseURL == baseURL && cache.accessToken != "" && time.Until(cache.expiresAt) > accessTokenRefreshMargin {
+			accessToken := cache.accessToken
+			cache.mu.Unlock()
+			return accessToken, nil
+		}
+		exchange := cache.exchange
+		if exchange == nil || exchange.refreshToken != refreshToken || exchange.baseURL != baseURL {
+			break
+		}
+
+		// Another request is exchanging the same token; use its result, or
+		// try again if it failed
+		cache.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-exchange.done:
+		}
+		cache.mu.Lock()
+	}
+	exchange := &tokenExchange{refreshToken: refreshToken, baseURL: baseURL, done: make(chan struct{})}
+	cache.exchange = exchange
+	cache.mu.Unlock()
+	defer close(exchange.done)
+
+	var accessToken string
+	var expiresAt time.Time
+	client, err := b.createHTTPClient()
+	if err == nil {
+		accessToken, expiresAt, err = b.exchangeRefreshToken(ctx, client, baseURL, refreshToken)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.exchange == exchange {
+		cache.exchange = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	cache.refreshToken = refreshToken
+	cache.baseURL = baseURL
+	cache.accessToken = accessToken
+	cache.expiresAt = expiresAt
+	return accessToken, nil
+}
+
+// invalidateAccessToken drops a cached access token the API rejected
+func (b *BroadcomService) invalidateAccessToken(accessToken string) {
+	cache := &b.accessTokens
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.accessToken == accessToken {
+		cache.accessToken = ""
+	}
+}
+
+// validateToken checks that a refresh token can be exchanged at the API
+// endpoint of the session. Only a rejected token is reported; network
+// failures are left to the next request.
+func (b *BroadcomService) validateToken(refreshToken string) error {
+	client, err := b.createHTTPClient()
+	if err != nil {
+		return err
+	}
+	return b.validateTokenAt(client, b.apiBaseURL(), refreshToken)
+}
+
+// validateTokenAt checks that a refresh token can be exchanged at baseURL
+// through client, which may carry the endpoint and connection settings of a
+// profile that is not selected
+func (b *BroadcomService) validateTokenAt(client *http.Client, baseURL string, refreshToken string) error {
+	ctx, cancel := context.WithTimeout(b.requestContext(), apiRequestTimeout)
+	defer cancel()
+
+	accessToken, expiresAt, err := b.exchangeRefreshToken(ctx, client, baseURL, refreshToken)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return err
+		}
+		fmt.Printf("Could not validate API token: %v\n", err)
+		return nil
+	}
+	if baseURL != b.apiBaseURL() {
+		// Only tokens of the session's endpoint are cached
+		return nil
+	}
+
+	cache := &b.accessTokens
+	cache.mu.Lock()
+	cache.refreshToken = refreshToken
+	cache.baseURL = baseURL
+	cache.accessToken = accessToken
+	cache.expiresAt = expiresAt
+	cache.mu.Unlock()
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,21 +11,39 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
-
-	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"time"
 )
 
+// defaultBaseURL is the Broadcom Support Portal API endpoint
+const defaultBaseURL = "https://network.tanzu.vmware.com"
+
+// baseURLEnv points a single run at another API endpoint, such as a mock server
+const baseURLEnv = "TILE_DOWNLOADER_BASE_URL"
+
 // BroadcomService handles interactions with the Broadcom Support Portal API
 type BroadcomService struct {
-	ctx               context.Context
-	apiToken          string
-	baseURL           string
-	activeDownloads   map[int]*exec.Cmd // Track active download processes by fileID
-	downloadsMutex    sync.Mutex        // Mutex to protect activeDownloads map
+	ctx             context.Context
+	apiToken        string                          // Refresh token of the selected profile, protected by sessionMutex
+	baseURL         string                          // API endpoint of the selected profile, protected by sessionMutex
+	activeDownloads map[int]*exec.Cmd               // Track active download processes by fileID
+	activeTransfers map[int]context.CancelCauseFunc // Track native downloads by fileID
+	omStopCauses    map[int]error                   // Why a killed om process was stopped, by fileID
+	pendingStops    map[int]error                   // Downloads being prepared by fileID, with the cause of a stop requested before their transfer started
+	downloadsMutex  sync.Mutex                      // Mutex to protect activeDownloads, activeTransfers, omStopCauses and pendingStops
+	historyMutex    sync.Mutex                      // Serializes access to the download history
+	inventoryMutex  sync.Mutex                      // Serializes access to the inventory hash cache
+	events          EventHandler                    // Receives events instead of the frontend in headless mode
+	queue           *downloadManager                // Backend-owned download queue
+	accessTokens    accessTokenCache                // Access token exchanged for apiToken
+	transports      transportCache                  // Transport for the proxy and TLS settings of the selected profile
+	sessionMutex    sync.Mutex                      // Protects apiToken, baseURL, passphrase, tokenLocked, profile, profileOverride and secretCache
+	passphrase      string                          // Unlocks the token in passphrase mode, kept in memory only
+	tokenLocked     bool                            // The saved token is waiting for its passphrase
+	profile         string                          // Name of the profile the token was loaded from
+	profileOverride string                          // Profile selected for this process only, instead of the saved one, protected by sessionMutex
+	secretCache     map[string]string               // Decrypted config values by ciphertext
 }
 
 // Product represents a Tanzu product
@@ -52,6 +71,9 @@ type ProductFile struct {
 	FileVersion  string `json:"file_version"`
 	MD5          string `json:"md5"`
 	SHA256       string `json:"sha256"`
+	Size         int64  `json:"size"`
+	LocalStatus  string `json:"local_status,omitempty"` // present, verified or stale when the file is already in the download location
+	LocalPath    string `json:"local_path,omitempty"`
 }
 
 // EULA represents an End User License Agreement
@@ -80,21 +102,38 @@ type DependencySpecifier struct {
 
 // NewBroadcomService creates a new Broadcom API service
 func NewBroadcomService() *BroadcomService {
-	return &BroadcomService{
-		baseURL:         "https://network.tanzu.vmware.com",
+	b := &BroadcomService{
+		baseURL:         defaultBaseURL,
 		activeDownloads: make(map[int]*exec.Cmd),
+		activeTransfers: make(map[int]context.CancelCauseFunc),
+		omStopCauses:    make(map[int]error),
+		pendingStops:    make(map[int]error),
 	}
+	b.queue = newDownloadManager(b)
+	return b
 }
 
-// startup initializes the service with context and loads saved token
+// startup initializes the service with context, loads saved token
+// and resumes the persisted download queue
 func (b *BroadcomService) startup(ctx context.Context) {
+	b.initialize(ctx)
+	b.queue.start()
+}
+
+// initialize sets the context and loads the saved token without
+// touching the download queue, for headless use
+func (b *BroadcomService) initialize(ctx context.Context) {
 	b.ctx = ctx
 	// Load saved token on startup
 	b.loadToken()
+
+	if insecure, _ := b.GetInsecureSkipVerify(); insecure {
+		warnInsecureTLS("all connections")
+	}
 }
 
-// getConfigPath returns the path to the config file
-func (b *BroadcomService) getConfigPath() (string, error) {
+// getConfigDir returns the configuration directory, creating it if needed
+func (b *BroadcomService) getConfigDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -103,54 +142,112 @@ func (b *BroadcomService) getConfigPath() (string, error) {
 	if err := os.MkdirAll(configDir, 0700); err != nil {
 		return "", err
 	}
+	return configDir, nil
+}
+
+// getConfigPath returns the path to the config file
+func (b *BroadcomService) getConfigPath() (string, error) {
+	configDir, err := b.getConfigDir()
+	if err != nil {
+		return "", err
+	}
 	return filepath.Join(configDir, "config.json"), nil
 }
 
-// Config represents the application configuration
+// Config represents the application configuration. The embedded Profile
+// holds the settings of the selected profile; on disk they live under profiles.
 type Config struct {
-	APIToken         string `json:"api_token"`
-	DownloadLocation string `json:"download_location"`
-	HTTPProxy        string `json:"http_proxy,omitempty"`
-	HTTPSProxy       string `json:"https_proxy,omitempty"`
+	APIToken string `json:"api_token,omitempty"` // Plaintext token of older versions, migrated on load
+	Profile
+	TokenProtection string `json:"token_protection,omitempty"`
+	DownloadEngine  string `json:"download_engine,omitempty"`
+	DownloadLayout  string `json:"download_layout,omitempty"` // Path template below the download location, flat when empty
+
+	MaxParallelDownloads int  `json:"max_parallel_downloads,omitempty"`
+	DownloadRetries      *int `json:"download_retries,omitempty"` // Automatic retries per failed download, defaultDownloadRetries when unset
+
+	BandwidthLimit  int64            `json:"bandwidth_limit,omitempty"`  // Bytes per second shared by all downloads, zero for unlimited
+	DownloadWindows []DownloadWindow `json:"download_windows,omitempty"` // Daily periods in which queued downloads run, any time when empty
+
+	CACertFiles        []string `json:"ca_cert_files,omitempty"`        // Extra PEM bundles trusted by all connections
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty"` // Disables TLS verification, logged once per component
+
+	ActiveProfile string             `json:"active_profile,omitempty"`
+	Profiles      map[string]Profile `json:"profiles,omitempty"`
+
+	profile string // Profile the embedded settings belong to
 }
 
-// loadConfig loads the configuration from disk
+// loadConfig loads the configuration from disk with the settings of the
+// selected profile in place
 func (b *BroadcomService) loadConfig() (*Config, error) {
 	configPath, err := b.getConfigPath()
 	if err != nil {
 		return nil, err
 	}
 
+	var config Config
 	data, err := os.ReadFile(configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Return default config
-			return b.getDefaultConfig(), nil
+	if err == nil {
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
 		}
+	} else if os.IsNotExist(err) {
+		// Start from the default config
+		config = *b.getDefaultConfig()
+	} else {
 		return nil, err
 	}
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+	// Older versions kept a single set of settings at the top level
+	if len(config.Profiles) == 0 {
+		config.Profiles = map[string]Profile{defaultProfileName: config.Profile}
 	}
+	if _, exists := config.Profiles[config.ActiveProfile]; !exists {
+		config.ActiveProfile = firstProfileName(config.Profiles)
+	}
+	config.profile = config.ActiveProfile
+	b.sessionMutex.Lock()
+	override := b.profileOverride
+	b.sessionMutex.Unlock()
+	if override != "" {
+		if _, exists := config.Profiles[override]; !exists {
+			return nil, fmt.Errorf("profile %q does not exist", override)
+		}
+		config.profile = override
+	}
+	config.Profile = config.Profiles[config.profile]
 
 	// Set defaults if not present
 	if config.DownloadLocation == "" {
 		config.DownloadLocation = b.getDefaultDownloadLocation()
 	}
+	if config.DownloadEngine == "" {
+		config.DownloadEngine = DownloadEngineNative
+	}
 
 	return &config, nil
 }
 
-// saveConfig saves the configuration to disk
+// saveConfig saves the configuration to disk, storing the embedded settings
+// in their profile
 func (b *BroadcomService) saveConfig(config *Config) error {
 	configPath, err := b.getConfigPath()
 	if err != nil {
 		return err
 	}
 
-	data, err := json.MarshalIndent(config, "", "  ")
+	stored := *config
+	stored.Profiles = make(map[string]Profile, len(config.Profiles)+1)
+	for name, profile := range config.Profiles {
+		stored.Profiles[name] = profile
+	}
+	if config.profile != "" {
+		stored.Profiles[config.profile] = config.Profile
+	}
+	stored.Profile = Profile{}
+
+	data, err := json.MarshalIndent(&stored, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -169,45 +266,142 @@ func (b *BroadcomService) getDefaultDownloadLocation() string {
 
 // getDefaultConfig returns default configuration
 func (b *BroadcomService) getDefaultConfig() *Config {
+	profile := Profile{DownloadLocation: b.getDefaultDownloadLocation()}
 	return &Config{
-		APIToken:         "",
-		DownloadLocation: b.getDefaultDownloadLocation(),
+		Profile:        profile,
+		DownloadEngine: DownloadEngineNative,
+		ActiveProfile:  defaultProfileName,
+		Profiles:       map[string]Profile{defaultProfileName: profile},
+		profile:        defaultProfileName,
 	}
 }
 
-// loadToken loads the API token from disk
+// loadToken loads and decrypts the API token of the selected profile. A
+// plaintext token from an older version is encrypted with the key file. A
+// passphrase-protected token is unlocked from TILE_DOWNLOADER_PASSPHRASE or
+// stays locked until UnlockToken.
 func (b *BroadcomService) loadToken() error {
 	config, err := b.loadConfig()
 	if err != nil {
 		return err
 	}
 
-	b.apiToken = config.APIToken
+	b.sessionMutex.Lock()
+	b.profile = config.profile
+	b.baseURL = resolveBaseURL(config)
+	b.apiToken = ""
+	passphrase := b.passphrase
+	b.sessionMutex.Unlock()
+
+	if config.APIToken != "" && config.EncryptedAPIToken == "" {
+		b.setToken(config.APIToken)
+		if err := b.saveToken(); err != nil {
+			return fmt.Errorf("failed to encrypt API token: %w", err)
+		}
+		return nil
+	}
+
+	protection := tokenProtection(config)
+	if protection == TokenProtectionPassphrase && passphrase == "" {
+		passphrase = os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			b.sessionMutex.Lock()
+			b.tokenLocked = config.EncryptedAPIToken != ""
+			b.sessionMutex.Unlock()
+			return nil
+		}
+	}
+
+	token, err := b.decryptSecret(config.EncryptedAPIToken, protection, passphrase)
+	if err != nil {
+		b.sessionMutex.Lock()
+		b.tokenLocked = protection == TokenProtectionPassphrase
+		b.sessionMutex.Unlock()
+		return fmt.Errorf("failed to decrypt API token: %w", err)
+	}
+
+	b.sessionMutex.Lock()
+	if protection == TokenProtectionPassphrase {
+		b.passphrase = passphrase
+	}
+	b.tokenLocked = false
+	b.apiToken = token
+	b.sessionMutex.Unlock()
 	return nil
 }
 
-// saveToken saves the API token to disk
+// saveToken encrypts the API token and saves it to disk
 func (b *BroadcomService) saveToken() error {
 	config, err := b.loadConfig()
 	if err != nil {
 		config = b.getDefaultConfig()
 	}
 
-	config.APIToken = b.apiToken
+	b.sessionMutex.Lock()
+	token := b.apiToken
+	passphrase := b.passphrase
+	b.sessionMutex.Unlock()
+
+	protection := tokenProtection(config)
+	if protection == TokenProtectionPassphrase && passphrase == "" {
+		// Without the passphrase the old token can only be replaced when no
+		// other profile still depends on it
+		for name, profile := range config.Profiles {
+			if (name != config.profile && profile.EncryptedAPIToken != "") || profile.EncryptedProxyPassword != "" {
+				return errTokenLocked
+			}
+		}
+		protection = TokenProtectionKeyFile
+	}
+
+	encrypted, err := b.encryptSecret(token, protection, passphrase)
+	if err != nil {
+		return err
+	}
+
+	b.sessionMutex.Lock()
+	b.tokenLocked = false
+	b.sessionMutex.Unlock()
+
+	config.APIToken = ""
+	config.TokenProtection = protection
+	config.EncryptedAPIToken = encrypted
 	return b.saveConfig(config)
 }
 
-// SetAPIToken sets the Broadcom API token and saves it to disk
+// SetAPIToken validates the Broadcom API refresh token and saves it to disk.
+// Expired or revoked tokens are rejected.
 func (b *BroadcomService) SetAPIToken(token string) error {
-	b.apiToken = token
+	token = strings.TrimSpace(token)
+	if token != "" {
+		if err := b.validateToken(token); err != nil {
+			return err
+		}
+	}
+
+	b.setToken(token)
 	return b.saveToken()
 }
 
 // GetAPIToken returns the current API token
 func (b *BroadcomService) GetAPIToken() string {
+	return b.currentToken()
+}
+
+// currentToken returns the API refresh token of the session
+func (b *BroadcomService) currentToken() string {
+	b.sessionMutex.Lock()
+	defer b.sessionMutex.Unlock()
 	return b.apiToken
 }
 
+// setToken replaces the API refresh token of the session without saving it
+func (b *BroadcomService) setToken(token string) {
+	b.sessionMutex.Lock()
+	defer b.sessionMutex.Unlock()
+	b.apiToken = token
+}
+
 // GetDownloadLocation returns the configured download location
 func (b *BroadcomService) GetDownloadLocation() (string, error) {
 	config, err := b.loadConfig()
@@ -245,7 +439,7 @@ func (b *BroadcomService) SetHTTPProxy(proxy string) error {
 	}
 
 	config.HTTPProxy = proxy
-	return b.saveConfig(config)
+	return b.saveConnectionConfig(config)
 }
 
 // GetHTTPSProxy returns the configured HTTPS proxy
@@ -265,259 +459,406 @@ func (b *BroadcomService) SetHTTPSProxy(proxy string) error {
 	}
 
 	config.HTTPSProxy = proxy
-	return b.saveConfig(config)
+	return b.saveConnectionConfig(config)
 }
 
-// createHTTPClient creates an HTTP client with proxy configuration if set
-func (b *BroadcomService) createHTTPClient() (*http.Client, error) {
-	config, err := b.loadConfig()
-	if err != nil {
-		// Return default client if config can't be loaded
-		return &http.Client{}, nil
+// resolveBaseURL returns the API endpoint from the environment, the profile
+// or the default, in that order
+func resolveBaseURL(config *Config) string {
+	if baseURL, err := normalizeBaseURL(os.Getenv(baseURLEnv)); err == nil && baseURL != "" {
+		return baseURL
+	}
+	if config.BaseURL != "" {
+		return config.BaseURL
 	}
+	return defaultBaseURL
+}
 
-	// If no proxy is configured, return default client
-	if config.HTTPProxy == "" && config.HTTPSProxy == "" {
-		return &http.Client{}, nil
+// normalizeBaseURL checks an API endpoint and strips its trailing slash
+func normalizeBaseURL(baseURL string) (string, error) {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		return "", nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid API base URL %q: expected http(s)://host[:port][/path]", baseURL)
 	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid API base URL %q: query strings are not supported", baseURL)
+	}
+	return baseURL, nil
+}
 
-	// Create a custom transport with proxy settings
-	transport := &http.Transport{
-		Proxy: func(req *http.Request) (*url.URL, error) {
-			var proxyURLStr string
-			if req.URL.Scheme == "https" && config.HTTPSProxy != "" {
-				proxyURLStr = config.HTTPSProxy
-			} else if req.URL.Scheme == "http" && config.HTTPProxy != "" {
-				proxyURLStr = config.HTTPProxy
-			}
+// apiBaseURL returns the API endpoint requests are sent to
+func (b *BroadcomService) apiBaseURL() string {
+	b.sessionMutex.Lock()
+	defer b.sessionMutex.Unlock()
+	return b.baseURL
+}
 
-			if proxyURLStr == "" {
-				return nil, nil
-			}
+// GetBaseURL returns the API endpoint of the active profile, which is the
+// Broadcom Support Portal unless a mirror is configured
+func (b *BroadcomService) GetBaseURL() string {
+	return b.apiBaseURL()
+}
 
-			return url.Parse(proxyURLStr)
-		},
+// SetBaseURL sets the API endpoint of the active profile, such as an internal
+// mirror. An empty URL restores the Broadcom Support Portal.
+func (b *BroadcomService) SetBaseURL(baseURL string) error {
+	baseURL, err := normalizeBaseURL(baseURL)
+	if err != nil {
+		return err
+	}
+	if baseURL == defaultBaseURL {
+		baseURL = ""
 	}
 
-	return &http.Client{Transport: transport}, nil
-}
-
-// setProxyEnv sets proxy environment variables for a command
-func (b *BroadcomService) setProxyEnv(cmd *exec.Cmd) error {
 	config, err := b.loadConfig()
 	if err != nil {
-		return nil // Not a fatal error
+		config = b.getDefaultConfig()
 	}
 
-	// Get existing environment variables
-	cmd.Env = os.Environ()
-
-	// Add proxy settings if configured
-	if config.HTTPProxy != "" {
-		cmd.Env = append(cmd.Env, "HTTP_PROXY="+config.HTTPProxy)
-		cmd.Env = append(cmd.Env, "http_proxy="+config.HTTPProxy)
-	}
-	if config.HTTPSProxy != "" {
-		cmd.Env = append(cmd.Env, "HTTPS_PROXY="+config.HTTPSProxy)
-		cmd.Env = append(cmd.Env, "https_proxy="+config.HTTPSProxy)
+	config.BaseURL = baseURL
+	if err := b.saveConfig(config); err != nil {
+		return err
 	}
 
+	b.sessionMutex.Lock()
+	b.baseURL = resolveBaseURL(config)
+	b.sessionMutex.Unlock()
 	return nil
 }
 
-// CancelDownload cancels an active download by killing the process
-func (b *BroadcomService) CancelDownload(fileID int) error {
-	b.downloadsMutex.Lock()
-	cmd, exists := b.activeDownloads[fileID]
-	if !exists {
-		b.downloadsMutex.Unlock()
-		return fmt.Errorf("no active download found for file ID %d", fileID)
+// GetDownloadEngine returns the configured download engine ("native" or "om")
+func (b *BroadcomService) GetDownloadEngine() (string, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return "", err
 	}
-	// Don't delete yet - let the Wait() handle cleanup
-	b.downloadsMutex.Unlock()
+	return config.DownloadEngine, nil
+}
 
-	// Kill the process
-	if cmd.Process != nil {
-		if err := cmd.Process.Kill(); err != nil {
-			return fmt.Errorf("failed to kill download process: %w", err)
-		}
+// SetDownloadEngine selects the download engine used for product files
+func (b *BroadcomService) SetDownloadEngine(engine string) error {
+	if engine != DownloadEngineNative && engine != DownloadEngineOM {
+		return fmt.Errorf("unknown download engine %q", engine)
 	}
 
-	// Emit cancellation event immediately
-	runtime.EventsEmit(b.ctx, "download-cancelled", map[string]interface{}{
-		"fileID": fileID,
-	})
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
 
-	return nil
+	config.DownloadEngine = engine
+	return b.saveConfig(config)
 }
 
-// ListProducts retrieves all available products from Broadcom
-func (b *BroadcomService) ListProducts() ([]Product, error) {
-	if b.apiToken == "" {
-		return nil, fmt.Errorf("API token not set")
-	}
-
-	req, err := http.NewRequest("GET", b.baseURL+"/api/v2/products", nil)
+// createHTTPClient creates an HTTP client with the proxy and TLS settings if set
+func (b *BroadcomService) createHTTPClient() (*http.Client, error) {
+	transport, err := b.createTransport("the Broadcom API")
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+b.apiToken)
-	req.Header.Set("Accept", "application/json")
+	// If nothing is configured, return default client
+	if transport == nil {
+		return &http.Client{}, nil
+	}
+	return &http.Client{Transport: transport}, nil
+}
 
-	client, err := b.createHTTPClient()
+// profileHTTPClient returns a client with the proxy and TLS settings of config,
+// which may belong to a profile that is not selected. Its transport is built
+// for the call and not shared.
+func (b *BroadcomService) profileHTTPClient(config *Config) (*http.Client, error) {
+	transport, err := b.buildTransport(config)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if config.InsecureSkipVerify {
+		warnInsecureTLS("the Broadcom API")
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	if transport == nil {
+		return &http.Client{}, nil
 	}
+	return &http.Client{Transport: transport}, nil
+}
 
-	var result struct {
-		Products []Product `json:"products"`
+// setProxyEnv sets proxy environment variables for a command, including
+// proxy credentials and NO_PROXY exclusions
+func (b *BroadcomService) setProxyEnv(cmd *exec.Cmd) error {
+	config, err := b.loadConfig()
+	if err != nil {
+		return nil // Not a fatal error
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	// Get existing environment variables
+	cmd.Env = os.Environ()
+
+	// Add proxy settings if configured
+	if config.HTTPProxy != "" {
+		proxyURL, err := b.proxyURLWithCredentials(config, config.HTTPProxy)
+		if err != nil {
+			return err
+		}
+		cmd.Env = append(cmd.Env, "HTTP_PROXY="+proxyURL)
+		cmd.Env = append(cmd.Env, "http_proxy="+proxyURL)
+	}
+	if config.HTTPSProxy != "" {
+		proxyURL, err := b.proxyURLWithCredentials(config, config.HTTPSProxy)
+		if err != nil {
+			return err
+		}
+		cmd.Env = append(cmd.Env, "HTTPS_PROXY="+proxyURL)
+		cmd.Env = append(cmd.Env, "https_proxy="+proxyURL)
+	}
+	if config.NoProxy != "" {
+		cmd.Env = append(cmd.Env, "NO_PROXY="+config.NoProxy)
+		cmd.Env = append(cmd.Env, "no_proxy="+config.NoProxy)
 	}
 
-	return result.Products, nil
+	return nil
 }
 
-// GetProductReleases retrieves all releases for a specific product
-func (b *BroadcomService) GetProductReleases(productSlug string) ([]Release, error) {
-	if b.apiToken == "" {
-		return nil, fmt.Errorf("API token not set")
-	}
+// omTokenVarsPrefix is the --vars-env prefix om reads the API token from
+const omTokenVarsPrefix = "TILE_DOWNLOADER_OM"
 
-	url := fmt.Sprintf("%s/api/v2/products/%s/releases", b.baseURL, productSlug)
-	req, err := http.NewRequest("GET", url, nil)
+// omDownloadCommand builds an om download-product command with proxy settings.
+// The API token is interpolated by om from a config file and an environment
+// variable, so it does not show up in the process list.
+func (b *BroadcomService) omDownloadCommand(omPath string, args ...string) (*exec.Cmd, error) {
+	configDir, err := b.getConfigDir()
 	if err != nil {
 		return nil, err
 	}
+	omConfigPath := filepath.Join(configDir, "om-download.yml")
+	omConfig := fmt.Sprintf("pivnet-api-token: ((pivnet_api_token))\npivnet-host: %s\n", b.apiBaseURL())
+	if err := os.WriteFile(omConfigPath, []byte(omConfig), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write om config: %w", err)
+	}
 
-	req.Header.Set("Authorization", "Bearer "+b.apiToken)
-	req.Header.Set("Accept", "application/json")
+	cmdArgs := append([]string{"download-product", "--config", omConfigPath, "--vars-env", omTokenVarsPrefix}, args...)
 
-	client, err := b.createHTTPClient()
+	// om only skips verification of the Pivnet connection through its own flag
+	insecure, err := b.GetInsecureSkipVerify()
 	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS settings: %w", err)
+	}
+	if insecure {
+		warnInsecureTLS("om downloads")
+		cmdArgs = append(cmdArgs, "--pivnet-disable-ssl")
+	}
+	cmd := exec.Command(omPath, cmdArgs...)
+
+	// Set proxy environment variables if configured
+	if err := b.setProxyEnv(cmd); err != nil {
 		return nil, err
 	}
-	resp, err := client.Do(req)
-	if err != nil {
+	if err := b.setTLSEnv(cmd); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
 	}
+	cmd.Env = append(cmd.Env, omTokenVarsPrefix+"_pivnet_api_token="+b.currentToken())
+	return cmd, nil
+}
 
-	var result struct {
-		Releases []Release `json:"releases"`
-	}
+// runOMDownload runs an om download command, reporting its progress as
+// download-progress events. A cancelled download is not an error.
+func (b *BroadcomService) runOMDownload(cmd *exec.Cmd, fileID int, name string, savePath string) error {
+	output := newOMOutputParser(func(progress omProgress) {
+		b.emitOMProgress(fileID, progress)
+	})
+	cmd.Stdout = io.Discard
+	cmd.Stderr = output
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+	// Start and register the process together so a stop cannot slip in between
+	b.downloadsMutex.Lock()
+	if cause := b.pendingStops[fileID]; cause != nil {
+		// Stopped while the download was being prepared
+		b.omStopCauses[fileID] = cause
+		b.downloadsMutex.Unlock()
+		return nil
+	}
+	if err := cmd.Start(); err != nil {
+		b.downloadsMutex.Unlock()
+		return fmt.Errorf("failed to start om command: %w", err)
 	}
 
-	return result.Releases, nil
-}
+	// Store in active downloads
+	b.activeDownloads[fileID] = cmd
+	delete(b.omStopCauses, fileID)
+	b.downloadsMutex.Unlock()
 
-// GetReleaseEULA retrieves the EULA for a specific release
-func (b *BroadcomService) GetReleaseEULA(productSlug string, releaseID int) (*EULA, error) {
-	if b.apiToken == "" {
-		return nil, fmt.Errorf("API token not set")
-	}
+	err := cmd.Wait()
+
+	// Remove from active downloads
+	b.downloadsMutex.Lock()
+	delete(b.activeDownloads, fileID)
+	b.downloadsMutex.Unlock()
 
-	url := fmt.Sprintf("%s/api/v2/products/%s/releases/%d", b.baseURL, productSlug, releaseID)
-	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, err
+		// If the process was killed (cancelled), don't emit completion or error
+		if strings.Contains(err.Error(), "killed") {
+			return nil
+		}
+		return &omDownloadError{Command: name, Err: err, Output: output.Tail()}
 	}
 
-	req.Header.Set("Authorization", "Bearer "+b.apiToken)
-	req.Header.Set("Accept", "application/json")
+	// Emit completion event
+	b.emit("download-complete", map[string]interface{}{
+		"fileID": fileID,
+		"path":   savePath,
+	})
 
-	client, err := b.createHTTPClient()
-	if err != nil {
-		return nil, err
+	return nil
+}
+
+// emitOMProgress sends a progress update parsed from om output as a
+// download-progress event and records it in the download queue
+func (b *BroadcomService) emitOMProgress(fileID int, progress omProgress) {
+	data := map[string]interface{}{
+		"fileID":   fileID,
+		"progress": progress.Percent,
+		"status":   "Downloading...",
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if progress.Total > 0 {
+		data["downloaded"] = progress.Downloaded
+		data["total"] = progress.Total
+		data["totalSize"] = progress.Total
+		b.queue.recordProgress(fileID, progress.Downloaded, progress.Total)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	if progress.BytesPerSecond > 0 {
+		data["speed"] = int64(progress.BytesPerSecond)
 	}
-
-	var result struct {
-		EULA *EULA `json:"eula"`
+	if progress.ETA > 0 {
+		data["eta"] = int64(progress.ETA.Seconds())
 	}
+	b.emit("download-progress", data)
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
+// CancelDownload cancels a download, killing the process or stopping the
+// native transfer, and removes it from the download queue. Unlike
+// PauseDownload, the partial file is discarded.
+func (b *BroadcomService) CancelDownload(fileID int) error {
+	m := b.queue
+	m.mu.Lock()
+	job, queued := m.jobs[fileID]
+	wasRunning := queued && job.State == JobRunning
+	if queued {
+		delete(m.jobs, fileID)
+		m.recordCancelled(job)
+		removePartialFile(job)
+		m.changed()
+		m.schedule()
+	}
+	m.mu.Unlock()
+
+	if !queued || wasRunning {
+		if err := b.stopDownload(fileID, errDownloadCancelled); err != nil && !queued {
+			return err
+		}
 	}
 
-	return result.EULA, nil
+	// Emit cancellation event immediately
+	b.emit("download-cancelled", map[string]interface{}{
+		"fileID": fileID,
+	})
+
+	return nil
 }
 
-// GetReleaseFiles retrieves all files for a specific release
-func (b *BroadcomService) GetReleaseFiles(productSlug string, releaseID int) ([]ProductFile, error) {
-	if b.apiToken == "" {
-		return nil, fmt.Errorf("API token not set")
+// stopDownload stops an active transfer or kills its om process. The cause
+// tells a native transfer whether to keep its partial file, and is reported
+// for an om download once its process has exited. A download that is still
+// accepting the EULA or looking up its file stops with the cause before its
+// transfer starts.
+func (b *BroadcomService) stopDownload(fileID int, cause error) error {
+	b.downloadsMutex.Lock()
+	if cancel, exists := b.activeTransfers[fileID]; exists {
+		b.downloadsMutex.Unlock()
+		cancel(cause)
+		return nil
 	}
 
-	url := fmt.Sprintf("%s/api/v2/products/%s/releases/%d/product_files", b.baseURL, productSlug, releaseID)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+	cmd, exists := b.activeDownloads[fileID]
+	if !exists {
+		if _, preparing := b.pendingStops[fileID]; preparing {
+			b.pendingStops[fileID] = cause
+			b.downloadsMutex.Unlock()
+			return nil
+		}
+		b.downloadsMutex.Unlock()
+		return fmt.Errorf("no active download found for file ID %d", fileID)
 	}
+	// Don't delete yet - let the Wait() handle cleanup
+	b.omStopCauses[fileID] = cause
+	b.downloadsMutex.Unlock()
 
-	req.Header.Set("Authorization", "Bearer "+b.apiToken)
-	req.Header.Set("Accept", "application/json")
-
-	client, err := b.createHTTPClient()
-	if err != nil {
-		return nil, err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	// Kill the process
+	if cmd.Process != nil {
+		if err := cmd.Process.Kill(); err != nil {
+			return fmt.Errorf("failed to kill download process: %w", err)
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	return nil
+}
+
+// beginPreparing makes a download stoppable before its transfer starts. The
+// returned function must be called once the download has finished.
+func (b *BroadcomService) beginPreparing(fileID int) func() {
+	b.downloadsMutex.Lock()
+	b.pendingStops[fileID] = nil
+	b.downloadsMutex.Unlock()
+
+	return func() {
+		b.downloadsMutex.Lock()
+		delete(b.pendingStops, fileID)
+		b.downloadsMutex.Unlock()
 	}
+}
+
+// pendingStop returns the cause of a stop requested while a download was
+// being prepared, or nil
+func (b *BroadcomService) pendingStop(fileID int) error {
+	b.downloadsMutex.Lock()
+	defer b.downloadsMutex.Unlock()
+	return b.pendingStops[fileID]
+}
+
+// ListProducts retrieves all available products from Broadcom
+func (b *BroadcomService) ListProducts() ([]Product, error) {
+	return apiList[Product](b, "/api/v2/products", "products")
+}
 
+// GetProductReleases retrieves all releases for a specific product
+func (b *BroadcomService) GetProductReleases(productSlug string) ([]Release, error) {
+	return apiList[Release](b, fmt.Sprintf("/api/v2/products/%s/releases", productSlug), "releases")
+}
+
+// GetReleaseEULA retrieves the EULA for a specific release
+func (b *BroadcomService) GetReleaseEULA(productSlug string, releaseID int) (*EULA, error) {
 	var result struct {
-		ProductFiles []ProductFile `json:"product_files"`
+		EULA *EULA `json:"eula"`
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := b.apiJSON(http.MethodGet, fmt.Sprintf("/api/v2/products/%s/releases/%d", productSlug, releaseID), &result); err != nil {
 		return nil, err
 	}
+	return result.EULA, nil
+}
 
-	return result.ProductFiles, nil
+// GetReleaseFiles retrieves all files for a specific release
+func (b *BroadcomService) GetReleaseFiles(productSlug string, releaseID int) ([]ProductFile, error) {
+	return apiList[ProductFile](b, fmt.Sprintf("/api/v2/products/%s/releases/%d/product_files", productSlug, releaseID), "product_files")
 }
 
 // DownloadStemcellWithOM downloads a stemcell using the OM CLI
 func (b *BroadcomService) DownloadStemcellWithOM(productSlug string, releaseVersion string, fileName string, awsObjectKey string, savePath string, fileID int) error {
-	if b.apiToken == "" {
-		return fmt.Errorf("API token not set")
+	if b.currentToken() == "" {
+		return b.missingTokenError()
 	}
 
 	// Get the bundled OM CLI path
@@ -536,7 +877,10 @@ func (b *BroadcomService) DownloadStemcellWithOM(productSlug string, releaseVers
 
 	// For stemcells, we need to extract the iaas from the filename
 	// Example: "bosh-stemcell-1.915-vsphere-esxi-ubuntu-jammy-go_agent.tgz"
-	stemcellIaas := "vsphere" // Default to vsphere
+	stemcellIaas := b.defaultIaaS() // Default to the profile's IaaS
+	if stemcellIaas == "gcp" {
+		stemcellIaas = "google"
+	}
 	lowerName := strings.ToLower(fileName)
 	if strings.Contains(lowerName, "vsphere") {
 		stemcellIaas = "vsphere"
@@ -554,150 +898,24 @@ func (b *BroadcomService) DownloadStemcellWithOM(productSlug string, releaseVers
 	// We create a glob pattern that will match the actual file
 	fileGlob := fmt.Sprintf("*%s*", stemcellIaas)
 
-	cmd := exec.Command(omPath,
-		"download-product",
-		"-t", b.apiToken,
+	// The API token reaches om through the environment, never the command line
+	cmd, err := b.omDownloadCommand(omPath,
 		"-p", productSlug,
 		"--product-version", releaseVersion,
 		"-f", fileGlob,
 		"-o", outputDir,
 	)
-
-	// Set proxy environment variables if configured
-	b.setProxyEnv(cmd)
-
-	// Create pipes for stdout and stderr
-	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start om command: %w", err)
-	}
-
-	// Store in active downloads
-	b.downloadsMutex.Lock()
-	b.activeDownloads[fileID] = cmd
-	b.downloadsMutex.Unlock()
-
-	// Read stdout (not used for progress but consume it)
-	go func() {
-		buffer := make([]byte, 1024)
-		for {
-			_, err := stdout.Read(buffer)
-			if err != nil {
-				break
-			}
-		}
-	}()
-
-	// Read stderr and parse progress (handle carriage returns)
-	var stderrOutput string
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				chunk := string(buf[:n])
-				stderrOutput += chunk
-
-				// Split by both newline and carriage return to handle progress updates
-				parts := strings.FieldsFunc(chunk, func(r rune) bool {
-					return r == '\n' || r == '\r'
-				})
-
-				for _, part := range parts {
-					if part == "" || !strings.Contains(part, "%") {
-						continue
-					}
-
-					// Pattern: " 211.14 MiB / 18.47 GiB [>------]   1.12% 02m56s"
-					// Extract: total size and percentage
-					reWithSize := regexp.MustCompile(`[\d.]+\s+([KMG]i?B)\s*/\s*([\d.]+)\s+([KMG]i?B)\s+\[.*?\]\s+([\d.]+)%`)
-					matches := reWithSize.FindStringSubmatch(part)
-
-					if len(matches) >= 5 {
-						totalSizeStr := matches[2]
-						unit := matches[3]
-						percentage, _ := strconv.ParseFloat(matches[4], 64)
-						totalSize, _ := strconv.ParseFloat(totalSizeStr, 64)
-
-						var totalBytes int64
-						switch unit {
-						case "GiB", "GB":
-							totalBytes = int64(totalSize * 1024 * 1024 * 1024)
-						case "MiB", "MB":
-							totalBytes = int64(totalSize * 1024 * 1024)
-						case "KiB", "KB":
-							totalBytes = int64(totalSize * 1024)
-						}
-
-						runtime.EventsEmit(b.ctx, "download-progress", map[string]interface{}{
-							"fileID":    fileID,
-							"progress":  percentage,
-							"totalSize": totalBytes,
-							"status":    "Downloading...",
-						})
-					} else {
-						// Fallback: extract percentage only
-						re := regexp.MustCompile(`([\d.]+)%`)
-						matches := re.FindStringSubmatch(part)
-						if len(matches) > 1 {
-							if percentage, err := strconv.ParseFloat(matches[1], 64); err == nil {
-								runtime.EventsEmit(b.ctx, "download-progress", map[string]interface{}{
-									"fileID":   fileID,
-									"progress": percentage,
-									"status":   "Downloading...",
-								})
-							}
-						}
-					}
-				}
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
-
-	// Wait for command to complete
-	err = cmd.Wait()
-
-	// Remove from active downloads
-	b.downloadsMutex.Lock()
-	delete(b.activeDownloads, fileID)
-	b.downloadsMutex.Unlock()
-
-	if err != nil {
-		// If the process was killed (cancelled), don't return an error
-		if err.Error() == "signal: killed" || strings.Contains(err.Error(), "killed") {
-			return nil // Cancellation is not an error
-		}
-		if stderrOutput != "" {
-			return fmt.Errorf("om download-product (stemcell) failed: %s\n%s", err, stderrOutput)
-		}
-		return fmt.Errorf("om download-product (stemcell) failed: %w", err)
-	}
 
-	// Emit completion event
-	runtime.EventsEmit(b.ctx, "download-complete", map[string]interface{}{
-		"fileID": fileID,
-		"path":   savePath,
-	})
-
-	return nil
+	return b.runOMDownload(cmd, fileID, "om download-product (stemcell)", savePath)
 }
 
 // DownloadOpsManagerWithOM downloads an Ops Manager file using the OM CLI
 func (b *BroadcomService) DownloadOpsManagerWithOM(productSlug string, releaseVersion string, fileName string, awsObjectKey string, savePath string, fileID int) error {
-	if b.apiToken == "" {
-		return fmt.Errorf("API token not set")
+	if b.currentToken() == "" {
+		return b.missingTokenError()
 	}
 
 	// Get the bundled OM CLI path
@@ -716,7 +934,7 @@ func (b *BroadcomService) DownloadOpsManagerWithOM(productSlug string, releaseVe
 
 	// For Ops Manager, extract the IaaS from the filename
 	// Example: "Tanzu Ops Manager for vSphere - 3.2.0"
-	opsManagerIaas := "vsphere" // Default to vsphere
+	opsManagerIaas := b.defaultIaaS() // Default to the profile's IaaS
 	lowerName := strings.ToLower(fileName)
 	if strings.Contains(lowerName, "vsphere") {
 		opsManagerIaas = "vsphere"
@@ -734,150 +952,24 @@ func (b *BroadcomService) DownloadOpsManagerWithOM(productSlug string, releaseVe
 	// Ops Manager uses a glob pattern based on the IaaS
 	fileGlob := fmt.Sprintf("*%s*", opsManagerIaas)
 
-	cmd := exec.Command(omPath,
-		"download-product",
-		"-t", b.apiToken,
+	// The API token reaches om through the environment, never the command line
+	cmd, err := b.omDownloadCommand(omPath,
 		"-p", productSlug,
 		"--product-version", releaseVersion,
 		"-f", fileGlob,
 		"-o", outputDir,
 	)
-
-	// Set proxy environment variables if configured
-	b.setProxyEnv(cmd)
-
-	// Create pipes for stdout and stderr
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return err
 	}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start om command: %w", err)
-	}
-
-	// Store in active downloads
-	b.downloadsMutex.Lock()
-	b.activeDownloads[fileID] = cmd
-	b.downloadsMutex.Unlock()
-
-	// Read stdout (not used for progress but consume it)
-	go func() {
-		buffer := make([]byte, 1024)
-		for {
-			_, err := stdout.Read(buffer)
-			if err != nil {
-				break
-			}
-		}
-	}()
-
-	// Read stderr and parse progress (handle carriage returns)
-	var stderrOutput string
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				chunk := string(buf[:n])
-				stderrOutput += chunk
-
-				// Split by both newline and carriage return to handle progress updates
-				parts := strings.FieldsFunc(chunk, func(r rune) bool {
-					return r == '\n' || r == '\r'
-				})
-
-				for _, part := range parts {
-					if part == "" || !strings.Contains(part, "%") {
-						continue
-					}
-
-					// Pattern: " 211.14 MiB / 18.47 GiB [>------]   1.12% 02m56s"
-					// Extract: total size and percentage
-					reWithSize := regexp.MustCompile(`[\d.]+\s+([KMG]i?B)\s*/\s*([\d.]+)\s+([KMG]i?B)\s+\[.*?\]\s+([\d.]+)%`)
-					matches := reWithSize.FindStringSubmatch(part)
-
-					if len(matches) >= 5 {
-						totalSizeStr := matches[2]
-						unit := matches[3]
-						percentage, _ := strconv.ParseFloat(matches[4], 64)
-						totalSize, _ := strconv.ParseFloat(totalSizeStr, 64)
-
-						var totalBytes int64
-						switch unit {
-						case "GiB", "GB":
-							totalBytes = int64(totalSize * 1024 * 1024 * 1024)
-						case "MiB", "MB":
-							totalBytes = int64(totalSize * 1024 * 1024)
-						case "KiB", "KB":
-							totalBytes = int64(totalSize * 1024)
-						}
-
-						runtime.EventsEmit(b.ctx, "download-progress", map[string]interface{}{
-							"fileID":    fileID,
-							"progress":  percentage,
-							"totalSize": totalBytes,
-							"status":    "Downloading...",
-						})
-					} else {
-						// Fallback: extract percentage only
-						re := regexp.MustCompile(`([\d.]+)%`)
-						matches := re.FindStringSubmatch(part)
-						if len(matches) > 1 {
-							if percentage, err := strconv.ParseFloat(matches[1], 64); err == nil {
-								runtime.EventsEmit(b.ctx, "download-progress", map[string]interface{}{
-									"fileID":   fileID,
-									"progress": percentage,
-									"status":   "Downloading...",
-								})
-							}
-						}
-					}
-				}
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
-
-	// Wait for command to complete
-	err = cmd.Wait()
-
-	// Remove from active downloads
-	b.downloadsMutex.Lock()
-	delete(b.activeDownloads, fileID)
-	b.downloadsMutex.Unlock()
-
-	if err != nil {
-		// If the process was killed (cancelled), don't return an error
-		if err.Error() == "signal: killed" || strings.Contains(err.Error(), "killed") {
-			return nil // Cancellation is not an error
-		}
-		if stderrOutput != "" {
-			return fmt.Errorf("om download-product (ops-manager) failed: %s\n%s", err, stderrOutput)
-		}
-		return fmt.Errorf("om download-product (ops-manager) failed: %w", err)
-	}
-
-	// Emit completion event
-	runtime.EventsEmit(b.ctx, "download-complete", map[string]interface{}{
-		"fileID": fileID,
-		"path":   savePath,
-	})
-
-	return nil
+	return b.runOMDownload(cmd, fileID, "om download-product (ops-manager)", savePath)
 }
 
 // DownloadFileWithOM downloads a product file using the OM CLI
 func (b *BroadcomService) DownloadFileWithOM(productSlug string, releaseVersion string, fileName string, awsObjectKey string, savePath string, fileID int) error {
-	if b.apiToken == "" {
-		return fmt.Errorf("API token not set")
+	if b.currentToken() == "" {
+		return b.missingTokenError()
 	}
 
 	// Get the bundled OM CLI path
@@ -912,294 +1004,213 @@ func (b *BroadcomService) DownloadFileWithOM(productSlug string, releaseVersion
 		fileGlob = "*.pivotal"
 	}
 
-	cmd := exec.Command(omPath,
-		"download-product",
-		"-t", b.apiToken,
+	// The API token reaches om through the environment, never the command line
+	cmd, err := b.omDownloadCommand(omPath,
 		"-p", productSlug,
 		"--product-version", releaseVersion,
 		"-f", fileGlob,
 		"-o", outputDir,
 	)
-
-	// Set proxy environment variables if configured
-	b.setProxyEnv(cmd)
-
-	// Create pipes for stdout and stderr
-	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
-	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start om command: %w", err)
-	}
-
-	// Store in active downloads
-	b.downloadsMutex.Lock()
-	b.activeDownloads[fileID] = cmd
-	b.downloadsMutex.Unlock()
-
-	// Read stdout (not used for progress but consume it)
-	go func() {
-		buffer := make([]byte, 1024)
-		for {
-			_, err := stdout.Read(buffer)
-			if err != nil {
-				break
-			}
-		}
-	}()
-
-	// Read stderr and parse progress (handle carriage returns)
-	var stderrOutput string
-	go func() {
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if n > 0 {
-				chunk := string(buf[:n])
-				stderrOutput += chunk
-
-				// Split by both newline and carriage return to handle progress updates
-				parts := strings.FieldsFunc(chunk, func(r rune) bool {
-					return r == '\n' || r == '\r'
-				})
-
-				for _, part := range parts {
-					if part == "" || !strings.Contains(part, "%") {
-						continue
-					}
-
-					// Pattern: " 211.14 MiB / 18.47 GiB [>------]   1.12% 02m56s"
-					// Extract: total size and percentage
-					reWithSize := regexp.MustCompile(`[\d.]+\s+([KMG]i?B)\s*/\s*([\d.]+)\s+([KMG]i?B)\s+\[.*?\]\s+([\d.]+)%`)
-					matches := reWithSize.FindStringSubmatch(part)
-
-					if len(matches) >= 5 {
-						totalSizeStr := matches[2]
-						unit := matches[3]
-						percentage, _ := strconv.ParseFloat(matches[4], 64)
-						totalSize, _ := strconv.ParseFloat(totalSizeStr, 64)
-
-						var totalBytes int64
-						switch unit {
-						case "GiB", "GB":
-							totalBytes = int64(totalSize * 1024 * 1024 * 1024)
-						case "MiB", "MB":
-							totalBytes = int64(totalSize * 1024 * 1024)
-						case "KiB", "KB":
-							totalBytes = int64(totalSize * 1024)
-						}
-
-						runtime.EventsEmit(b.ctx, "download-progress", map[string]interface{}{
-							"fileID":    fileID,
-							"progress":  percentage,
-							"totalSize": totalBytes,
-							"status":    "Downloading...",
-						})
-					} else {
-						// Fallback: extract percentage only
-						re := regexp.MustCompile(`([\d.]+)%`)
-						matches := re.FindStringSubmatch(part)
-						if len(matches) > 1 {
-							if percentage, err := strconv.ParseFloat(matches[1], 64); err == nil {
-								runtime.EventsEmit(b.ctx, "download-progress", map[string]interface{}{
-									"fileID":   fileID,
-									"progress": percentage,
-									"status":   "Downloading...",
-								})
-							}
-						}
-					}
-				}
-			}
-			if err != nil {
-				break
-			}
-		}
-	}()
-
-	// Wait for command to complete
-	err = cmd.Wait()
-
-	// Remove from active downloads and check if it was cancelled
-	b.downloadsMutex.Lock()
-	delete(b.activeDownloads, fileID)
-	b.downloadsMutex.Unlock()
-
-	if err != nil {
-		// If the process was killed (cancelled), don't emit completion or error
-		if err.Error() == "signal: killed" || strings.Contains(err.Error(), "killed") {
-			// Don't emit anything - cancellation event was already sent
-			return nil
-		}
-		if stderrOutput != "" {
-			return fmt.Errorf("om download-product failed: %s\n%s", err, stderrOutput)
-		}
-		return fmt.Errorf("om download-product failed: %w", err)
-	}
-
-	// Emit completion event only if not cancelled
-	runtime.EventsEmit(b.ctx, "download-complete", map[string]interface{}{
-		"fileID": fileID,
-		"path":   savePath,
-	})
 
-	return nil
+	return b.runOMDownload(cmd, fileID, "om download-product", savePath)
 }
 
 // GetReleaseDependencies retrieves all dependencies for a specific release
 func (b *BroadcomService) GetReleaseDependencies(productSlug string, releaseID int) ([]Dependency, error) {
-	if b.apiToken == "" {
-		return nil, fmt.Errorf("API token not set")
-	}
-
-	url := fmt.Sprintf("%s/api/v2/products/%s/releases/%d/dependencies", b.baseURL, productSlug, releaseID)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+b.apiToken)
-	req.Header.Set("Accept", "application/json")
-
-	client, err := b.createHTTPClient()
-	if err != nil {
-		return nil, err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
-	}
-
-	var result struct {
-		Dependencies []Dependency `json:"dependencies"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
-
-	return result.Dependencies, nil
+	return apiList[Dependency](b, fmt.Sprintf("/api/v2/products/%s/releases/%d/dependencies", productSlug, releaseID), "dependencies")
 }
 
 // GetReleaseDependencySpecifiers retrieves dependency version specifiers for a specific release
 func (b *BroadcomService) GetReleaseDependencySpecifiers(productSlug string, releaseID int) ([]DependencySpecifier, error) {
-	if b.apiToken == "" {
-		return nil, fmt.Errorf("API token not set")
-	}
+	return apiList[DependencySpecifier](b, fmt.Sprintf("/api/v2/products/%s/releases/%d/dependency_specifiers", productSlug, releaseID), "dependency_specifiers")
+}
 
-	url := fmt.Sprintf("%s/api/v2/products/%s/releases/%d/dependency_specifiers", b.baseURL, productSlug, releaseID)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
+// AcceptEULAAndDownload accepts the release EULA through the API and downloads a product file with progress tracking
+func (b *BroadcomService) AcceptEULAAndDownload(productSlug string, releaseID int, fileID int, savePath string) error {
+	return b.downloadNow(DownloadRequest{
+		ProductSlug: productSlug,
+		ReleaseID:   releaseID,
+		FileID:      fileID,
+		OutputDir:   savePath,
+	})
+}
 
-	req.Header.Set("Authorization", "Bearer "+b.apiToken)
-	req.Header.Set("Accept", "application/json")
+// downloadNow downloads a product file outside the queue and records the
+// outcome in the download history, including a failed version lookup
+func (b *BroadcomService) downloadNow(req DownloadRequest) error {
+	startedAt := time.Now()
 
-	client, err := b.createHTTPClient()
-	if err != nil {
-		return nil, err
+	// The history records the version, which the frontend does not pass
+	var (
+		path string
+		file *ProductFile
+		err  error
+	)
+	if req.Version == "" {
+		req.Version, err = b.releaseVersion(req.ProductSlug, req.ReleaseID)
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+	if err == nil {
+		path, file, err = b.acceptEULAAndDownload(req.ProductSlug, req.ReleaseID, req.Version, req.FileID, req.OutputDir)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	if errors.Is(err, errDownloadPaused) {
+		return nil // Only queued downloads can be paused
 	}
-
-	var result struct {
-		DependencySpecifiers []DependencySpecifier `json:"dependency_specifiers"`
+	b.recordHistory(newHistoryEntry(req, startedAt, path, file, err))
+	if errors.Is(err, errDownloadCancelled) {
+		return nil // Cancellation is not an error
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, err
-	}
-
-	return result.DependencySpecifiers, nil
+	return err
 }
 
-// AcceptEULAAndDownload accepts the EULA and downloads a product file with progress tracking
-func (b *BroadcomService) AcceptEULAAndDownload(productSlug string, releaseID int, fileID int, savePath string) error {
-	if b.apiToken == "" {
-		return fmt.Errorf("API token not set")
+// acceptEULAAndDownload implements AcceptEULAAndDownload and returns the path of the
+// downloaded file with its API record, or the cause passed to stopDownload when the
+// download was cancelled, paused or stopped by the end of a download window. A
+// failed transfer returns the path of the partial file it left behind.
+// The release version is looked up when releaseVersion is empty.
+func (b *BroadcomService) acceptEULAAndDownload(productSlug string, releaseID int, releaseVersion string, fileID int, savePath string) (string, *ProductFile, error) {
+	if b.currentToken() == "" {
+		return "", nil, b.missingTokenError()
 	}
 
 	// Ensure the download directory exists
 	if err := os.MkdirAll(savePath, 0755); err != nil {
-		return fmt.Errorf("failed to create download directory: %w", err)
+		return "", nil, fmt.Errorf("failed to create download directory: %w", err)
 	}
 
-	// Get the release version and file name first
-	releases, err := b.GetProductReleases(productSlug)
-	if err != nil {
-		return fmt.Errorf("failed to get releases: %w", err)
-	}
+	// Pausing, cancelling or deferring the download takes effect between
+	// the API calls that come before the transfer
+	defer b.beginPreparing(fileID)()
 
-	var releaseVersion string
-	for _, rel := range releases {
-		if rel.ID == releaseID {
-			releaseVersion = rel.Version
-			break
-		}
+	// Downloads of a release fail until its EULA has been accepted
+	if err := b.AcceptEULA(productSlug, releaseID); err != nil {
+		return "", nil, fmt.Errorf("failed to accept EULA: %w", err)
+	}
+	if err := b.pendingStop(fileID); err != nil {
+		return "", nil, err
 	}
 
+	// Get the release version when the request does not carry it
 	if releaseVersion == "" {
-		return fmt.Errorf("could not find release version for ID %d", releaseID)
+		version, err := b.releaseVersion(productSlug, releaseID)
+		if err != nil {
+			return "", nil, err
+		}
+		releaseVersion = version
+		if err := b.pendingStop(fileID); err != nil {
+			return "", nil, err
+		}
 	}
 
 	// Get file details
 	files, err := b.GetReleaseFiles(productSlug, releaseID)
 	if err != nil {
-		return fmt.Errorf("failed to get files: %w", err)
+		return "", nil, fmt.Errorf("failed to get files: %w", err)
+	}
+	if err := b.pendingStop(fileID); err != nil {
+		return "", nil, err
 	}
 
-	var fileName string
-	var awsObjectKey string
-	for _, file := range files {
-		if file.ID == fileID {
-			fileName = file.Name
-			awsObjectKey = file.AWSObjectKey
+	var productFile *ProductFile
+	for i := range files {
+		if files[i].ID == fileID {
+			productFile = &files[i]
 			break
 		}
 	}
 
-	if fileName == "" {
-		return fmt.Errorf("could not find file name for ID %d", fileID)
+	if productFile == nil || productFile.Name == "" {
+		return "", nil, fmt.Errorf("could not find file name for ID %d", fileID)
 	}
+	fileName := productFile.Name
+	awsObjectKey := productFile.AWSObjectKey
+
+	destPath, err := b.productFilePath(savePath, productSlug, releaseVersion, *productFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The native downloader handles every file type the same way
+	engine, _ := b.GetDownloadEngine()
+	if engine != DownloadEngineOM {
+		path, err := b.downloadProductFile(productSlug, releaseID, *productFile, destPath)
+		if errors.Is(err, ErrEULARequired) {
+			// The recorded acceptance is no longer honoured; accept once more
+			if err := b.reacceptEULA(productSlug, releaseID); err != nil {
+				return "", nil, fmt.Errorf("failed to accept EULA: %w", err)
+			}
+			path, err = b.downloadProductFile(productSlug, releaseID, *productFile, destPath)
+		}
+		return path, productFile, err
+	}
+
+	// om names the file after its object key, in the directory of the layout
+	omDir := filepath.Dir(destPath)
 
 	// Check if this is a stemcell product (different download command)
 	// Stemcells have 'stemcell' in the product slug
 	isStemcell := strings.Contains(strings.ToLower(productSlug), "stemcell")
 
-	if isStemcell {
-		return b.DownloadStemcellWithOM(productSlug, releaseVersion, fileName, awsObjectKey, savePath, fileID)
-	}
-
 	// Check if this is an Ops Manager product
 	// Ops Manager products have 'ops-manager' in the slug or file type
 	isOpsManager := strings.Contains(strings.ToLower(productSlug), "ops-manager")
 
-	if isOpsManager {
-		return b.DownloadOpsManagerWithOM(productSlug, releaseVersion, fileName, awsObjectKey, savePath, fileID)
+	if isStemcell {
+		err = b.DownloadStemcellWithOM(productSlug, releaseVersion, fileName, awsObjectKey, omDir, fileID)
+	} else if isOpsManager {
+		err = b.DownloadOpsManagerWithOM(productSlug, releaseVersion, fileName, awsObjectKey, omDir, fileID)
+	} else {
+		// Use OM CLI to download regular products (tiles)
+		err = b.DownloadFileWithOM(productSlug, releaseVersion, fileName, awsObjectKey, omDir, fileID)
+	}
+	if err != nil {
+		return "", nil, err
 	}
 
-	// Use OM CLI to download regular products (tiles)
-	return b.DownloadFileWithOM(productSlug, releaseVersion, fileName, awsObjectKey, savePath, fileID)
+	// A stopped om download leaves no file behind to verify
+	downloadedPath := filepath.Join(omDir, productFileName(*productFile))
+	if _, err := os.Stat(downloadedPath); err != nil {
+		return "", nil, b.omStopCause(fileID)
+	}
+	if downloadedPath != destPath {
+		if err := os.Rename(downloadedPath, destPath); err != nil {
+			return "", nil, fmt.Errorf("failed to finalize download: %w", err)
+		}
+	}
+	if err := b.verifyDownload(destPath, *productFile); err != nil {
+		return "", nil, err
+	}
+	return destPath, productFile, nil
+}
+
+// omStopCause returns why the om process of a download was killed:
+// errDownloadPaused, errDownloadWindowClosed or errDownloadCancelled. om
+// keeps no partial file, so a paused or deferred job starts over when resumed.
+func (b *BroadcomService) omStopCause(fileID int) error {
+	b.downloadsMutex.Lock()
+	defer b.downloadsMutex.Unlock()
+
+	cause, exists := b.omStopCauses[fileID]
+	delete(b.omStopCauses, fileID)
+	if !exists || cause == nil {
+		return errDownloadCancelled
+	}
+	return cause
+}
+
+// releaseVersion looks up the version of a release by its ID
+func (b *BroadcomService) releaseVersion(productSlug string, releaseID int) (string, error) {
+	releases, err := b.GetProductReleases(productSlug)
+	if err != nil {
+		return "", fmt.Errorf("failed to get releases: %w", err)
+	}
+
+	for _, rel := range releases {
+		if rel.ID == releaseID {
+			return rel.Version, nil
+		}
+	}
+	return "", fmt.Errorf("could not find release version for ID %d", releaseID)
 }
@@ -0,0 +1,910 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tanzu-downloader/fakepivnet"
+)
+
+// fakeAPI is a BroadcomService pointed at a fake Pivnet API
+type fakeAPI struct {
+	b    *BroadcomService
+	fake *fakepivnet.Server
+	url  string
+
+	mu     sync.Mutex
+	ranges []string                                      // Range headers of object store requests
+	before func(*http.Request)                           // Runs before the fake handles a request, when set
+	status func(*http.Request) int                       // Answers a request with this status instead of the fake when nonzero
+	handle func(http.ResponseWriter, *http.Request) bool // Answers a request instead of the fake when it returns true
+}
+
+// newFakeAPI starts fakepivnet with the bundled fixtures and signs a service
+// in to it, with its configuration in a temporary home directory
+func newFakeAPI(t *testing.T) *fakeAPI {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(baseURLEnv, "")
+
+	api := &fakeAPI{fake: fakepivnet.New(nil)}
+	api.fake.RefreshToken = "fake-refresh-token"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			api.mu.Lock()
+			api.ranges = append(api.ranges, r.Header.Get("Range"))
+			api.mu.Unlock()
+		}
+		api.mu.Lock()
+		before, status, handle := api.before, api.status, api.handle
+		api.mu.Unlock()
+		if before != nil {
+			before(r)
+		}
+		if status != nil {
+			if code := status(r); code != 0 {
+				http.Error(w, http.StatusText(code), code)
+				return
+			}
+		}
+		if handle != nil && handle(w, r) {
+			return
+		}
+		api.fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	api.url = srv.URL
+
+	api.b = NewBroadcomService()
+	api.b.events = func(string, map[string]interface{}) {}
+	if err := api.b.SetBaseURL(srv.URL); err != nil {
+		t.Fatalf("SetBaseURL: %v", err)
+	}
+	if err := api.b.SetAPIToken("fake-refresh-token"); err != nil {
+		t.Fatalf("SetAPIToken: %v", err)
+	}
+	return api
+}
+
+func TestGetReleaseFiles(t *testing.T) {
+	api := newFakeAPI(t)
+
+	files, err := api.b.GetReleaseFiles("p-rabbitmq", 4001)
+	if err != nil {
+		t.Fatalf("GetReleaseFiles: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d files, want 1", len(files))
+	}
+	file := files[0]
+	if file.ID != 40011 || productFileName(file) != "p-rabbitmq-10.0.3-build.12.pivotal" {
+		t.Errorf("got file %d %q, want 40011 p-rabbitmq-10.0.3-build.12.pivotal", file.ID, productFileName(file))
+	}
+	if file.SHA256 == "" || file.Size == 0 {
+		t.Errorf("file has no checksum or size: %+v", file)
+	}
+
+	if _, err := api.b.GetReleaseFiles("p-rabbitmq", 9999); err == nil {
+		t.Error("GetReleaseFiles of an unknown release succeeded")
+	}
+}
+
+func TestAcceptEULA(t *testing.T) {
+	api := newFakeAPI(t)
+
+	if api.fake.EULAAccepted("p-rabbitmq", 4001) {
+		t.Fatal("EULA accepted before AcceptEULA")
+	}
+	if err := api.b.AcceptEULA("p-rabbitmq", 4001); err != nil {
+		t.Fatalf("AcceptEULA: %v", err)
+	}
+	if !api.fake.EULAAccepted("p-rabbitmq", 4001) {
+		t.Error("EULA not accepted after AcceptEULA")
+	}
+	if api.fake.EULAAccepted("p-rabbitmq", 4002) {
+		t.Error("EULA of another release accepted")
+	}
+}
+
+func TestDownloadProductFileResumesPartialFile(t *testing.T) {
+	api := newFakeAPI(t)
+	const name = "p-rabbitmq-10.0.3-build.12.pivotal"
+
+	// Fetch the content the object store serves to seed a partial download
+	resp, err := http.Get(api.url + "/object-store/p-rabbitmq/4001/40011/" + name)
+	if err != nil {
+		t.Fatalf("fetching object: %v", err)
+	}
+	want, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(want) < 2 {
+		t.Fatalf("fetching object: %d bytes, %v", len(want), err)
+	}
+
+	dir := t.TempDir()
+	destPath := filepath.Join(dir, name)
+	half := len(want) / 2
+	if err := os.WriteFile(destPath+partialSuffix, want[:half], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.b.AcceptEULA("p-rabbitmq", 4001); err != nil {
+		t.Fatalf("AcceptEULA: %v", err)
+	}
+	if err := api.b.DownloadProductFile("p-rabbitmq", 4001, 40011, dir); err != nil {
+		t.Fatalf("DownloadProductFile: %v", err)
+	}
+
+	got, err := os.ReadFile(destPath)
+	if err != nil {
+		t.Fatalf("reading download: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("downloaded %q, want %q", got, want)
+	}
+	if _, err := os.Stat(destPath + partialSuffix); !os.IsNotExist(err) {
+		t.Errorf("partial file still exists: %v", err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	wantRange := "bytes=" + strconv.Itoa(half) + "-"
+	if len(api.ranges) != 1 || api.ranges[0] != wantRange {
+		t.Errorf("got Range headers %q, want [%q]", api.ranges, wantRange)
+	}
+}
+
+func TestFetchToFileChecksContentRange(t *testing.T) {
+	const content = "0123456789"
+
+	tests := []struct {
+		name    string
+		partial string
+		serve   func(w http.ResponseWriter, start int)
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "resumes from the partial file",
+			partial: "01234",
+			serve:   serveRange(content),
+			want:    content,
+		},
+		{
+			name:    "content range at another offset",
+			partial: "01234",
+			serve: func(w http.ResponseWriter, start int) {
+				if start >= 0 {
+					w.Header().Set("Content-Range", "bytes 2-9/10")
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write([]byte(content[2:]))
+					return
+				}
+				w.Write([]byte(content))
+			},
+			want: content,
+		},
+		{
+			name:    "partial content without a content range",
+			partial: "01234",
+			serve: func(w http.ResponseWriter, start int) {
+				if start >= 0 {
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write([]byte(content[start:]))
+					return
+				}
+				w.Write([]byte(content))
+			},
+			want: content,
+		},
+		{
+			name: "short body",
+			serve: func(w http.ResponseWriter, start int) {
+				w.Write([]byte(content[:8]))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			b := NewBroadcomService()
+			b.events = func(string, map[string]interface{}) {}
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start := -1
+				if rng := r.Header.Get("Range"); rng != "" {
+					start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+				}
+				tt.serve(w, start)
+			}))
+			t.Cleanup(srv.Close)
+
+			partPath := filepath.Join(t.TempDir(), "tile.pivotal"+partialSuffix)
+			if tt.partial != "" {
+				if err := os.WriteFile(partPath, []byte(tt.partial), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := b.fetchToFile(context.Background(), srv.URL, partPath, ProductFile{ID: 1, Size: int64(len(content))})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchToFile: %v", err)
+			}
+			data, err := os.ReadFile(partPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadProductFileRequiresEULA(t *testing.T) {
+	api := newFakeAPI(t)
+
+	if err := api.b.DownloadProductFile("p-rabbitmq", 4001, 40011, t.TempDir()); err == nil {
+		t.Fatal("download succeeded without accepting the EULA")
+	}
+	if downloads := api.fake.Downloads(); len(downloads) != 0 {
+		t.Errorf("object store served %q without an accepted EULA", downloads)
+	}
+}
+
+func TestPlanDownloads(t *testing.T) {
+	tests := []struct {
+		name        string
+		runtimeType string
+		want        map[string]string // Product slug to version
+		wantFile    map[string]int    // Product slug to a file ID in the plan
+	}{
+		{
+			name:        "full runtime",
+			runtimeType: RuntimeTypeFull,
+			want: map[string]string{
+				"ops-manager":            "3.1.2",
+				"elastic-runtime":        "6.0.5",
+				"p-rabbitmq":             "10.0.3",
+				"pivotal-mysql":          "3.3.2",
+				"stemcells-ubuntu-jammy": "1.820",
+			},
+			wantFile: map[string]int{"ops-manager": 10011, "elastic-runtime": 20011},
+		},
+		{
+			name:        "small footprint runtime",
+			runtimeType: RuntimeTypeSmallFootprint,
+			want: map[string]string{
+				"ops-manager":     "3.1.2",
+				"elastic-runtime": "6.0.5",
+				"p-rabbitmq":      "10.0.3",
+			},
+			wantFile: map[string]int{"elastic-runtime": 20012},
+		},
+	}
+
+	api := newFakeAPI(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := api.b.PlanDownloads("3.1.2", "6.0.5", tt.runtimeType)
+			if err != nil {
+				t.Fatalf("PlanDownloads: %v", err)
+			}
+
+			planned := make(map[string]PlannedProduct)
+			for _, product := range plan.Products {
+				planned[product.ProductSlug] = product
+			}
+			for slug, version := range tt.want {
+				product, ok := planned[slug]
+				if !ok {
+					t.Errorf("%s missing from plan", slug)
+					continue
+				}
+				if product.Version != version {
+					t.Errorf("%s planned at %s, want %s", slug, product.Version, version)
+				}
+			}
+			for slug, fileID := range tt.wantFile {
+				found := false
+				for _, file := range planned[slug].Files {
+					found = found || file.ID == fileID
+				}
+				if !found {
+					t.Errorf("%s plan lacks file %d", slug, fileID)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanDownloadsDependencyErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		wantErr     error
+		wantWarning string
+	}{
+		{
+			name:        "release skipped",
+			status:      http.StatusNotFound,
+			wantWarning: "Skipped RabbitMQ 10.0.3",
+		},
+		{
+			name:    "token rejected",
+			status:  http.StatusUnauthorized,
+			wantErr: ErrUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+			api.mu.Lock()
+			api.status = func(r *http.Request) int {
+				if strings.HasSuffix(r.URL.Path, "/p-rabbitmq/releases/4001/dependency_specifiers") {
+					return tt.status
+				}
+				return 0
+			}
+			api.mu.Unlock()
+
+			plan, err := api.b.PlanDownloads("3.1.2", "6.0.5", RuntimeTypeFull)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("PlanDownloads: got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PlanDownloads: %v", err)
+			}
+			for _, product := range plan.Products {
+				if product.ProductSlug == "p-rabbitmq" {
+					t.Errorf("p-rabbitmq planned at %s, want it left out", product.Version)
+				}
+			}
+			found := false
+			for _, warning := range plan.Warnings {
+				found = found || strings.HasPrefix(warning, tt.wantWarning)
+			}
+			if !found {
+				t.Errorf("warnings %q lack %q", plan.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestOMStopCause(t *testing.T) {
+	tests := []struct {
+		name  string
+		cause error
+	}{
+		{name: "paused", cause: errDownloadPaused},
+		{name: "download window closed", cause: errDownloadWindowClosed},
+		{name: "cancelled", cause: errDownloadCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBroadcomService()
+			b.events = func(string, map[string]interface{}) {}
+
+			const fileID = 7
+			done := make(chan error, 1)
+			go func() {
+				done <- b.runOMDownload(exec.Command("sleep", "30"), fileID, "sleep", t.TempDir())
+			}()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				b.downloadsMutex.Lock()
+				_, running := b.activeDownloads[fileID]
+				b.downloadsMutex.Unlock()
+				if running {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("om process did not start")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if err := b.stopDownload(fileID, tt.cause); err != nil {
+				t.Fatalf("stopDownload: %v", err)
+			}
+			if err := <-done; err != nil {
+				t.Fatalf("runOMDownload: %v", err)
+			}
+			if err := b.omStopCause(fileID); !errors.Is(err, tt.cause) {
+				t.Errorf("got %v, want %v", err, tt.cause)
+			}
+			if err := b.omStopCause(fileID); !errors.Is(err, errDownloadCancelled) {
+				t.Errorf("cause was not cleared: got %v", err)
+			}
+		})
+	}
+}
+
+func TestAcceptEULAAndDownloadRecordsVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		releaseID   int
+		wantErr     bool
+		wantVersion string
+		wantOutcome string
+	}{
+		{
+			name:        "download",
+			releaseID:   4001,
+			wantVersion: "10.0.3",
+			wantOutcome: HistoryDone,
+		},
+		{
+			name:        "version lookup fails",
+			releaseID:   4999,
+			wantErr:     true,
+			wantOutcome: HistoryFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+
+			err := api.b.AcceptEULAAndDownload("p-rabbitmq", tt.releaseID, 40011, t.TempDir())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AcceptEULAAndDownload: %v, want error %v", err, tt.wantErr)
+			}
+			entries, err := api.b.QueryDownloadHistory(HistoryQuery{})
+			if err != nil {
+				t.Fatalf("QueryDownloadHistory: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d history entries, want 1", len(entries))
+			}
+			if entries[0].Version != tt.wantVersion || entries[0].Outcome != tt.wantOutcome {
+				t.Errorf("got history entry %q %s, want %q %s", entries[0].Version, entries[0].Outcome, tt.wantVersion, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestStopQueuedDownloadBeforeTransfer(t *testing.T) {
+	tests := []struct {
+		name      string
+		stop      func(b *BroadcomService) error
+		wantState JobState
+	}{
+		{
+			name:      "pause",
+			stop:      func(b *BroadcomService) error { return b.PauseDownload(40011) },
+			wantState: JobPaused,
+		},
+		{
+			name: "download window closes",
+			stop: func(b *BroadcomService) error {
+				start := time.Now().Add(2 * time.Hour)
+				return b.SetDownloadWindows([]DownloadWindow{{
+					Start: start.Format("15:04"),
+					End:   start.Add(time.Hour).Format("15:04"),
+				}})
+			},
+			wantState: JobQueued,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeAPI(t)
+
+			// Hold the EULA acceptance until the download has been stopped
+			accepting := make(chan struct{}, 1)
+			release := make(chan struct{})
+			var releaseOnce sync.Once
+			unblock := func() { releaseOnce.Do(func() { close(release) }) }
+			t.Cleanup(unblock)
+			api.mu.Lock()
+			api.before = func(r *http.Request) {
+				if strings.HasSuffix(r.URL.Path, "/pivnet_resource_eula_acceptance") {
+					accepting <- struct{}{}
+					<-release
+				}
+			}
+			api.mu.Unlock()
+
+			api.b.queue.start()
+			err := api.b.EnqueueDownload(DownloadRequest{
+				ProductSlug: "p-rabbitmq",
+				ReleaseID:   4001,
+				FileID:      40011,
+				FileName:    "p-rabbitmq-10.0.3-build.12.pivotal",
+				OutputDir:   t.TempDir(),
+			})
+			if err != nil {
+				t.Fatalf("EnqueueDownload: %v", err)
+			}
+
+			select {
+			case <-accepting:
+			case <-time.After(5 * time.Second):
+				t.Fatal("EULA was not accepted")
+			}
+			if err := tt.stop(api.b); err != nil {
+				t.Fatalf("stopping the download: %v", err)
+			}
+			unblock()
+
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				api.b.queue.mu.Lock()
+				running := api.b.queue.running
+				state := api.b.queue.jobs[40011].State
+				api.b.queue.mu.Unlock()
+				if running == 0 {
+					if state != tt.wantState {
+						t.Errorf("job is %s, want %s", state, tt.wantState)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("download did not stop")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if downloads := api.fake.Downloads(); len(downloads) != 0 {
+				t.Errorf("object store served %q after the download was stopped", downloads)
+			}
+		})
+	}
+}
+
+func TestPauseAndResumeQueuedDownload(t *testing.T) {
+	api := newFakeAPI(t)
+
+	// Hold the first EULA acceptance so the run is still in flight when resumed
+	accepting := make(chan struct{}, 2)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	unblock := func() { releaseOnce.Do(func() { close(release) }) }
+	t.Cleanup(unblock)
+	api.mu.Lock()
+	api.before = func(r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/pivnet_resource_eula_acceptance") {
+			accepting <- struct{}{}
+			<-release
+		}
+	}
+	api.mu.Unlock()
+
+	api.b.queue.start()
+	dir := t.TempDir()
+	err := api.b.EnqueueDownload(DownloadRequest{
+		ProductSlug: "p-rabbitmq",
+		ReleaseID:   4001,
+		FileID:      40011,
+		FileName:    "p-rabbitmq-10.0.3-build.12.pivotal",
+		OutputDir:   dir,
+	})
+	if err != nil {
+		t.Fatalf("EnqueueDownload: %v", err)
+	}
+
+	select {
+	case <-accepting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("EULA was not accepted")
+	}
+	if err := api.b.PauseDownload(40011); err != nil {
+		t.Fatalf("PauseDownload: %v", err)
+	}
+	if err := api.b.ResumeDownload(40011); err != nil {
+		t.Fatalf("ResumeDownload: %v", err)
+	}
+	api.b.queue.mu.Lock()
+	running := api.b.queue.running
+	api.b.queue.mu.Unlock()
+	if running != 1 {
+		t.Errorf("%d runs after resuming, want the stopping one only", running)
+	}
+	unblock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		api.b.queue.mu.Lock()
+		job := *api.b.queue.jobs[40011]
+		api.b.queue.mu.Unlock()
+		if job.State == JobDone {
+			if _, err := os.Stat(job.Path); err != nil {
+				t.Errorf("downloaded file: %v", err)
+			}
+			break
+		}
+		if job.State == JobFailed || time.Now().After(deadline) {
+			t.Fatalf("job is %s (%s), want %s", job.State, job.Error, JobDone)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if downloads := api.fake.Downloads(); len(downloads) != 1 {
+		t.Errorf("object store served %q, want one transfer", downloads)
+	}
+}
+
+func TestAcceptEULAPerEndpoint(t *testing.T) {
+	api := newFakeAPI(t)
+	if err := api.b.AcceptEULA("p-rabbitmq", 4001); err != nil {
+		t.Fatalf("AcceptEULA: %v", err)
+	}
+
+	// A mirror knows nothing of the acceptance on the first endpoint
+	mirror := fakepivnet.New(nil)
+	srv := httptest.NewServer(mirror)
+	t.Cleanup(srv.Close)
+	if err := api.b.SetBaseURL(srv.URL); err != nil {
+		t.Fatalf("SetBaseURL: %v", err)
+	}
+	if api.b.IsEULAAccepted("p-rabbitmq", 4001) {
+		t.Error("acceptance on another endpoint counted for the mirror")
+	}
+	if err := api.b.AcceptEULA("p-rabbitmq", 4001); err != nil {
+		t.Fatalf("AcceptEULA: %v", err)
+	}
+	if !mirror.EULAAccepted("p-rabbitmq", 4001) {
+		t.Error("EULA not accepted on the mirror")
+	}
+}
+
+func TestAcceptEULAAndDownloadReacceptsEULA(t *testing.T) {
+	api := newFakeAPI(t)
+
+	// The record claims an acceptance the API does not know about
+	if err := api.b.recordEULAAcceptance("p-rabbitmq", 4001); err != nil {
+		t.Fatalf("recordEULAAcceptance: %v", err)
+	}
+	if err := api.b.AcceptEULAAndDownload("p-rabbitmq", 4001, 40011, t.TempDir()); err != nil {
+		t.Fatalf("AcceptEULAAndDownload: %v", err)
+	}
+	if !api.fake.EULAAccepted("p-rabbitmq", 4001) {
+		t.Error("EULA was not accepted again")
+	}
+	if downloads := api.fake.Downloads(); len(downloads) != 1 {
+		t.Errorf("got downloads %q, want one", downloads)
+	}
+}
+
+func TestSetProfileToken(t *testing.T) {
+	api := newFakeAPI(t)
+	if err := api.b.CreateProfile("other", defaultProfileName); err != nil {
+		t.Fatalf("CreateProfile: %v", err)
+	}
+
+	if err := api.b.setProfileToken("other", "revoked-token"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("storing a rejected token of another profile: got %v, want %v", err, ErrUnauthorized)
+	}
+	if err := api.b.setProfileToken("other", "fake-refresh-token"); err != nil {
+		t.Errorf("setProfileToken of another profile: %v", err)
+	}
+
+	if err := api.b.setProfileToken(defaultProfileName, "revoked-token"); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("storing a rejected token of the active profile: got %v, want %v", err, ErrUnauthorized)
+	}
+	if token := api.b.currentToken(); token != "fake-refresh-token" {
+		t.Errorf("rejected token replaced the session token: got %q", token)
+	}
+
+	// The active profile's new token is used by the session right away
+	api.b.setToken("stale-token")
+	if err := api.b.setProfileToken(defaultProfileName, "fake-refresh-token"); err != nil {
+		t.Fatalf("setProfileToken of the active profile: %v", err)
+	}
+	if token := api.b.currentToken(); token != "fake-refresh-token" {
+		t.Errorf("session token is %q, want the new token", token)
+	}
+	if _, err := api.b.GetReleaseFiles("p-rabbitmq", 4001); err != nil {
+		t.Errorf("request with the new token: %v", err)
+	}
+}
+
+func TestSetProfileTokenUsesProfileProxy(t *testing.T) {
+	api := newFakeAPI(t)
+	if err := api.b.CreateProfile("other", defaultProfileName); err != nil {
+		t.Fatalf("CreateProfile: %v", err)
+	}
+
+	// Only the other profile goes through the proxy, which answers the exchange itself
+	var proxied []string
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		w.Write([]byte(`{"access_token": "proxied-access-token"}`))
+	}))
+	t.Cleanup(proxy.Close)
+	err := api.b.updateProfile("other", func(profile *Profile) error {
+		profile.HTTPProxy = proxy.URL
+		profile.BaseURL = "http://api.other.example"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateProfile: %v", err)
+	}
+
+	if err := api.b.setProfileToken("other", "other-refresh-token"); err != nil {
+		t.Fatalf("setProfileToken: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(proxied) != 1 || proxied[0] != "http://api.other.example/api/v2/authentication/access_tokens" {
+		t.Errorf("proxy saw %v, want the token exchange of the other profile", proxied)
+	}
+}
+
+func TestAccessTokenExchangedOnce(t *testing.T) {
+	api := newFakeAPI(t)
+	release := make(chan struct{})
+	var releaseOnce sync.Once
+	t.Cleanup(func() { releaseOnce.Do(func() { close(release) }) }) // Lets the server shut down after a failure
+	var exchanges int
+	api.before = func(r *http.Request) {
+		if r.URL.Path == "/api/v2/authentication/access_tokens" {
+			api.mu.Lock()
+			exchanges++
+			api.mu.Unlock()
+			<-release
+		}
+	}
+	api.b.invalidateAccessToken(api.b.accessTokens.accessToken)
+
+	errs := make(chan error, 5)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := api.b.getAccessToken(context.Background())
+			errs <- err
+		}()
+	}
+
+	// The cache is not locked while the exchange is in flight
+	waitFor(t, "the exchange to start", func() bool {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		return exchanges == 1
+	})
+	waitFor(t, "the token cache to be unlocked during the exchange", func() bool {
+		if !api.b.accessTokens.mu.TryLock() {
+			return false
+		}
+		api.b.accessTokens.mu.Unlock()
+		return true
+	})
+
+	releaseOnce.Do(func() { close(release) })
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("getAccessToken: %v", err)
+		}
+	}
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if exchanges != 1 {
+		t.Errorf("exchanged the refresh token %d times, want once", exchanges)
+	}
+}
+
+func TestSwitchProfile(t *testing.T) {
+	api := newFakeAPI(t)
+	if err := api.b.CreateProfile("other", defaultProfileName); err != nil {
+		t.Fatalf("CreateProfile: %v", err)
+	}
+
+	// Not while a download runs
+	api.b.queue.mu.Lock()
+	api.b.queue.ensureLoaded()
+	api.b.queue.jobs[1] = &DownloadJob{DownloadRequest: DownloadRequest{FileID: 1}, State: JobRunning}
+	api.b.queue.mu.Unlock()
+	if err := api.b.SwitchProfile("other"); err == nil {
+		t.Fatal("SwitchProfile succeeded with a running download")
+	}
+	if profile := api.b.GetActiveProfile(); profile != defaultProfileName {
+		t.Fatalf("active profile is %s after a refused switch", profile)
+	}
+	api.b.queue.mu.Lock()
+	delete(api.b.queue.jobs, 1)
+	api.b.queue.mu.Unlock()
+
+	// A token that cannot be read is reported, and the profile is active without it
+	err := api.b.updateProfile("other", func(profile *Profile) error {
+		profile.EncryptedAPIToken = secretFormatPrefix + "garbage"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("updateProfile: %v", err)
+	}
+	if err := api.b.SwitchProfile("other"); err == nil || !strings.Contains(err.Error(), "could not load its token") {
+		t.Errorf("SwitchProfile to a profile with an unreadable token: got %v", err)
+	}
+	if profile := api.b.GetActiveProfile(); profile != "other" {
+		t.Errorf("active profile is %s, want other", profile)
+	}
+	if token := api.b.currentToken(); token != "" {
+		t.Errorf("session kept the token %q of the previous profile", token)
+	}
+
+	if err := api.b.SwitchProfile(defaultProfileName); err != nil {
+		t.Fatalf("SwitchProfile back: %v", err)
+	}
+	if token := api.b.currentToken(); token != "fake-refresh-token" {
+		t.Errorf("session token is %q after switching back", token)
+	}
+}
+
+func TestRemoveFailedDownloadDeletesPartialFile(t *testing.T) {
+	api := newFakeAPI(t)
+
+	// The object store drops the connection after a few bytes
+	api.mu.Lock()
+	api.handle = func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Path, "/object-store/") {
+			return false
+		}
+		w.Header().Set("Content-Length", "1000000")
+		w.Write([]byte("partial"))
+		return true
+	}
+	api.mu.Unlock()
+
+	api.b.queue.start()
+	noRetries := 0
+	dir := t.TempDir()
+	err := api.b.EnqueueDownload(DownloadRequest{
+		ProductSlug: "p-rabbitmq",
+		ReleaseID:   4001,
+		FileID:      40011,
+		FileName:    "p-rabbitmq-10.0.3-build.12.pivotal",
+		OutputDir:   dir,
+		MaxRetries:  &noRetries,
+	})
+	if err != nil {
+		t.Fatalf("EnqueueDownload: %v", err)
+	}
+	var job DownloadJob
+	waitFor(t, "the download to fail", func() bool {
+		api.b.queue.mu.Lock()
+		defer api.b.queue.mu.Unlock()
+		job = *api.b.queue.jobs[40011]
+		return job.State == JobFailed
+	})
+	partPath := filepath.Join(dir, "p-rabbitmq-10.0.3-build.12.pivotal"+partialSuffix)
+	if job.PartialPath != partPath {
+		t.Fatalf("failed job has partial file %q, want %s", job.PartialPath, partPath)
+	}
+	if _, err := os.Stat(partPath); err != nil {
+		t.Fatalf("partial file of the failed download: %v", err)
+	}
+
+	if err := api.b.RemoveDownload(40011); err != nil {
+		t.Fatalf("RemoveDownload: %v", err)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Errorf("partial file of the removed download: %v, want it deleted", err)
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// bundleFormatVersion is written to every manifest so importers can reject bundles they do not understand
+	bundleFormatVersion = 1
+
+	bundleManifestName  = "manifest.json"
+	bundleSignatureName = "manifest.json.sig"
+	bundlePublicKeyName = "signing-key.pub"
+	bundleFilesDir      = "files"
+)
+
+// BundleEntry describes one artifact in an air-gap bundle
+type BundleEntry struct {
+	ProductSlug string `json:"product_slug"`
+	ProductName string `json:"product_name,omitempty"`
+	Version     string `json:"version"`
+	ReleaseID   int    `json:"release_id"`
+	FileID      int    `json:"file_id"`
+	FileName    string `json:"file_name"`
+	Path        string `json:"path"` // Slash-separated path inside the bundle
+	SHA256      string `json:"sha256"`
+	Size        int64  `json:"size"`
+}
+
+// BundleManifest lists the artifacts of an air-gap bundle
+type BundleManifest struct {
+	FormatVersion  int           `json:"format_version"`
+	CreatedAt      time.Time     `json:"created_at"`
+	KeyFingerprint string        `json:"key_fingerprint"`
+	Files          []BundleEntry `json:"files"`
+}
+
+// BundleExportResult describes a written bundle
+type BundleExportResult struct {
+	Location       string         `json:"location"`
+	KeyFingerprint string         `json:"key_fingerprint"`
+	Manifest       BundleManifest `json:"manifest"`
+}
+
+// getSigningKeyPath returns the path to the private key used to sign bundles
+func (b *BroadcomService) getSigningKeyPath() (string, error) {
+	configDir, err := b.getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "bundle-signing.key"), nil
+}
+
+// loadSigningKey reads the bundle signing key, generating one on first use
+func (b *BroadcomService) loadSigningKey() (ed25519.PrivateKey, error) {
+	keyPath, err := b.getSigningKeyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("invalid signing key in %s", keyPath)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key in %s: %w", keyPath, err)
+		}
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("signing key in %s is not an Ed25519 key", keyPath)
+		}
+		return privateKey, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save signing key: %w", err)
+	}
+	return privateKey, nil
+}
+
+// encodePublicKey returns a public key in PEM form
+func encodePublicKey(publicKey ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// keyFingerprint identifies a public key by the SHA256 of its raw bytes
+func keyFingerprint(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return "SHA256:" + hex.EncodeToString(sum[:])
+}
+
+// GetBundleSigningKey returns the PEM public key that signs exported bundles,
+// for distribution to the disconnected side
+func (b *BroadcomService) GetBundleSigningKey() (string, error) {
+	privateKey, err := b.loadSigningKey()
+	if err != nil {
+		return "", err
+	}
+	publicKey, err := encodePublicKey(privateKey.Public().(ed25519.PublicKey))
+	if err != nil {
+		return "", err
+	}
+	return string(publicKey), nil
+}
+
+// bundleEntryPath returns the slash-separated location of an artifact inside a bundle
+func bundleEntryPath(job DownloadJob, fileName string) string {
+	return path.Join(bundleFilesDir, job.ProductSlug, job.Version, fileName)
+}
+
+// bundleFileName returns the product file name of a completed download. The
+// importer applies its own layout to it, so the name must not carry the one
+// the file was downloaded with.
+func (b *BroadcomService) bundleFileName(job DownloadJob) string {
+	if job.ObjectName != "" {
+		return job.ObjectName
+	}
+
+	// Downloads recorded before the name was kept lose what the layout added
+	base := filepath.Base(job.Path)
+	template, err := b.GetDownloadLayout()
+	if err != nil {
+		return base
+	}
+	return layoutFileName(template, layoutFields{
+		Kind:        fileKind(job.ProductSlug, base),
+		ProductSlug: job.ProductSlug,
+		Version:     job.Version,
+		Profile:     job.Profile,
+	}, base)
+}
+
+// bundleWriter receives the files of a bundle, either as a directory or a tarball
+type bundleWriter interface {
+	// addFile copies src to name, returning the SHA256 and size of the copied bytes
+	addFile(name string, src string) (string, int64, error)
+	// addBytes writes a small metadata file
+	addBytes(name string, data []byte) error
+	close() error
+}
+
+// dirBundleWriter lays a bundle out as a plain directory
+type dirBundleWriter struct {
+	root string
+}
+
+func (w *dirBundleWriter) addFile(name string, src string) (string, int64, error) {
+	dest := filepath.Join(w.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", 0, err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", 0, err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func (w *dirBundleWriter) addBytes(name string, data []byte) error {
+	return os.WriteFile(filepath.Join(w.root, filepath.FromSlash(name)), data, 0644)
+}
+
+func (w *dirBundleWriter) close() error {
+	return nil
+}
+
+// tarBundleWriter streams a bundle into an uncompressed tarball;
+// tiles and stemcells are already compressed
+type tarBundleWriter struct {
+	file *os.File
+	tw   *tar.Writer
+}
+
+func (w *tarBundleWriter) addFile(name string, src string) (string, int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		Size:    info.Size(),
+		Mode:    0644,
+		ModTime: info.ModTime(),
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return "", 0, err
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w.tw, h), in)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+func (w *tarBundleWriter) addBytes(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Size:    int64(len(data)),
+		Mode:    0644,
+		ModTime: time.Now(),
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(data)
+	return err
+}
+
+func (w *tarBundleWriter) close() error {
+	if err := w.tw.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// exportableDownloads returns the completed downloads that are still on disk,
+// from the download history and the queue, one per file ID. With file IDs
+// only those are returned, and each has to be on disk.
+func (b *BroadcomService) exportableDownloads(fileIDs []int) ([]DownloadJob, error) {
+	byFileID := make(map[int]DownloadJob)
+	onDisk := func(path string) bool {
+		info, err := os.Stat(path)
+		return err == nil && info.Mode().IsRegular()
+	}
+
+	// Queue jobs from before the history existed are still on record there
+	for _, job := range b.queue.completedJobs() {
+		if onDisk(job.Path) {
+			byFileID[job.FileID] = job
+		}
+	}
+
+	// History entries are newest first; the newest copy on disk wins
+	entries, err := b.QueryDownloadHistory(HistoryQuery{Outcome: HistoryDone})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[int]bool)
+	for _, entry := range entries {
+		if seen[entry.FileID] || !onDisk(entry.Path) {
+			continue
+		}
+		seen[entry.FileID] = true
+		byFileID[entry.FileID] = DownloadJob{
+			DownloadRequest: DownloadRequest{
+				ProductSlug: entry.ProductSlug,
+				ProductName: entry.ProductName,
+				ReleaseID:   entry.ReleaseID,
+				Version:     entry.Version,
+				FileID:      entry.FileID,
+				FileName:    entry.FileName,
+				Profile:     entry.Profile,
+			},
+			State:      JobDone,
+			Path:       entry.Path,
+			ObjectName: entry.ObjectName,
+			SHA256:     entry.SHA256,
+			FinishedAt: entry.FinishedAt,
+		}
+	}
+
+	if len(fileIDs) > 0 {
+		jobs := make([]DownloadJob, 0, len(fileIDs))
+		for _, fileID := range fileIDs {
+			job, exists := byFileID[fileID]
+			if !exists {
+				return nil, fmt.Errorf("file ID %d has no completed download on disk", fileID)
+			}
+			jobs = append(jobs, job)
+		}
+		return jobs, nil
+	}
+
+	jobs := make([]DownloadJob, 0, len(byFileID))
+	for _, job := range byFileID {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].ProductSlug != jobs[j].ProductSlug {
+			return jobs[i].ProductSlug < jobs[j].ProductSlug
+		}
+		if jobs[i].Version != jobs[j].Version {
+			return jobs[i].Version < jobs[j].Version
+		}
+		return jobs[i].FileID < jobs[j].FileID
+	})
+	return jobs, nil
+}
+
+// ExportBundle copies completed downloads into an air-gap bundle with a signed
+// manifest. Completed downloads come from the download history and the queue,
+// and only files still on disk are exported. With no file IDs every one of them
+// is exported. destination is a directory, or a .tar file when asTarball is set.
+func (b *BroadcomService) ExportBundle(fileIDs []int, destination string, asTarball bool) (*BundleExportResult, error) {
+	if destination == "" {
+		return nil, fmt.Errorf("bundle destination not set")
+	}
+
+	jobs, err := b.exportableDownloads(fileIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no completed downloads to export")
+	}
+
+	privateKey, err := b.loadSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	publicKeyPEM, err := encodePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var writer bundleWriter
+	var tmpPath string
+	if asTarball {
+		if !strings.HasSuffix(destination, ".tar") {
+			destination += ".tar"
+		}
+		if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create bundle directory: %w", err)
+		}
+		// The tarball only appears under its name once it is complete
+		tmpPath = destination + partialSuffix
+		file, err := os.Create(tmpPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bundle: %w", err)
+		}
+		defer os.Remove(tmpPath)
+		writer = &tarBundleWriter{file: file, tw: tar.NewWriter(file)}
+	} else {
+		if err := os.MkdirAll(destination, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create bundle directory: %w", err)
+		}
+		writer = &dirBundleWriter{root: destination}
+	}
+
+	manifest := BundleManifest{
+		FormatVersion:  bundleFormatVersion,
+		CreatedAt:      time.Now().UTC(),
+		KeyFingerprint: keyFingerprint(publicKey),
+		Files:          make([]BundleEntry, 0, len(jobs)),
+	}
+
+	for i, job := range jobs {
+		b.emit("bundle-progress", map[string]interface{}{
+			"operation": "export",
+			"file":      filepath.Base(job.Path),
+			"index":     i + 1,
+			"total":     len(jobs),
+		})
+
+		fileName := b.bundleFileName(job)
+		entryPath := bundleEntryPath(job, fileName)
+		sum, size, err := writer.addFile(entryPath, job.Path)
+		if err != nil {
+			writer.close()
+			return nil, fmt.Errorf("failed to add %s to bundle: %w", job.Path, err)
+		}
+
+		// Never ship a file that no longer matches what the API published
+		if job.SHA256 != "" && !strings.EqualFold(job.SHA256, sum) {
+			writer.close()
+			return nil, fmt.Errorf("%w for %s: expected %s, got %s", errChecksumMismatch, job.Path, job.SHA256, sum)
+		}
+
+		manifest.Files = append(manifest.Files, BundleEntry{
+			ProductSlug: job.ProductSlug,
+			ProductName: job.ProductName,
+			Version:     job.Version,
+			ReleaseID:   job.ReleaseID,
+			FileID:      job.FileID,
+			FileName:    fileName,
+			Path:        entryPath,
+			SHA256:      sum,
+			Size:        size,
+		})
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		writer.close()
+		return nil, err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, manifestData))
+
+	if err := writer.addBytes(bundleManifestName, manifestData); err != nil {
+		writer.close()
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := writer.addBytes(bundleSignatureName, []byte(signature+"\n")); err != nil {
+		writer.close()
+		return nil, fmt.Errorf("failed to write signature: %w", err)
+	}
+	if err := writer.addBytes(bundlePublicKeyName, publicKeyPEM); err != nil {
+		writer.close()
+		return nil, fmt.Errorf("failed to write public key: %w", err)
+	}
+	if err := writer.close(); err != nil {
+		return nil, fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if tmpPath != "" {
+		if err := os.Rename(tmpPath, destination); err != nil {
+			return nil, fmt.Errorf("failed to finish bundle: %w", err)
+		}
+	}
+
+	b.emit("bundle-complete", map[string]interface{}{
+		"operation": "export",
+		"location":  destination,
+		"files":     len(manifest.Files),
+	})
+
+	return &BundleExportResult{
+		Location:       destination,
+		KeyFingerprint: manifest.KeyFingerprint,
+		Manifest:       manifest,
+	}, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exportTestBundle downloads the RabbitMQ tile with the given layout and exports
+// it, returning the download path relative to its download location and the
+// bundle location
+func exportTestBundle(t *testing.T, api *fakeAPI, layout string, asTarball bool) (string, string) {
+	t.Helper()
+	src := t.TempDir()
+	if err := api.b.SetDownloadLayout(layout); err != nil {
+		t.Fatalf("SetDownloadLayout: %v", err)
+	}
+	if err := api.b.AcceptEULAAndDownload("p-rabbitmq", 4001, 40011, src); err != nil {
+		t.Fatalf("AcceptEULAAndDownload: %v", err)
+	}
+	downloaded, err := api.b.productFilePath(src, "p-rabbitmq", "10.0.3", ProductFile{AWSObjectKey: "product-files/p-rabbitmq/p-rabbitmq-10.0.3-build.12.pivotal"})
+	if err != nil {
+		t.Fatalf("productFilePath: %v", err)
+	}
+
+	rel, err := filepath.Rel(src, downloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := api.b.ExportBundle([]int{40011}, filepath.Join(t.TempDir(), "bundle"), asTarball)
+	if err != nil {
+		t.Fatalf("ExportBundle: %v", err)
+	}
+	return rel, result.Location
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	for _, preset := range downloadLayoutPresets {
+		for _, asTarball := range []bool{false, true} {
+			name := preset.Name + "/directory"
+			if asTarball {
+				name = preset.Name + "/tarball"
+			}
+			t.Run(name, func(t *testing.T) {
+				api := newFakeAPI(t)
+				rel, bundle := exportTestBundle(t, api, preset.Name, asTarball)
+
+				if _, err := api.b.TrustBundleKey(mustSigningKey(t, api.b)); err != nil {
+					t.Fatalf("TrustBundleKey: %v", err)
+				}
+				dest := t.TempDir()
+				if err := api.b.SetDownloadLocation(dest); err != nil {
+					t.Fatalf("SetDownloadLocation: %v", err)
+				}
+				report, err := api.b.ImportBundle(bundle, false)
+				if err != nil {
+					t.Fatalf("ImportBundle: %v", err)
+				}
+				if !report.OK() || len(report.Files) != 1 {
+					t.Fatalf("import report: %+v", report)
+				}
+
+				check := report.Files[0]
+				if check.FileName != "p-rabbitmq-10.0.3-build.12.pivotal" {
+					t.Errorf("manifest file name %q, want the product file name", check.FileName)
+				}
+				if want := filepath.Join(dest, rel); check.Destination != want {
+					t.Errorf("imported to %s, want %s", check.Destination, want)
+				}
+				if _, err := os.Stat(check.Destination); err != nil {
+					t.Errorf("imported file: %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestBundleRefusesUntrustedAndModifiedBundles(t *testing.T) {
+	api := newFakeAPI(t)
+	_, bundle := exportTestBundle(t, api, "product", false)
+
+	// The key in the bundle checks the signature, but it is not trusted yet
+	if _, err := api.b.ImportBundle(bundle, false); !errors.Is(err, errUntrustedBundle) {
+		t.Errorf("ImportBundle of an untrusted bundle: got %v, want %v", err, errUntrustedBundle)
+	}
+	report, err := api.b.VerifyBundle(bundle)
+	if err != nil {
+		t.Fatalf("VerifyBundle: %v", err)
+	}
+	if report.Trusted || !report.OK() {
+		t.Errorf("VerifyBundle: trusted %v, ok %v; want an intact untrusted bundle", report.Trusted, report.OK())
+	}
+
+	sum := report.Files[0].SHA256
+
+	// A changed artifact is reported as corrupt
+	artifact := filepath.Join(bundle, filepath.FromSlash(report.Files[0].Path))
+	if err := os.WriteFile(artifact, []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = api.b.VerifyBundle(bundle); err != nil || report.Corrupt != 1 {
+		t.Errorf("VerifyBundle of a changed artifact: %+v, %v; want one corrupt file", report, err)
+	}
+
+	// A changed manifest no longer matches its signature
+	manifestPath := filepath.Join(bundle, bundleManifestName)
+	manifest, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest = []byte(strings.Replace(string(manifest), sum, strings.Repeat("0", 64), 1))
+	if err := os.WriteFile(manifestPath, manifest, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.b.VerifyBundle(bundle); err == nil || !strings.Contains(err.Error(), "signature is invalid") {
+		t.Errorf("VerifyBundle of a changed manifest: got %v, want an invalid signature", err)
+	}
+}
+
+// mustSigningKey returns the PEM public key that signs bundles of b
+func mustSigningKey(t *testing.T, b *BroadcomService) string {
+	t.Helper()
+	key, err := b.GetBundleSigningKey()
+	if err != nil {
+		t.Fatalf("GetBundleSigningKey: %v", err)
+	}
+	return key
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Outcomes of checking a manifest entry against the bundle contents
+const (
+	BundleFileOK      = "ok"
+	BundleFileMissing = "missing"
+	BundleFileCorrupt = "corrupt"
+)
+
+// errUntrustedBundle refuses imports of bundles whose signer is not trusted
+var errUntrustedBundle = errors.New("bundle is signed by an untrusted key")
+
+// BundleFileCheck is the result of re-hashing one bundle artifact
+type BundleFileCheck struct {
+	BundleEntry
+	Status       string `json:"status"`
+	ActualSHA256 string `json:"actual_sha256,omitempty"`
+	Destination  string `json:"destination,omitempty"` // Set once the file has been imported
+}
+
+// BundleImportReport describes a verified or imported bundle
+type BundleImportReport struct {
+	Source         string            `json:"source"`
+	Destination    string            `json:"destination,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	KeyFingerprint string            `json:"key_fingerprint"`
+	Trusted        bool              `json:"trusted"` // Signer is in the trusted key list
+	Files          []BundleFileCheck `json:"files"`
+	Missing        int               `json:"missing"`
+	Corrupt        int               `json:"corrupt"`
+	Extra          []string          `json:"extra"` // Files in the bundle that the manifest does not list
+}
+
+// OK reports whether every manifest entry was present and intact
+func (r *BundleImportReport) OK() bool {
+	return r.Missing == 0 && r.Corrupt == 0
+}
+
+// getTrustedKeysPath returns the path to the PEM file of trusted bundle signers
+func (b *BroadcomService) getTrustedKeysPath() (string, error) {
+	configDir, err := b.getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "trusted-bundle-keys.pem"), nil
+}
+
+// parsePublicKey decodes the first PEM Ed25519 public key in data
+func parsePublicKey(data []byte) (ed25519.PublicKey, []byte, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return nil, nil, fmt.Errorf("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("public key is not an Ed25519 key")
+	}
+	return publicKey, rest, nil
+}
+
+// loadTrustedKeys returns the trusted bundle signers keyed by fingerprint
+func (b *BroadcomService) loadTrustedKeys() (map[string]ed25519.PublicKey, error) {
+	keysPath, err := b.getTrustedKeysPath()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]ed25519.PublicKey)
+	data, err := os.ReadFile(keysPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return keys, nil
+		}
+		return nil, err
+	}
+
+	for len(bytes.TrimSpace(data)) > 0 {
+		publicKey, rest, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %s: %w", keysPath, err)
+		}
+		keys[keyFingerprint(publicKey)] = publicKey
+		data = rest
+	}
+	return keys, nil
+}
+
+// TrustBundleKey adds a PEM public key to the trusted bundle signers and
+// returns its fingerprint. Only bundles from trusted signers are imported.
+func (b *BroadcomService) TrustBundleKey(publicKeyPEM string) (string, error) {
+	publicKey, _, err := parsePublicKey([]byte(publicKeyPEM))
+	if err != nil {
+		return "", err
+	}
+	fingerprint := keyFingerprint(publicKey)
+
+	keys, err := b.loadTrustedKeys()
+	if err != nil {
+		return "", err
+	}
+	if _, exists := keys[fingerprint]; exists {
+		return fingerprint, nil
+	}
+
+	encoded, err := encodePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	keysPath, err := b.getTrustedKeysPath()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(keysPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to open trusted keys: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(encoded); err != nil {
+		return "", fmt.Errorf("failed to save trusted key: %w", err)
+	}
+	return fingerprint, nil
+}
+
+// isTarBundle reports whether a bundle path is a tarball rather than a directory
+func isTarBundle(source string) (bool, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return false, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	return !info.IsDir(), nil
+}
+
+// readBundleMetadata returns the manifest, signature and public key of a bundle
+func readBundleMetadata(source string, isTar bool) (map[string][]byte, error) {
+	wanted := map[string]bool{
+		bundleManifestName:  true,
+		bundleSignatureName: true,
+		bundlePublicKeyName: true,
+	}
+	metadata := make(map[string][]byte)
+
+	if !isTar {
+		for name := range wanted {
+			data, err := os.ReadFile(filepath.Join(source, name))
+			if err != nil {
+				return nil, fmt.Errorf("bundle is missing %s: %w", name, err)
+			}
+			metadata[name] = data
+		}
+		return metadata, nil
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if !wanted[header.Name] {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		metadata[header.Name] = data
+	}
+
+	for name := range wanted {
+		if _, exists := metadata[name]; !exists {
+			return nil, fmt.Errorf("bundle is missing %s", name)
+		}
+	}
+	return metadata, nil
+}
+
+// verifyManifest checks the detached signature and decodes the manifest
+func (b *BroadcomService) verifyManifest(metadata map[string][]byte) (*BundleManifest, string, bool, error) {
+	publicKey, _, err := parsePublicKey(metadata[bundlePublicKeyName])
+	if err != nil {
+		return nil, "", false, fmt.Errorf("invalid %s: %w", bundlePublicKeyName, err)
+	}
+	fingerprint := keyFingerprint(publicKey)
+
+	trustedKeys, err := b.loadTrustedKeys()
+	if err != nil {
+		return nil, "", false, err
+	}
+	_, trusted := trustedKeys[fingerprint]
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(metadata[bundleSignatureName])))
+	if err != nil {
+		return nil, fingerprint, trusted, fmt.Errorf("invalid %s: %w", bundleSignatureName, err)
+	}
+	if !ed25519.Verify(publicKey, metadata[bundleManifestName], signature) {
+		return nil, fingerprint, trusted, fmt.Errorf("manifest signature is invalid; the bundle has been modified")
+	}
+
+	var manifest BundleManifest
+	if err := json.Unmarshal(metadata[bundleManifestName], &manifest); err != nil {
+		return nil, fingerprint, trusted, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.FormatVersion != bundleFormatVersion {
+		return nil, fingerprint, trusted, fmt.Errorf("unsupported bundle format version %d", manifest.FormatVersion)
+	}
+	if manifest.KeyFingerprint != fingerprint {
+		return nil, fingerprint, trusted, fmt.Errorf("manifest names signing key %s but the bundle carries %s", manifest.KeyFingerprint, fingerprint)
+	}
+
+	for _, entry := range manifest.Files {
+		cleaned := path.Clean(entry.Path)
+		if cleaned != entry.Path || !strings.HasPrefix(cleaned, bundleFilesDir+"/") {
+			return nil, fingerprint, trusted, fmt.Errorf("manifest entry has an invalid path: %s", entry.Path)
+		}
+		if entry.FileName != filepath.Base(entry.FileName) || entry.FileName == "" || entry.FileName == "." || entry.FileName == ".." {
+			return nil, fingerprint, trusted, fmt.Errorf("manifest entry has an invalid file name: %s", entry.FileName)
+		}
+	}
+
+	return &manifest, fingerprint, trusted, nil
+}
+
+// checkBundleFile hashes one artifact and, when dest is set, writes it there.
+// The file only replaces the destination once its checksum has been confirmed.
+func checkBundleFile(entry BundleEntry, r io.Reader, dest string) BundleFileCheck {
+	check := BundleFileCheck{BundleEntry: entry}
+	h := sha256.New()
+
+	var size int64
+	var err error
+	var tmpPath string
+	if dest == "" {
+		size, err = io.Copy(h, r)
+	} else {
+		tmpPath = dest + partialSuffix
+		var out *os.File
+		if err = os.MkdirAll(filepath.Dir(dest), 0755); err == nil {
+			out, err = os.Create(tmpPath)
+		}
+		if err == nil {
+			size, err = io.Copy(io.MultiWriter(out, h), r)
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+
+	if err != nil {
+		check.Status = BundleFileCorrupt
+		if tmpPath != "" {
+			os.Remove(tmpPath)
+		}
+		return check
+	}
+
+	check.ActualSHA256 = hex.EncodeToString(h.Sum(nil))
+	if size != entry.Size || !strings.EqualFold(check.ActualSHA256, entry.SHA256) {
+		check.Status = BundleFileCorrupt
+		if tmpPath != "" {
+			os.Remove(tmpPath)
+		}
+		return check
+	}
+
+	check.Status = BundleFileOK
+	if tmpPath != "" {
+		if err := os.Rename(tmpPath, dest); err != nil {
+			os.Remove(tmpPath)
+			check.Status = BundleFileCorrupt
+			return check
+		}
+		check.Destination = dest
+	}
+	return check
+}
+
+// VerifyBundle checks a bundle's signature and re-hashes its files without
+// importing them. Bundles from untrusted signers can be inspected this way.
+func (b *BroadcomService) VerifyBundle(source string) (*BundleImportReport, error) {
+	return b.importBundle(source, "", true)
+}
+
+// ImportBundle verifies a bundle and copies its intact files into the download
+// location, using the same layout as downloads. It works offline and needs no API token.
+// Bundles whose signing key is not trusted are refused unless allowUntrusted is set,
+// since the key that checks the signature ships inside the bundle.
+func (b *BroadcomService) ImportBundle(source string, allowUntrusted bool) (*BundleImportReport, error) {
+	destination, err := b.GetDownloadLocation()
+	if err != nil {
+		return nil, err
+	}
+	if destination == "" {
+		return nil, fmt.Errorf("download location not set")
+	}
+	if err := os.MkdirAll(destination, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+	return b.importBundle(source, destination, allowUntrusted)
+}
+
+// importBundle verifies a bundle and, when destination is set, imports it
+func (b *BroadcomService) importBundle(source string, destination string, allowUntrusted bool) (*BundleImportReport, error) {
+	isTar, err := isTarBundle(source)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := readBundleMetadata(source, isTar)
+	if err != nil {
+		return nil, err
+	}
+	manifest, fingerprint, trusted, err := b.verifyManifest(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if !trusted && !allowUntrusted {
+		return nil, fmt.Errorf("%w: %s; trust the signer's public key first", errUntrustedBundle, fingerprint)
+	}
+
+	report := &BundleImportReport{
+		Source:         source,
+		Destination:    destination,
+		CreatedAt:      manifest.CreatedAt,
+		KeyFingerprint: fingerprint,
+		Trusted:        trusted,
+		Extra:          []string{},
+	}
+
+	entries := make(map[string]BundleEntry, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		entries[entry.Path] = entry
+	}
+	checks := make(map[string]BundleFileCheck, len(manifest.Files))
+
+	// Imported files are arranged like downloads
+	destFor := func(entry BundleEntry) string {
+		if destination == "" {
+			return ""
+		}
+		dest, err := b.downloadPath(destination, layoutFields{
+			Kind:        fileKind(entry.ProductSlug, entry.FileName),
+			ProductSlug: entry.ProductSlug,
+			Version:     entry.Version,
+			File:        entry.FileName,
+		})
+		if err != nil {
+			return filepath.Join(destination, entry.FileName)
+		}
+		return dest
+	}
+
+	progress := func(entry BundleEntry) {
+		b.emit("bundle-progress", map[string]interface{}{
+			"operation": "import",
+			"file":      entry.FileName,
+			"index":     len(checks) + 1,
+			"total":     len(manifest.Files),
+		})
+	}
+
+	if isTar {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open bundle: %w", err)
+		}
+		defer f.Close()
+
+		tr := tar.NewReader(f)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read bundle: %w", err)
+			}
+			if header.Typeflag != tar.TypeReg || !strings.HasPrefix(header.Name, bundleFilesDir+"/") {
+				continue
+			}
+
+			entry, listed := entries[header.Name]
+			if !listed {
+				report.Extra = append(report.Extra, header.Name)
+				continue
+			}
+			progress(entry)
+			checks[entry.Path] = checkBundleFile(entry, tr, destFor(entry))
+		}
+	} else {
+		filesRoot := filepath.Join(source, bundleFilesDir)
+		err := filepath.Walk(filesRoot, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && p == filesRoot {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(source, p)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+
+			entry, listed := entries[name]
+			if !listed {
+				report.Extra = append(report.Extra, name)
+				return nil
+			}
+
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			progress(entry)
+			checks[entry.Path] = checkBundleFile(entry, f, destFor(entry))
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+	}
+
+	// Report in manifest order
+	for _, entry := range manifest.Files {
+		check, found := checks[entry.Path]
+		if !found {
+			check = BundleFileCheck{BundleEntry: entry, Status: BundleFileMissing}
+		}
+		switch check.Status {
+		case BundleFileMissing:
+			report.Missing++
+		case BundleFileCorrupt:
+			report.Corrupt++
+		}
+		report.Files = append(report.Files, check)
+	}
+
+	operation := "verify"
+	if destination != "" {
+		operation = "import"
+	}
+	b.emit("bundle-complete", map[string]interface{}{
+		"operation": operation,
+		"location":  source,
+		"files":     len(report.Files),
+		"missing":   report.Missing,
+		"corrupt":   report.Corrupt,
+		"extra":     len(report.Extra),
+	})
+
+	return report, nil
+}
@@ -0,0 +1,1003 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"tanzu-downloader/fakepivnet"
+)
+
+// Exit codes returned by the headless CLI
+const (
+	exitOK           = 0
+	exitFailure      = 1
+	exitUsage        = 2
+	exitUnauthorized = 3
+	exitNotFound     = 4
+	exitRateLimited  = 5
+	exitEULARequired = 6
+)
+
+// cliCommandNames lists the subcommands that run without a window, in help order
+var cliCommandNames = []string{"products", "releases", "files", "download", "plan", "export", "import", "model", "profiles", "history", "inventory", "layout", "connectivity", "mock-server"}
+
+// cliUsage holds the argument synopsis of each subcommand
+var cliUsage = map[string]string{
+	"products":     "products [--json]",
+	"releases":     "releases <product-slug> [--json]",
+	"files":        "files <product-slug> <version> [--json]",
+	"download":     "download <product-slug> <version> [--glob PATTERN] [--output DIR]",
+	"plan":         "plan <ops-manager-version> <elastic-runtime-version> [--type full|srt] [--json]",
+	"export":       "export --output PATH [--tar] [file-id|path ...]",
+	"import":       "import <bundle-path> [--verify-only] [--trust-key FILE] [--allow-untrusted] [--json]",
+	"model":        "model <vllm|ollama> <huggingface-url> [--name NAME] [--output DIR]",
+	"profiles":     "profiles [list [--json] | use NAME | delete NAME | create NAME [--copy-from PROFILE] [--download-location DIR] [--http-proxy URL] [--https-proxy URL] [--iaas IAAS] [--base-url URL] [--token-stdin]]",
+	"history":      "history [--profile NAME] [--product TEXT] [--version VERSION] [--outcome done|failed|cancelled] [--since DATE|AGE] [--until DATE|AGE] [--search TEXT] [--limit N] [--json]",
+	"inventory":    "inventory [--verify] [--json]",
+	"layout":       "layout [--json] | layout set PRESET|TEMPLATE",
+	"connectivity": "connectivity [--json]",
+	"mock-server":  "mock-server [--listen ADDR] [--fixtures DIR] [--refresh-token TOKEN] [--page-size N]",
+}
+
+// isCLIInvocation reports whether the arguments select a headless subcommand
+func isCLIInvocation(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		return true
+	}
+	_, ok := cliUsage[args[0]]
+	return ok
+}
+
+// cli runs subcommands against the same services the desktop app binds
+type cli struct {
+	stdout   io.Writer
+	stderr   io.Writer
+	broadcom *BroadcomService
+	aiModel  *AIModelService
+
+	// fileNames maps file IDs to names for progress output
+	fileNames      map[int]string
+	fileNamesMutex sync.Mutex
+}
+
+// runCLI executes a headless subcommand and returns the process exit code
+func runCLI(args []string) int {
+	c := &cli{
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+		broadcom:  NewBroadcomService(),
+		aiModel:   NewAIModelService(),
+		fileNames: make(map[int]string),
+	}
+	c.broadcom.events = c.handleEvent
+	c.aiModel.events = c.handleEvent
+	c.aiModel.activeProfile = c.broadcom.activeProfileName
+	c.aiModel.transport = c.broadcom.aiModelTransport
+	c.aiModel.layout = c.broadcom.downloadPath
+
+	// Run a single command against another profile without switching the saved one
+	if profile := os.Getenv(profileEnv); profile != "" {
+		c.broadcom.sessionMutex.Lock()
+		c.broadcom.profileOverride = profile
+		c.broadcom.sessionMutex.Unlock()
+		if _, err := c.broadcom.loadConfig(); err != nil {
+			return c.failErr(err)
+		}
+	}
+
+	ctx := context.Background()
+	c.broadcom.initialize(ctx)
+	c.aiModel.startup(ctx)
+
+	// Allow automation to supply a token without writing it to the config file
+	if token := os.Getenv("TILE_DOWNLOADER_API_TOKEN"); token != "" {
+		c.broadcom.setToken(token)
+	}
+
+	switch args[0] {
+	case "products":
+		return c.runProducts(args[1:])
+	case "releases":
+		return c.runReleases(args[1:])
+	case "files":
+		return c.runFiles(args[1:])
+	case "download":
+		return c.runDownload(args[1:])
+	case "plan":
+		return c.runPlan(args[1:])
+	case "export":
+		return c.runExport(args[1:])
+	case "import":
+		return c.runImport(args[1:])
+	case "model":
+		return c.runModel(args[1:])
+	case "profiles":
+		return c.runProfiles(args[1:])
+	case "history":
+		return c.runHistory(args[1:])
+	case "inventory":
+		return c.runInventory(args[1:])
+	case "layout":
+		return c.runLayout(args[1:])
+	case "connectivity":
+		return c.runConnectivity(args[1:])
+	case "mock-server":
+		return c.runMockServer(args[1:])
+	}
+
+	c.printUsage()
+	return exitOK
+}
+
+// printUsage lists the available subcommands
+func (c *cli) printUsage() {
+	fmt.Fprintln(c.stderr, "Usage: tile-downloader <command> [arguments]")
+	fmt.Fprintln(c.stderr, "")
+	fmt.Fprintln(c.stderr, "Run without arguments to start the desktop app.")
+	fmt.Fprintln(c.stderr, "")
+	fmt.Fprintln(c.stderr, "Commands:")
+	for _, name := range cliCommandNames {
+		fmt.Fprintf(c.stderr, "  %s\n", cliUsage[name])
+	}
+}
+
+// fail prints an error and returns the failure exit code
+func (c *cli) fail(format string, args ...interface{}) int {
+	fmt.Fprintf(c.stderr, "Error: "+format+"\n", args...)
+	return exitFailure
+}
+
+// failErr prints an error and returns an exit code that tells scripts
+// which kind of API failure occurred
+func (c *cli) failErr(err error) int {
+	c.fail("%v", err)
+	switch {
+	case errors.Is(err, ErrUnauthorized) && c.broadcom.currentToken() == "" && c.broadcom.IsTokenLocked():
+		fmt.Fprintf(c.stderr, "The saved API token is protected with a passphrase; set %s to unlock it.\n", passphraseEnv)
+		return exitUnauthorized
+	case errors.Is(err, ErrUnauthorized):
+		fmt.Fprintln(c.stderr, "Check the API token saved in the app or TILE_DOWNLOADER_API_TOKEN.")
+		return exitUnauthorized
+	case errors.Is(err, ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, ErrRateLimited):
+		fmt.Fprintln(c.stderr, "The Broadcom API is rate limiting requests; try again later.")
+		return exitRateLimited
+	case errors.Is(err, ErrEULARequired):
+		return exitEULARequired
+	}
+	return exitFailure
+}
+
+// usageError prints the usage of a subcommand and returns the usage exit code
+func (c *cli) usageError(name string) int {
+	fmt.Fprintf(c.stderr, "Usage: tile-downloader %s\n", cliUsage[name])
+	return exitUsage
+}
+
+// parseArgs parses flags that may appear before, between or after positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// printJSON writes v as indented JSON
+func (c *cli) printJSON(v interface{}) int {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return c.failErr(err)
+	}
+	return exitOK
+}
+
+// printTable writes rows as aligned columns under the given headers
+func (c *cli) printTable(headers []string, rows [][]string) {
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// formatBytes renders a byte count in binary units
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// handleEvent prints backend events that the desktop app would show in its UI
+func (c *cli) handleEvent(name string, data map[string]interface{}) {
+	switch name {
+	case "download-progress":
+		fileID, _ := data["fileID"].(int)
+		c.fileNamesMutex.Lock()
+		fileName := c.fileNames[fileID]
+		c.fileNamesMutex.Unlock()
+		if fileName == "" {
+			fileName = fmt.Sprintf("file %d", fileID)
+		}
+
+		status, _ := data["status"].(string)
+		progress := toFloat(data["progress"])
+		downloaded, _ := data["downloaded"].(int64)
+		total, _ := data["total"].(int64)
+		if total > 0 {
+			fmt.Fprintf(c.stderr, "\r%s: %5.1f%% %s / %s  ", fileName, progress, formatBytes(downloaded), formatBytes(total))
+		} else {
+			fmt.Fprintf(c.stderr, "\r%s: %5.1f%% %s  ", fileName, progress, status)
+		}
+	case "download-complete":
+		fmt.Fprintf(c.stderr, "\nDownloaded %v\n", data["path"])
+	case "download-verified":
+		fmt.Fprintf(c.stderr, "Verified %v (%v)\n", data["path"], data["algorithm"])
+	case "download-corrupt":
+		fmt.Fprintf(c.stderr, "Checksum mismatch for %v, moved to %v\n", data["path"], data["quarantinedPath"])
+	case "download-cancelled":
+		fmt.Fprintf(c.stderr, "\nDownload of file %v cancelled\n", data["fileID"])
+	case "bundle-progress":
+		fmt.Fprintf(c.stderr, "[%v/%v] %v\n", data["index"], data["total"], data["file"])
+	case "ai-model-status":
+		fmt.Fprintf(c.stderr, "\r%v: %v  ", data["modelName"], data["status"])
+	case "ai-model-complete":
+		fmt.Fprintf(c.stderr, "\nModel saved to %v\n", data["path"])
+	}
+}
+
+// toFloat converts the numeric types used in event payloads
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
+
+// runProducts lists all products
+func (c *cli) runProducts(args []string) int {
+	fs := c.newFlagSet("products")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 0 {
+		return c.usageError("products")
+	}
+
+	products, err := c.broadcom.ListProducts()
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		return c.printJSON(products)
+	}
+
+	rows := make([][]string, 0, len(products))
+	for _, p := range products {
+		rows = append(rows, []string{p.Slug, p.Name})
+	}
+	c.printTable([]string{"SLUG", "NAME"}, rows)
+	return exitOK
+}
+
+// runReleases lists the releases of a product
+func (c *cli) runReleases(args []string) int {
+	fs := c.newFlagSet("releases")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 1 {
+		return c.usageError("releases")
+	}
+
+	releases, err := c.broadcom.GetProductReleases(positional[0])
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		return c.printJSON(releases)
+	}
+
+	rows := make([][]string, 0, len(releases))
+	for _, r := range releases {
+		rows = append(rows, []string{fmt.Sprint(r.ID), r.Version, r.ReleaseDate})
+	}
+	c.printTable([]string{"ID", "VERSION", "RELEASE DATE"}, rows)
+	return exitOK
+}
+
+// runFiles lists the files of a release
+func (c *cli) runFiles(args []string) int {
+	fs := c.newFlagSet("files")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 2 {
+		return c.usageError("files")
+	}
+
+	release, err := c.broadcom.findReleaseByVersion(positional[0], positional[1])
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	files, err := c.broadcom.GetReleaseFiles(positional[0], release.ID)
+	if err != nil {
+		return c.failErr(err)
+	}
+	if files, err = c.broadcom.AnnotateLocalStatus(positional[0], release.Version, files); err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		return c.printJSON(files)
+	}
+
+	rows := make([][]string, 0, len(files))
+	for _, f := range files {
+		size := ""
+		if f.Size > 0 {
+			size = formatBytes(f.Size)
+		}
+		rows = append(rows, []string{fmt.Sprint(f.ID), productFileName(f), f.FileType, size, f.LocalStatus})
+	}
+	c.printTable([]string{"ID", "FILE", "TYPE", "SIZE", "LOCAL"}, rows)
+	return exitOK
+}
+
+// runDownload downloads the files of a release that match a glob
+func (c *cli) runDownload(args []string) int {
+	fs := c.newFlagSet("download")
+	glob := fs.String("glob", "*", "download files whose name matches this pattern")
+	output := fs.String("output", "", "download directory (defaults to the configured download location)")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 2 {
+		return c.usageError("download")
+	}
+	productSlug, version := positional[0], positional[1]
+
+	outputDir := *output
+	if outputDir == "" {
+		if outputDir, err = c.broadcom.GetDownloadLocation(); err != nil {
+			return c.failErr(err)
+		}
+	}
+
+	release, err := c.broadcom.findReleaseByVersion(productSlug, version)
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	files, err := c.broadcom.GetReleaseFiles(productSlug, release.ID)
+	if err != nil {
+		return c.failErr(err)
+	}
+	if *output == "" {
+		if files, err = c.broadcom.AnnotateLocalStatus(productSlug, release.Version, files); err != nil {
+			return c.failErr(err)
+		}
+	}
+
+	var matched []ProductFile
+	for _, f := range files {
+		nameMatch, _ := filepath.Match(*glob, productFileName(f))
+		displayMatch, _ := filepath.Match(*glob, f.Name)
+		if nameMatch || displayMatch {
+			matched = append(matched, f)
+		}
+	}
+	if len(matched) == 0 {
+		return c.fail("no files of %s %s match %q", productSlug, version, *glob)
+	}
+
+	failures := 0
+	for _, f := range matched {
+		// Files already in the download location are not fetched again
+		if *output == "" && (f.LocalStatus == LocalVerified || f.LocalStatus == LocalPresent) {
+			fmt.Fprintf(c.stdout, "%s: already downloaded to %s\n", productFileName(f), f.LocalPath)
+			continue
+		}
+
+		c.fileNamesMutex.Lock()
+		c.fileNames[f.ID] = productFileName(f)
+		c.fileNamesMutex.Unlock()
+
+		err := c.broadcom.downloadNow(DownloadRequest{
+			ProductSlug: productSlug,
+			ReleaseID:   release.ID,
+			Version:     release.Version,
+			FileID:      f.ID,
+			FileName:    f.Name,
+			OutputDir:   outputDir,
+		})
+		if err != nil {
+			fmt.Fprintf(c.stderr, "\nError: %s: %v\n", productFileName(f), err)
+			failures++
+		}
+	}
+
+	if failures > 0 {
+		return c.fail("%d of %d downloads failed", failures, len(matched))
+	}
+	return exitOK
+}
+
+// runPlan resolves the compatible tile releases for an Ops Manager and Elastic Runtime pair
+func (c *cli) runPlan(args []string) int {
+	fs := c.newFlagSet("plan")
+	runtimeType := fs.String("type", RuntimeTypeFull, "Elastic Runtime flavour: full or srt")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 2 {
+		return c.usageError("plan")
+	}
+
+	plan, err := c.broadcom.PlanDownloads(positional[0], positional[1], *runtimeType)
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		return c.printJSON(plan)
+	}
+
+	rows := [][]string{}
+	for _, product := range plan.Products {
+		for _, f := range product.Files {
+			rows = append(rows, []string{product.ProductSlug, product.Version, fmt.Sprint(f.ID), productFileName(f)})
+		}
+	}
+	c.printTable([]string{"PRODUCT", "VERSION", "FILE ID", "FILE"}, rows)
+	for _, warning := range plan.Warnings {
+		fmt.Fprintf(c.stderr, "Warning: %s\n", warning)
+	}
+	return exitOK
+}
+
+// runExport writes completed downloads into a signed air-gap bundle
+func (c *cli) runExport(args []string) int {
+	fs := c.newFlagSet("export")
+	output := fs.String("output", "", "bundle directory, or tarball path with --tar")
+	asTarball := fs.Bool("tar", false, "write a single .tar file instead of a directory")
+	positional, err := parseArgs(fs, args)
+	if err != nil || *output == "" {
+		return c.usageError("export")
+	}
+
+	fileIDs := make([]int, 0, len(positional))
+	var downloads []DownloadJob
+	for _, arg := range positional {
+		if fileID, err := strconv.Atoi(arg); err == nil {
+			fileIDs = append(fileIDs, fileID)
+			continue
+		}
+
+		// A path names the completed download stored there
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return c.failErr(err)
+		}
+		if downloads == nil {
+			if downloads, err = c.broadcom.exportableDownloads(nil); err != nil {
+				return c.failErr(err)
+			}
+		}
+		found := false
+		for _, job := range downloads {
+			if job.Path == path {
+				fileIDs = append(fileIDs, job.FileID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return c.fail("%s is not a completed download", arg)
+		}
+	}
+
+	result, err := c.broadcom.ExportBundle(fileIDs, *output, *asTarball)
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	fmt.Fprintf(c.stdout, "Exported %d files to %s\n", len(result.Manifest.Files), result.Location)
+	fmt.Fprintf(c.stdout, "Signing key fingerprint: %s\n", result.KeyFingerprint)
+	return exitOK
+}
+
+// runImport verifies an air-gap bundle and lays its files into the download location
+func (c *cli) runImport(args []string) int {
+	fs := c.newFlagSet("import")
+	verifyOnly := fs.Bool("verify-only", false, "check the bundle without copying files")
+	trustKey := fs.String("trust-key", "", "add this PEM public key to the trusted bundle signers first")
+	allowUntrusted := fs.Bool("allow-untrusted", false, "import a bundle whose signing key is not trusted")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 1 {
+		return c.usageError("import")
+	}
+
+	if *trustKey != "" {
+		data, err := os.ReadFile(*trustKey)
+		if err != nil {
+			return c.failErr(err)
+		}
+		fingerprint, err := c.broadcom.TrustBundleKey(string(data))
+		if err != nil {
+			return c.failErr(err)
+		}
+		fmt.Fprintf(c.stderr, "Trusted signing key %s\n", fingerprint)
+	}
+
+	var report *BundleImportReport
+	if *verifyOnly {
+		report, err = c.broadcom.VerifyBundle(positional[0])
+	} else {
+		report, err = c.broadcom.ImportBundle(positional[0], *allowUntrusted)
+	}
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		if code := c.printJSON(report); code != exitOK {
+			return code
+		}
+	} else {
+		rows := make([][]string, 0, len(report.Files)+len(report.Extra))
+		for _, f := range report.Files {
+			rows = append(rows, []string{f.ProductSlug, f.Version, f.FileName, f.Status})
+		}
+		for _, name := range report.Extra {
+			rows = append(rows, []string{"", "", name, "extra"})
+		}
+		c.printTable([]string{"PRODUCT", "VERSION", "FILE", "STATUS"}, rows)
+		if !report.Trusted {
+			fmt.Fprintf(c.stderr, "Warning: signing key %s is not in the trusted key list\n", report.KeyFingerprint)
+		}
+	}
+
+	if !report.OK() {
+		return c.fail("%d missing and %d corrupt files in bundle", report.Missing, report.Corrupt)
+	}
+	return exitOK
+}
+
+// runProfiles lists, switches, creates and deletes configuration profiles
+func (c *cli) runProfiles(args []string) int {
+	action := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "list":
+		fs := c.newFlagSet("profiles")
+		asJSON := fs.Bool("json", false, "print JSON instead of a table")
+		positional, err := parseArgs(fs, args)
+		if err != nil || len(positional) != 0 {
+			return c.usageError("profiles")
+		}
+
+		profiles, err := c.broadcom.ListProfiles()
+		if err != nil {
+			return c.failErr(err)
+		}
+		if *asJSON {
+			return c.printJSON(profiles)
+		}
+
+		rows := make([][]string, 0, len(profiles))
+		for _, p := range profiles {
+			active, token := "", "no"
+			if p.Active {
+				active = "*"
+			}
+			if p.HasToken {
+				token = "yes"
+			}
+			rows = append(rows, []string{active, p.Name, token, p.DefaultIaaS, p.DownloadLocation})
+		}
+		c.printTable([]string{"", "NAME", "TOKEN", "IAAS", "DOWNLOAD LOCATION"}, rows)
+		return exitOK
+
+	case "use", "delete":
+		if len(args) != 1 {
+			return c.usageError("profiles")
+		}
+		var err error
+		if action == "use" {
+			err = c.broadcom.SwitchProfile(args[0])
+		} else {
+			err = c.broadcom.DeleteProfile(args[0])
+		}
+		if err != nil {
+			return c.failErr(err)
+		}
+		return exitOK
+
+	case "create":
+		fs := c.newFlagSet("profiles")
+		copyFrom := fs.String("copy-from", "", "copy settings other than the token from this profile")
+		location := fs.String("download-location", "", "download directory of the profile")
+		httpProxy := fs.String("http-proxy", "", "HTTP proxy of the profile")
+		httpsProxy := fs.String("https-proxy", "", "HTTPS proxy of the profile")
+		iaas := fs.String("iaas", "", "default IaaS: "+strings.Join(supportedIaaS, ", "))
+		baseURL := fs.String("base-url", "", "API endpoint of the profile, such as an internal mirror")
+		tokenStdin := fs.Bool("token-stdin", false, "read the API token of the profile from standard input")
+		positional, err := parseArgs(fs, args)
+		if err != nil || len(positional) != 1 {
+			return c.usageError("profiles")
+		}
+		name := positional[0]
+
+		if *iaas != "" {
+			if *iaas, err = validateIaaS(*iaas); err != nil {
+				return c.failErr(err)
+			}
+		}
+		if *baseURL, err = normalizeBaseURL(*baseURL); err != nil {
+			return c.failErr(err)
+		}
+		if err := c.broadcom.CreateProfile(name, *copyFrom); err != nil {
+			return c.failErr(err)
+		}
+
+		err = c.broadcom.updateProfile(name, func(profile *Profile) error {
+			if *location != "" {
+				profile.DownloadLocation = *location
+			}
+			if *httpProxy != "" {
+				profile.HTTPProxy = *httpProxy
+			}
+			if *httpsProxy != "" {
+				profile.HTTPSProxy = *httpsProxy
+			}
+			if *iaas != "" {
+				profile.DefaultIaaS = *iaas
+			}
+			if *baseURL != "" && *baseURL != defaultBaseURL {
+				profile.BaseURL = *baseURL
+			}
+			return nil
+		})
+		if err != nil {
+			return c.failErr(err)
+		}
+
+		if *tokenStdin {
+			token, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return c.failErr(err)
+			}
+			if err := c.broadcom.setProfileToken(name, string(token)); err != nil {
+				return c.failErr(err)
+			}
+		}
+		fmt.Fprintf(c.stderr, "Created profile %s\n", name)
+		return exitOK
+	}
+
+	return c.usageError("profiles")
+}
+
+// runHistory lists recorded downloads, newest first
+func (c *cli) runHistory(args []string) int {
+	fs := c.newFlagSet("history")
+	var query HistoryQuery
+	fs.StringVar(&query.Profile, "profile", "", "only downloads made with this profile")
+	fs.StringVar(&query.Product, "product", "", "part of the product slug or name")
+	fs.StringVar(&query.Version, "version", "", "release version")
+	fs.StringVar(&query.Outcome, "outcome", "", "done, failed or cancelled")
+	fs.StringVar(&query.Search, "search", "", "part of the product, version, file name or path")
+	fs.IntVar(&query.Limit, "limit", 0, "show only the newest N downloads")
+	since := fs.String("since", "", "finished on or after a date (2006-01-02 or RFC 3339) or an age such as 30d or 12h")
+	until := fs.String("until", "", "finished before a date or an age")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 0 {
+		return c.usageError("history")
+	}
+
+	now := time.Now()
+	if query.Since, err = parseHistoryTime(*since, now); err != nil {
+		return c.fail("%v", err)
+	}
+	if query.Until, err = parseHistoryTime(*until, now); err != nil {
+		return c.fail("%v", err)
+	}
+
+	entries, err := c.broadcom.QueryDownloadHistory(query)
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		return c.printJSON(entries)
+	}
+
+	rows := make([][]string, 0, len(entries))
+	for _, e := range entries {
+		product := e.ProductName
+		if product == "" {
+			product = e.ProductSlug
+		}
+		size := ""
+		if e.Size > 0 {
+			size = formatBytes(e.Size)
+		}
+		rows = append(rows, []string{
+			e.FinishedAt.Local().Format("2006-01-02 15:04"),
+			e.Profile,
+			product,
+			e.Version,
+			e.FileName,
+			size,
+			time.Duration(e.Duration * float64(time.Second)).Round(time.Second).String(),
+			e.Outcome,
+		})
+	}
+	c.printTable([]string{"FINISHED", "PROFILE", "PRODUCT", "VERSION", "FILE", "SIZE", "DURATION", "OUTCOME"}, rows)
+	return exitOK
+}
+
+// runInventory lists the files in the download location of the active profile
+func (c *cli) runInventory(args []string) int {
+	fs := c.newFlagSet("inventory")
+	verify := fs.Bool("verify", false, "hash files that have not been hashed yet")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 0 {
+		return c.usageError("inventory")
+	}
+
+	files, err := c.broadcom.ScanInventory(*verify)
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		return c.printJSON(files)
+	}
+
+	rows := make([][]string, 0, len(files))
+	for _, f := range files {
+		rows = append(rows, []string{f.Path, formatBytes(f.Size), f.ModTime.Local().Format("2006-01-02 15:04"), f.SHA256})
+	}
+	c.printTable([]string{"PATH", "SIZE", "MODIFIED", "SHA256"}, rows)
+	return exitOK
+}
+
+// runLayout shows or sets how downloads are arranged below the download location
+func (c *cli) runLayout(args []string) int {
+	if len(args) > 0 && args[0] == "set" {
+		if len(args) != 2 {
+			return c.usageError("layout")
+		}
+		if err := c.broadcom.SetDownloadLayout(args[1]); err != nil {
+			return c.failErr(err)
+		}
+		args = nil
+	}
+
+	fs := c.newFlagSet("layout")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 0 {
+		return c.usageError("layout")
+	}
+
+	layout, err := c.broadcom.GetDownloadLayout()
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		return c.printJSON(map[string]interface{}{
+			"layout":  layout,
+			"presets": c.broadcom.GetDownloadLayoutPresets(),
+		})
+	}
+
+	fmt.Fprintf(c.stdout, "Layout: %s\n\n", layout)
+	rows := [][]string{}
+	for _, preset := range c.broadcom.GetDownloadLayoutPresets() {
+		rows = append(rows, []string{preset.Name, preset.Template, preset.Description})
+	}
+	c.printTable([]string{"PRESET", "TEMPLATE", "DESCRIPTION"}, rows)
+	return exitOK
+}
+
+// parseHistoryTime reads a date, an RFC 3339 time or an age in days or any
+// Go duration before now. An empty value is the zero time.
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if age, err := time.ParseDuration(value); err == nil && age >= 0 {
+		return now.Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected a date such as 2006-01-02 or an age such as 30d", value)
+}
+
+// runConnectivity checks each hop between this machine and the API
+func (c *cli) runConnectivity(args []string) int {
+	fs := c.newFlagSet("connectivity")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 0 {
+		return c.usageError("connectivity")
+	}
+
+	report, err := c.broadcom.TestConnectivity()
+	if err != nil {
+		return c.failErr(err)
+	}
+
+	if *asJSON {
+		if code := c.printJSON(report); code != exitOK {
+			return code
+		}
+	} else {
+		rows := make([][]string, 0, len(report.Hops))
+		for _, hop := range report.Hops {
+			status := "ok"
+			if !hop.OK {
+				status = "FAILED"
+			}
+			rows = append(rows, []string{hop.Name, hop.Target, status, fmt.Sprintf("%dms", hop.DurationMs)})
+		}
+		c.printTable([]string{"HOP", "TARGET", "STATUS", "TIME"}, rows)
+	}
+
+	if !report.OK {
+		failed := report.Hops[len(report.Hops)-1]
+		fmt.Fprintf(c.stderr, "%s\n", failed.Hint)
+		return c.fail("%s hop failed: %s", failed.Name, failed.Error)
+	}
+	return exitOK
+}
+
+// runMockServer serves the fake Broadcom API until the process is stopped
+func (c *cli) runMockServer(args []string) int {
+	fs := c.newFlagSet("mock-server")
+	listen := fs.String("listen", "127.0.0.1:8080", "address to listen on")
+	fixtures := fs.String("fixtures", "", "directory with fixture files (defaults to the bundled fixtures)")
+	refreshToken := fs.String("refresh-token", "", "only accept this API token (defaults to any token)")
+	pageSize := fs.Int("page-size", 0, "split collections into pages of this size")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 0 {
+		return c.usageError("mock-server")
+	}
+
+	var fixturesFS iofs.FS
+	if *fixtures != "" {
+		if _, err := os.Stat(filepath.Join(*fixtures, "products.json")); err != nil {
+			return c.fail("%s does not look like a fixtures directory: %v", *fixtures, err)
+		}
+		fixturesFS = os.DirFS(*fixtures)
+	}
+	server := fakepivnet.New(fixturesFS)
+	server.RefreshToken = *refreshToken
+	server.PageSize = *pageSize
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return c.failErr(err)
+	}
+	baseURL := "http://" + listener.Addr().String()
+	token := *refreshToken
+	if token == "" {
+		token = "any-token"
+	}
+	fmt.Fprintf(c.stderr, "Fake Broadcom API listening on %s\n", baseURL)
+	fmt.Fprintf(c.stderr, "Use it with: %s=%s TILE_DOWNLOADER_API_TOKEN=%s tile-downloader products\n", baseURLEnv, baseURL, token)
+
+	if err := http.Serve(listener, server); err != nil {
+		return c.failErr(err)
+	}
+	return exitOK
+}
+
+// runModel downloads and packages an AI model from HuggingFace
+func (c *cli) runModel(args []string) int {
+	fs := c.newFlagSet("model")
+	name := fs.String("name", "", "model name used for the output file or directory")
+	output := fs.String("output", "", "download directory (defaults to the configured download location)")
+	positional, err := parseArgs(fs, args)
+	if err != nil || len(positional) != 2 {
+		return c.usageError("model")
+	}
+	modelType, repoURL := ModelType(positional[0]), positional[1]
+
+	outputDir := *output
+	if outputDir == "" {
+		if outputDir, err = c.broadcom.GetDownloadLocation(); err != nil {
+			return c.failErr(err)
+		}
+	}
+	c.aiModel.SetDownloadLocation(outputDir)
+
+	modelName := *name
+	if modelName == "" {
+		modelName = defaultModelName(repoURL)
+	}
+
+	switch modelType {
+	case ModelTypeVLLM:
+		err = c.aiModel.DownloadVLLMModel(repoURL, modelName)
+	case ModelTypeOllama:
+		err = c.aiModel.DownloadOllamaModel(repoURL, modelName)
+	default:
+		return c.usageError("model")
+	}
+
+	if err != nil {
+		return c.failErr(err)
+	}
+	return exitOK
+}
+
+// defaultModelName derives a model name from a HuggingFace URL,
+// using the last path segment (the repo name or quantization folder)
+func defaultModelName(repoURL string) string {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(repoURL, "https://huggingface.co/"), "/"), "/")
+	return parts[len(parts)-1]
+}
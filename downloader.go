@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DownloadEngineNative downloads product files directly over HTTP
+	DownloadEngineNative = "native"
+	// DownloadEngineOM downloads product files with the bundled OM CLI
+	DownloadEngineOM = "om"
+
+	// partialSuffix is appended to files that are still being downloaded
+	partialSuffix = ".partial"
+
+	// progressInterval is the minimum time between download-progress events
+	progressInterval = 500 * time.Millisecond
+)
+
+var (
+	// errDownloadCancelled is returned when a transfer is stopped through CancelDownload
+	errDownloadCancelled = errors.New("download cancelled")
+	// errDownloadPaused is returned when a transfer is stopped through PauseDownload
+	errDownloadPaused = errors.New("download paused")
+)
+
+// downloadStatusError is an unexpected response from the object store that
+// serves product files
+type downloadStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *downloadStatusError) Error() string {
+	return fmt.Sprintf("download failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+// productFileName returns the on-disk file name for a product file.
+// The AWS object key holds the real file name; the display name is a fallback.
+func productFileName(file ProductFile) string {
+	if file.AWSObjectKey != "" {
+		parts := strings.Split(file.AWSObjectKey, "/")
+		if name := parts[len(parts)-1]; name != "" {
+			return name
+		}
+	}
+	return file.Name
+}
+
+// progressWriter counts bytes written to a file and emits throttled progress events
+type progressWriter struct {
+	b          *BroadcomService
+	fileID     int
+	downloaded int64
+	total      int64
+	lastEmit   time.Time
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	p.downloaded += int64(len(data))
+	if time.Since(p.lastEmit) >= progressInterval {
+		p.emit()
+	}
+	return len(data), nil
+}
+
+// emit sends the current byte counts as a download-progress event
+func (p *progressWriter) emit() {
+	p.lastEmit = time.Now()
+
+	var percentage float64
+	if p.total > 0 {
+		percentage = float64(p.downloaded) / float64(p.total) * 100
+	}
+
+	p.b.queue.recordProgress(p.fileID, p.downloaded, p.total)
+
+	p.b.emit("download-progress", map[string]interface{}{
+		"fileID":     p.fileID,
+		"progress":   percentage,
+		"downloaded": p.downloaded,
+		"total":      p.total,
+		"totalSize":  p.total,
+		"status":     "Downloading...",
+	})
+}
+
+// DownloadProductFile downloads a single product file with the native HTTP downloader
+func (b *BroadcomService) DownloadProductFile(productSlug string, releaseID int, fileID int, savePath string) error {
+	version, err := b.releaseVersion(productSlug, releaseID)
+	if err != nil {
+		return err
+	}
+
+	files, err := b.GetReleaseFiles(productSlug, releaseID)
+	if err != nil {
+		return fmt.Errorf("failed to get files: %w", err)
+	}
+
+	for _, file := range files {
+		if file.ID == fileID {
+			destPath, err := b.productFilePath(savePath, productSlug, version, file)
+			if err != nil {
+				return err
+			}
+			_, err = b.downloadProductFile(productSlug, releaseID, file, destPath)
+			return err
+		}
+	}
+
+	return fmt.Errorf("could not find file ID %d in release %d", fileID, releaseID)
+}
+
+// downloadProductFile follows the product file download link to the signed object URL
+// and streams it into destPath. An existing partial file is resumed with an HTTP Range
+// request. It returns the path of the completed file, or the path of the partial file
+// with errDownloadPaused when the transfer was paused or with the error it failed with.
+func (b *BroadcomService) downloadProductFile(productSlug string, releaseID int, file ProductFile, destPath string) (string, error) {
+	if b.currentToken() == "" {
+		return "", b.missingTokenError()
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	partPath := destPath + partialSuffix
+
+	// A complete file of the expected size does not need to be fetched again
+	if info, err := os.Stat(destPath); err == nil && file.Size > 0 && info.Size() == file.Size {
+		if err := b.verifyDownload(destPath, file); err != nil {
+			return "", err
+		}
+		b.emit("download-complete", map[string]interface{}{
+			"fileID": file.ID,
+			"path":   destPath,
+		})
+		return destPath, nil
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	b.downloadsMutex.Lock()
+	b.activeTransfers[file.ID] = cancel
+	if cause := b.pendingStops[file.ID]; cause != nil {
+		// Stopped while the download was being prepared
+		cancel(cause)
+	}
+	b.downloadsMutex.Unlock()
+
+	defer func() {
+		b.downloadsMutex.Lock()
+		delete(b.activeTransfers, file.ID)
+		b.downloadsMutex.Unlock()
+	}()
+
+	signedURL, err := b.resolveDownloadURL(ctx, productSlug, releaseID, file.ID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return stoppedTransfer(ctx, partPath)
+		}
+		return "", err
+	}
+
+	if err := b.fetchToFile(ctx, signedURL, partPath, file); err != nil {
+		if ctx.Err() != nil {
+			return stoppedTransfer(ctx, partPath)
+		}
+		return partPath, err
+	}
+
+	if err := os.Rename(partPath, destPath); err != nil {
+		return "", fmt.Errorf("failed to finalize download: %w", err)
+	}
+
+	if err := b.verifyDownload(destPath, file); err != nil {
+		return "", err
+	}
+
+	b.emit("download-complete", map[string]interface{}{
+		"fileID": file.ID,
+		"path":   destPath,
+	})
+
+	return destPath, nil
+}
+
+// stoppedTransfer handles a transfer whose context was cancelled. A paused
+// transfer keeps its partial file for ResumeDownload, as does one stopped by the
+// end of a download window; a cancelled one discards it.
+func stoppedTransfer(ctx context.Context, partPath string) (string, error) {
+	if cause := context.Cause(ctx); errors.Is(cause, errDownloadPaused) || errors.Is(cause, errDownloadWindowClosed) {
+		return partPath, cause
+	}
+	if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Could not remove %s: %v\n", partPath, err)
+	}
+	return "", errDownloadCancelled
+}
+
+// resolveDownloadURL asks the API for a product file download and returns the signed
+// object URL it redirects to
+func (b *BroadcomService) resolveDownloadURL(ctx context.Context, productSlug string, releaseID int, fileID int) (string, error) {
+	url := fmt.Sprintf("%s/api/v2/products/%s/releases/%d/product_files/%d/download", b.apiBaseURL(), productSlug, releaseID, fileID)
+
+	// Stop at the redirect so the signed URL is requested without our Authorization header
+	resp, err := b.apiDo(ctx, http.MethodPost, url, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 || location == "" {
+		return "", fmt.Errorf("download request for file %d was not redirected (status %d)", fileID, resp.StatusCode)
+	}
+	return location, nil
+}
+
+// fetchToFile downloads url into partPath, appending to any bytes already on disk
+func (b *BroadcomService) fetchToFile(ctx context.Context, url string, partPath string, file ProductFile) error {
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	// The partial file already holds everything
+	if file.Size > 0 && offset == file.Size {
+		return nil
+	}
+	if file.Size > 0 && offset > file.Size {
+		offset = 0
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	client, err := b.createHTTPClient()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			if offset == 0 {
+				return fmt.Errorf("server answered from an unexpected offset: %q", resp.Header.Get("Content-Range"))
+			}
+			// Appending bytes from another offset would corrupt the file; start over
+			resp.Body.Close()
+			if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return b.fetchToFile(ctx, url, partPath, file)
+		}
+		flags |= os.O_APPEND
+	case http.StatusOK:
+		// Server ignored the range, start over
+		offset = 0
+		flags |= os.O_TRUNC
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset > 0 && file.Size <= 0 {
+			return nil
+		}
+		return fmt.Errorf("server rejected resume at offset %d", offset)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &downloadStatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	total := file.Size
+	if total <= 0 && resp.ContentLength > 0 {
+		total = offset + resp.ContentLength
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", partPath, err)
+	}
+
+	progress := &progressWriter{b: b, fileID: file.ID, downloaded: offset, total: total}
+	progress.emit()
+
+	body := b.queue.throttle(ctx, file.ID, resp.Body)
+	_, err = io.Copy(out, io.TeeReader(body, progress))
+	closeErr := out.Close()
+	if err != nil {
+		// Record the exact offset, which a paused job resumes from
+		b.queue.recordProgress(file.ID, progress.downloaded, progress.total)
+		return fmt.Errorf("download interrupted: %w", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write %s: %w", partPath, closeErr)
+	}
+
+	progress.emit()
+
+	if total > 0 && progress.downloaded != total {
+		return fmt.Errorf("download incomplete: got %d of %d bytes: %w", progress.downloaded, total, io.ErrUnexpectedEOF)
+	}
+
+	return nil
+}
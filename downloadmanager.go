@@ -0,0 +1,736 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// JobState is the lifecycle state of a queued download
+type JobState string
+
+const (
+	JobQueued  JobState = "queued"  // Waiting for a free download slot
+	JobRunning JobState = "running" // Transfer in progress
+	JobPaused  JobState = "paused"  // Held back until resumed
+	JobFailed  JobState = "failed"  // Stopped with an error
+	JobDone    JobState = "done"    // Downloaded and verified
+)
+
+// defaultMaxParallelDownloads is used when the config does not set a limit
+const defaultMaxParallelDownloads = 3
+
+// DownloadRequest describes a product file to add to the download queue
+type DownloadRequest struct {
+	ProductSlug string `json:"product_slug"`
+	ProductName string `json:"product_name"`
+	ReleaseID   int    `json:"release_id"`
+	Version     string `json:"version"`
+	FileID      int    `json:"file_id"`
+	FileName    string `json:"file_name"`
+	OutputDir   string `json:"output_dir"`
+	Priority    int    `json:"priority"`
+	Profile     string `json:"profile,omitempty"`     // Profile whose token and settings the download uses
+	MaxRetries  *int   `json:"max_retries,omitempty"` // Automatic retries of this job, the configured default when unset
+
+	BandwidthLimit int64 `json:"bandwidth_limit,omitempty"` // Bytes per second for this job, zero for no own cap
+}
+
+// DownloadJob is a download owned by the backend queue. Jobs are keyed by file ID.
+type DownloadJob struct {
+	DownloadRequest
+	State       JobState  `json:"state"`
+	Progress    float64   `json:"progress"`
+	Downloaded  int64     `json:"downloaded"`
+	Total       int64     `json:"total"`
+	Path        string    `json:"path,omitempty"`
+	ObjectName  string    `json:"object_name,omitempty"`  // Product file name the download layout was applied to
+	PartialPath string    `json:"partial_path,omitempty"` // Partial file a paused, deferred or failed job left behind
+	SHA256      string    `json:"sha256,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Retries     int       `json:"retries"`              // Automatic retries so far
+	LastError   string    `json:"last_error,omitempty"` // Most recent failure, also while a retry is pending
+	NextRetry   time.Time `json:"next_retry_at,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	FinishedAt  time.Time `json:"finished_at,omitempty"`
+}
+
+// downloadManager schedules queued downloads within the concurrency limit
+// and persists the queue so unfinished work survives a restart
+type downloadManager struct {
+	b       *BroadcomService
+	mu      sync.Mutex
+	jobs    map[int]*DownloadJob
+	running int
+	loaded  bool
+	started bool
+
+	wake     *time.Timer // Runs schedule when a retry is due or a download window opens or closes
+	wakeTime time.Time
+
+	bandwidth   *rateLimiter         // Global cap shared by all transfers
+	jobLimiters map[int]*rateLimiter // Caps of running jobs by file ID
+	inFlight    map[int]bool         // File IDs whose run has not returned yet
+}
+
+// newDownloadManager creates an empty download manager for a service
+func newDownloadManager(b *BroadcomService) *downloadManager {
+	return &downloadManager{
+		b:           b,
+		jobs:        make(map[int]*DownloadJob),
+		bandwidth:   &rateLimiter{},
+		jobLimiters: make(map[int]*rateLimiter),
+		inFlight:    make(map[int]bool),
+	}
+}
+
+// getQueuePath returns the path to the persisted download queue
+func (m *downloadManager) getQueuePath() (string, error) {
+	configDir, err := m.b.getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "queue.json"), nil
+}
+
+// start loads the persisted queue, requeues jobs that were interrupted
+// and begins scheduling
+func (m *downloadManager) start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.started {
+		return
+	}
+	m.started = true
+	m.ensureLoaded()
+
+	for _, job := range m.jobs {
+		if job.State == JobRunning {
+			// The partial file is resumed when the job runs again.
+			// Paused jobs stay paused until ResumeDownload.
+			job.State = JobQueued
+		}
+	}
+
+	m.changed()
+	m.schedule()
+}
+
+// ensureLoaded reads the persisted queue once. Callers must hold m.mu.
+func (m *downloadManager) ensureLoaded() {
+	if m.loaded {
+		return
+	}
+	m.loaded = true
+
+	if err := m.load(); err != nil {
+		fmt.Printf("Could not load download queue: %v\n", err)
+	}
+}
+
+// completedJobs returns the finished jobs still held by the queue
+func (m *downloadManager) completedJobs() []DownloadJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ensureLoaded()
+
+	var jobs []DownloadJob
+	for _, job := range m.sortedJobs() {
+		if job.State == JobDone {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// load reads the persisted queue from disk. Callers must hold m.mu.
+func (m *downloadManager) load() error {
+	path, err := m.getQueuePath()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var jobs []*DownloadJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		m.jobs[job.FileID] = job
+	}
+	return nil
+}
+
+// save writes the queue to disk. Callers must hold m.mu.
+func (m *downloadManager) save() error {
+	path, err := m.getQueuePath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(m.sortedJobs(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated queue
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// sortedJobs returns copies of all jobs ordered by priority, then age.
+// Callers must hold m.mu.
+func (m *downloadManager) sortedJobs() []DownloadJob {
+	jobs := make([]DownloadJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Priority != jobs[j].Priority {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// changed persists the queue and notifies the frontend. Callers must hold m.mu.
+func (m *downloadManager) changed() {
+	if err := m.save(); err != nil {
+		fmt.Printf("Could not save download queue: %v\n", err)
+	}
+	windowOpen, _ := m.downloadWindow(time.Now())
+	m.b.emit("download-queue-updated", map[string]interface{}{
+		"jobs":       m.sortedJobs(),
+		"windowOpen": windowOpen,
+	})
+}
+
+// maxParallel returns the configured concurrency limit
+func (m *downloadManager) maxParallel() int {
+	config, err := m.b.loadConfig()
+	if err != nil {
+		return defaultMaxParallelDownloads
+	}
+	return maxParallelDownloads(config)
+}
+
+// maxParallelDownloads returns the concurrency limit set in config
+func maxParallelDownloads(config *Config) int {
+	if config.MaxParallelDownloads <= 0 {
+		return defaultMaxParallelDownloads
+	}
+	return config.MaxParallelDownloads
+}
+
+// maxRetries returns how often a failed job is retried automatically
+func (m *downloadManager) maxRetries(job *DownloadJob) int {
+	if job.MaxRetries != nil {
+		return *job.MaxRetries
+	}
+	config, err := m.b.loadConfig()
+	if err != nil || config.DownloadRetries == nil {
+		return defaultDownloadRetries
+	}
+	return *config.DownloadRetries
+}
+
+// schedule starts queued jobs, highest priority first, until the concurrency
+// limit is reached. Callers must hold m.mu.
+func (m *downloadManager) schedule() {
+	if !m.started {
+		return
+	}
+
+	// The settings are read once, as the queue stays locked meanwhile
+	config, err := m.b.loadConfig()
+	if err != nil {
+		config = &Config{}
+	}
+	activeProfile := m.b.activeProfileName()
+
+	now := time.Now()
+	open, windowChange := downloadWindowState(config.DownloadWindows, now)
+	if !windowChange.IsZero() {
+		m.wakeAt(windowChange)
+	}
+	if !open {
+		m.deferRunning()
+		return
+	}
+
+	limit := maxParallelDownloads(config)
+	var nextRetry time.Time
+	started := false
+	for _, candidate := range m.sortedJobs() {
+		if m.running >= limit {
+			break
+		}
+		if candidate.State != JobQueued {
+			continue
+		}
+		if m.inFlight[candidate.FileID] {
+			// Paused, removed or deferred and queued again while its transfer
+			// was stopping; run starts it again once the transfer has returned
+			continue
+		}
+		if candidate.Profile != "" && candidate.Profile != activeProfile {
+			// Waits until its profile is active again
+			continue
+		}
+		if candidate.NextRetry.After(now) {
+			// Backing off after a failure
+			if nextRetry.IsZero() || candidate.NextRetry.Before(nextRetry) {
+				nextRetry = candidate.NextRetry
+			}
+			continue
+		}
+
+		job := m.jobs[candidate.FileID]
+		job.State = JobRunning
+		job.Error = ""
+		job.NextRetry = time.Time{}
+		job.StartedAt = time.Now()
+		m.running++
+		m.inFlight[job.FileID] = true
+		started = true
+
+		go m.run(job.FileID, job.DownloadRequest)
+	}
+	if started {
+		m.changed()
+	}
+
+	if !nextRetry.IsZero() {
+		m.wakeAt(nextRetry)
+	}
+}
+
+// wakeAt makes schedule run again at the given time. An earlier pending
+// wake-up is kept, since schedule arms the next one itself. Callers must hold m.mu.
+func (m *downloadManager) wakeAt(at time.Time) {
+	if m.wake != nil && m.wakeTime.After(time.Now()) && !m.wakeTime.After(at) {
+		return
+	}
+	if m.wake != nil {
+		m.wake.Stop()
+	}
+	m.wakeTime = at
+	m.wake = time.AfterFunc(time.Until(at), func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.schedule()
+	})
+}
+
+// deferRunning stops running jobs outside the download windows and puts them
+// back in the queue. Native transfers keep their partial file. Callers must hold m.mu.
+func (m *downloadManager) deferRunning() {
+	for fileID, job := range m.jobs {
+		if job.State != JobRunning {
+			continue
+		}
+		job.State = JobQueued
+		m.changed()
+		if err := m.b.stopDownload(fileID, errDownloadWindowClosed); err != nil {
+			fmt.Printf("Could not stop download of %s: %v\n", job.FileName, err)
+		}
+	}
+}
+
+// run downloads a job and records the outcome
+func (m *downloadManager) run(fileID int, req DownloadRequest) {
+	path, file, err := m.b.acceptEULAAndDownload(req.ProductSlug, req.ReleaseID, req.Version, req.FileID, req.OutputDir)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.running--
+	delete(m.inFlight, fileID)
+	delete(m.jobLimiters, fileID)
+	job, exists := m.jobs[fileID]
+	if !exists {
+		// Removed while the transfer was stopping
+		m.schedule()
+		return
+	}
+	if job.State == JobPaused && err != nil {
+		// The transfer has stopped; its offset is kept for ResumeDownload.
+		// A download paused before its transfer started keeps the earlier offset.
+		if errors.Is(err, errDownloadPaused) && path != "" {
+			job.PartialPath = path
+		}
+		m.changed()
+		m.b.emit("download-paused", pausedEventData(job))
+		m.schedule()
+		return
+	}
+	if job.State == JobQueued && err != nil {
+		// Deferred to the next download window, resumed or queued again
+		// while the transfer was stopping
+		if (errors.Is(err, errDownloadWindowClosed) || errors.Is(err, errDownloadPaused)) && path != "" {
+			job.PartialPath = path
+			m.changed()
+		}
+		m.schedule()
+		return
+	}
+
+	job.FinishedAt = time.Now()
+	switch {
+	case err == nil:
+		job.State = JobDone
+		job.Path = path
+		job.ObjectName = productFileName(*file)
+		job.PartialPath = ""
+		job.Progress = 100
+		job.SHA256 = file.SHA256
+		if file.Size > 0 {
+			job.Total = file.Size
+			job.Downloaded = file.Size
+		}
+		m.recordHistory(job, path, file, err)
+	case errors.Is(err, errDownloadCancelled):
+		delete(m.jobs, fileID)
+		m.recordHistory(job, path, file, err)
+	default:
+		// The partial file of a failed transfer is resumed by the retry, or
+		// deleted with the job
+		if path != "" {
+			job.PartialPath = path
+		}
+		job.LastError = err.Error()
+		if maxRetries := m.maxRetries(job); job.Retries < maxRetries && isRetryableDownload(err) {
+			job.Retries++
+			wait := backoff(job.Retries, err)
+			job.State = JobQueued
+			job.NextRetry = time.Now().Add(wait)
+			m.b.emit("download-retrying", map[string]interface{}{
+				"fileID":     fileID,
+				"attempt":    job.Retries,
+				"maxRetries": maxRetries,
+				"retryIn":    int64(wait.Seconds()),
+				"error":      job.LastError,
+			})
+			break
+		}
+		job.State = JobFailed
+		job.Error = err.Error()
+		m.recordHistory(job, path, file, err)
+	}
+
+	m.changed()
+	m.schedule()
+}
+
+// recordHistory adds the final outcome of a job to the download history
+func (m *downloadManager) recordHistory(job *DownloadJob, path string, file *ProductFile, err error) {
+	entry := newHistoryEntry(job.DownloadRequest, job.StartedAt, path, file, err)
+	entry.Retries = job.Retries
+	if entry.Size == 0 {
+		entry.Size = job.Total
+	}
+	m.b.recordHistory(entry)
+}
+
+// recordCancelled records a removed job that had started and not yet finished
+func (m *downloadManager) recordCancelled(job *DownloadJob) {
+	if job.StartedAt.IsZero() || job.State == JobDone || job.State == JobFailed {
+		return
+	}
+	m.recordHistory(job, "", nil, errDownloadCancelled)
+}
+
+// recordProgress stores the latest byte counts of a running job
+func (m *downloadManager) recordProgress(fileID int, downloaded int64, total int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.jobs[fileID]
+	if !exists {
+		return
+	}
+	job.Downloaded = downloaded
+	job.Total = total
+	if total > 0 {
+		job.Progress = float64(downloaded) / float64(total) * 100
+	}
+}
+
+// EnqueueDownload adds a product file to the backend download queue
+func (b *BroadcomService) EnqueueDownload(req DownloadRequest) error {
+	if req.ProductSlug == "" || req.ReleaseID == 0 || req.FileID == 0 {
+		return fmt.Errorf("product slug, release ID and file ID are required")
+	}
+
+	if req.Profile == "" {
+		req.Profile = b.activeProfileName()
+	}
+	if req.OutputDir == "" {
+		profiles, err := b.ListProfiles()
+		if err != nil {
+			return err
+		}
+		for _, profile := range profiles {
+			if profile.Name == req.Profile {
+				req.OutputDir = profile.DownloadLocation
+			}
+		}
+		if req.OutputDir == "" {
+			return fmt.Errorf("profile %q does not exist", req.Profile)
+		}
+	}
+
+	m := b.queue
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, exists := m.jobs[req.FileID]; exists {
+		switch existing.State {
+		case JobQueued, JobRunning, JobPaused:
+			return fmt.Errorf("%s is already in the download queue", req.FileName)
+		}
+	}
+
+	m.jobs[req.FileID] = &DownloadJob{
+		DownloadRequest: req,
+		State:           JobQueued,
+		CreatedAt:       time.Now(),
+	}
+
+	m.changed()
+	m.schedule()
+	return nil
+}
+
+// GetDownloadQueue returns all jobs ordered by priority
+func (b *BroadcomService) GetDownloadQueue() []DownloadJob {
+	b.queue.mu.Lock()
+	defer b.queue.mu.Unlock()
+	return b.queue.sortedJobs()
+}
+
+// SetDownloadPriority changes the priority of a job; higher priorities start first
+func (b *BroadcomService) SetDownloadPriority(fileID int, priority int) error {
+	m := b.queue
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.jobs[fileID]
+	if !exists {
+		return fmt.Errorf("no download found for file ID %d", fileID)
+	}
+
+	job.Priority = priority
+	m.changed()
+	m.schedule()
+	return nil
+}
+
+// RetryDownload puts a failed job back into the queue with a fresh set of
+// automatic retries
+func (b *BroadcomService) RetryDownload(fileID int) error {
+	m := b.queue
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.jobs[fileID]
+	if !exists {
+		return fmt.Errorf("no download found for file ID %d", fileID)
+	}
+	if job.State != JobFailed {
+		return fmt.Errorf("download of %s has not failed", job.FileName)
+	}
+
+	job.State = JobQueued
+	job.Error = ""
+	job.Retries = 0
+	m.changed()
+	m.schedule()
+	return nil
+}
+
+// RemoveDownload removes a job from the queue, stopping it first if it is running
+func (b *BroadcomService) RemoveDownload(fileID int) error {
+	m := b.queue
+	m.mu.Lock()
+	job, exists := m.jobs[fileID]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("no download found for file ID %d", fileID)
+	}
+	wasRunning := job.State == JobRunning
+	delete(m.jobs, fileID)
+	m.recordCancelled(job)
+	removePartialFile(job)
+	m.changed()
+	m.schedule()
+	m.mu.Unlock()
+
+	if wasRunning {
+		return b.stopDownload(fileID, errDownloadCancelled)
+	}
+	return nil
+}
+
+// PauseDownload stops a queued or running download but keeps it in the queue
+// together with its partial file. Paused jobs stay paused across restarts
+// until ResumeDownload continues them from the saved offset.
+func (b *BroadcomService) PauseDownload(fileID int) error {
+	m := b.queue
+	m.mu.Lock()
+	job, exists := m.jobs[fileID]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("no download found for file ID %d", fileID)
+	}
+	wasRunning := job.State == JobRunning
+	switch job.State {
+	case JobQueued, JobRunning:
+	case JobPaused:
+		m.mu.Unlock()
+		return nil
+	default:
+		m.mu.Unlock()
+		return fmt.Errorf("download of %s is not in progress", job.FileName)
+	}
+
+	job.State = JobPaused
+	m.changed()
+	if !wasRunning {
+		// Nothing to stop; run emits the event once a running transfer has stopped
+		m.b.emit("download-paused", pausedEventData(job))
+	}
+	m.mu.Unlock()
+
+	if wasRunning {
+		return b.stopDownload(fileID, errDownloadPaused)
+	}
+	return nil
+}
+
+// ResumeDownload puts a paused download back into the queue. The transfer
+// continues from the partial file when its turn comes.
+func (b *BroadcomService) ResumeDownload(fileID int) error {
+	m := b.queue
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, exists := m.jobs[fileID]
+	if !exists {
+		return fmt.Errorf("no download found for file ID %d", fileID)
+	}
+	if job.State != JobPaused {
+		return fmt.Errorf("download of %s is not paused", job.FileName)
+	}
+
+	job.State = JobQueued
+	job.Error = ""
+	job.NextRetry = time.Time{}
+	m.b.emit("download-resumed", pausedEventData(job))
+	m.changed()
+	m.schedule()
+	return nil
+}
+
+// pausedEventData describes the saved offset of a paused or resumed job
+func pausedEventData(job *DownloadJob) map[string]interface{} {
+	return map[string]interface{}{
+		"fileID":     job.FileID,
+		"downloaded": job.Downloaded,
+		"total":      job.Total,
+		"progress":   job.Progress,
+	}
+}
+
+// removePartialFile deletes the partial file a paused, deferred or failed job left behind
+func removePartialFile(job *DownloadJob) {
+	if job.PartialPath == "" {
+		return
+	}
+	if err := os.Remove(job.PartialPath); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Could not remove %s: %v\n", job.PartialPath, err)
+	}
+}
+
+// ClearFinishedDownloads removes completed jobs from the queue
+func (b *BroadcomService) ClearFinishedDownloads() {
+	m := b.queue
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for fileID, job := range m.jobs {
+		if job.State == JobDone {
+			delete(m.jobs, fileID)
+		}
+	}
+	m.changed()
+}
+
+// GetMaxParallelDownloads returns how many downloads may run at once
+func (b *BroadcomService) GetMaxParallelDownloads() int {
+	return b.queue.maxParallel()
+}
+
+// GetDownloadRetries returns how often a failed download is retried automatically
+func (b *BroadcomService) GetDownloadRetries() int {
+	config, err := b.loadConfig()
+	if err != nil || config.DownloadRetries == nil {
+		return defaultDownloadRetries
+	}
+	return *config.DownloadRetries
+}
+
+// SetDownloadRetries changes how often a failed download is retried
+// automatically; zero turns automatic retries off
+func (b *BroadcomService) SetDownloadRetries(retries int) error {
+	if retries < 0 {
+		return fmt.Errorf("retries cannot be negative")
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+	config.DownloadRetries = &retries
+	return b.saveConfig(config)
+}
+
+// SetMaxParallelDownloads changes how many downloads may run at once
+func (b *BroadcomService) SetMaxParallelDownloads(limit int) error {
+	if limit < 1 {
+		return fmt.Errorf("at least one parallel download is required")
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+	config.MaxParallelDownloads = limit
+	if err := b.saveConfig(config); err != nil {
+		return err
+	}
+
+	b.queue.mu.Lock()
+	defer b.queue.mu.Unlock()
+	b.queue.schedule()
+	return nil
+}
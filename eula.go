@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// EULAAcceptance records that the EULA of a release was accepted through the API.
+// Acceptances belong to the account of a profile on one API endpoint.
+type EULAAcceptance struct {
+	Profile     string    `json:"profile"`
+	BaseURL     string    `json:"base_url"`
+	ProductSlug string    `json:"product_slug"`
+	ReleaseID   int       `json:"release_id"`
+	AcceptedAt  time.Time `json:"accepted_at"`
+}
+
+// eulaMutex serializes reads and writes of the acceptance record file
+var eulaMutex sync.Mutex
+
+// eulaKey identifies a release of a profile and API endpoint in the acceptance record
+func eulaKey(profile string, baseURL string, productSlug string, releaseID int) string {
+	return fmt.Sprintf("%s@%s/%s/%d", profile, baseURL, productSlug, releaseID)
+}
+
+// currentEULAKey identifies a release for the active profile and API endpoint
+func (b *BroadcomService) currentEULAKey(productSlug string, releaseID int) string {
+	return eulaKey(b.activeProfileName(), b.apiBaseURL(), productSlug, releaseID)
+}
+
+// getEULAAcceptancesPath returns the path to the EULA acceptance record
+func (b *BroadcomService) getEULAAcceptancesPath() (string, error) {
+	configDir, err := b.getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "eula_acceptances.json"), nil
+}
+
+// loadEULAAcceptances reads the EULA acceptance record from disk
+func (b *BroadcomService) loadEULAAcceptances() (map[string]EULAAcceptance, error) {
+	path, err := b.getEULAAcceptancesPath()
+	if err != nil {
+		return nil, err
+	}
+
+	acceptances := make(map[string]EULAAcceptance)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return acceptances, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &acceptances); err != nil {
+		return nil, err
+	}
+	return acceptances, nil
+}
+
+// saveEULAAcceptances writes the EULA acceptance record to disk. Callers must hold eulaMutex.
+func (b *BroadcomService) saveEULAAcceptances(acceptances map[string]EULAAcceptance) error {
+	data, err := json.MarshalIndent(acceptances, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path, err := b.getEULAAcceptancesPath()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// recordEULAAcceptance adds a release of the active profile and API endpoint
+// to the EULA acceptance record
+func (b *BroadcomService) recordEULAAcceptance(productSlug string, releaseID int) error {
+	eulaMutex.Lock()
+	defer eulaMutex.Unlock()
+
+	acceptances, err := b.loadEULAAcceptances()
+	if err != nil {
+		// A corrupt record only costs us a repeated API call
+		acceptances = make(map[string]EULAAcceptance)
+	}
+
+	profile, baseURL := b.activeProfileName(), b.apiBaseURL()
+	acceptances[eulaKey(profile, baseURL, productSlug, releaseID)] = EULAAcceptance{
+		Profile:     profile,
+		BaseURL:     baseURL,
+		ProductSlug: productSlug,
+		ReleaseID:   releaseID,
+		AcceptedAt:  time.Now().UTC(),
+	}
+	return b.saveEULAAcceptances(acceptances)
+}
+
+// forgetEULAAcceptance removes a release of the active profile and API
+// endpoint from the EULA acceptance record
+func (b *BroadcomService) forgetEULAAcceptance(productSlug string, releaseID int) error {
+	eulaMutex.Lock()
+	defer eulaMutex.Unlock()
+
+	acceptances, err := b.loadEULAAcceptances()
+	if err != nil {
+		return err
+	}
+	delete(acceptances, b.currentEULAKey(productSlug, releaseID))
+	return b.saveEULAAcceptances(acceptances)
+}
+
+// IsEULAAccepted reports whether the EULA of a release has already been accepted
+// with the active profile on its API endpoint
+func (b *BroadcomService) IsEULAAccepted(productSlug string, releaseID int) bool {
+	eulaMutex.Lock()
+	defer eulaMutex.Unlock()
+
+	acceptances, err := b.loadEULAAcceptances()
+	if err != nil {
+		return false
+	}
+	_, accepted := acceptances[b.currentEULAKey(productSlug, releaseID)]
+	return accepted
+}
+
+// GetEULAAcceptances returns every release whose EULA has been accepted
+func (b *BroadcomService) GetEULAAcceptances() ([]EULAAcceptance, error) {
+	eulaMutex.Lock()
+	defer eulaMutex.Unlock()
+
+	acceptances, err := b.loadEULAAcceptances()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]EULAAcceptance, 0, len(acceptances))
+	for _, acceptance := range acceptances {
+		result = append(result, acceptance)
+	}
+	return result, nil
+}
+
+// AcceptEULA accepts the EULA of a release through the Broadcom API and records it.
+// Releases that were already accepted with the active profile on its API endpoint
+// are not sent to the API again.
+func (b *BroadcomService) AcceptEULA(productSlug string, releaseID int) error {
+	if b.currentToken() == "" {
+		return b.missingTokenError()
+	}
+
+	if b.IsEULAAccepted(productSlug, releaseID) {
+		return nil
+	}
+
+	path := fmt.Sprintf("/api/v2/products/%s/releases/%d/pivnet_resource_eula_acceptance", productSlug, releaseID)
+	if err := b.apiJSON(http.MethodPost, path, nil); err != nil {
+		return fmt.Errorf("EULA acceptance failed: %w", err)
+	}
+
+	if err := b.recordEULAAcceptance(productSlug, releaseID); err != nil {
+		return fmt.Errorf("EULA accepted but could not be recorded: %w", err)
+	}
+
+	return nil
+}
+
+// reacceptEULA accepts the EULA of a release again after the API reported it
+// as not accepted, even though the record says it was
+func (b *BroadcomService) reacceptEULA(productSlug string, releaseID int) error {
+	if err := b.forgetEULAAcceptance(productSlug, releaseID); err != nil {
+		return fmt.Errorf("failed to reset EULA acceptance: %w", err)
+	}
+	return b.AcceptEULA(productSlug, releaseID)
+}
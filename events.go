@@ -0,0 +1,36 @@
+package main
+
+import (
+	wailsruntime "github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// EventHandler receives backend events when the app runs without a window
+type EventHandler func(name string, data map[string]interface{})
+
+// emit sends an event to the frontend, or to the headless event handler when
+// set. Events are tagged with the active profile.
+func (b *BroadcomService) emit(name string, data map[string]interface{}) {
+	if _, tagged := data["profile"]; !tagged {
+		data["profile"] = b.activeProfileName()
+	}
+	if b.events != nil {
+		b.events(name, data)
+		return
+	}
+	wailsruntime.EventsEmit(b.ctx, name, data)
+}
+
+// emit sends an event to the frontend, or to the headless event handler when
+// set. Events are tagged with the active profile when one is known.
+func (a *AIModelService) emit(name string, data map[string]interface{}) {
+	if a.activeProfile != nil {
+		if _, tagged := data["profile"]; !tagged {
+			data["profile"] = a.activeProfile()
+		}
+	}
+	if a.events != nil {
+		a.events(name, data)
+		return
+	}
+	wailsruntime.EventsEmit(a.ctx, name, data)
+}
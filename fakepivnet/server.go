@@ -0,0 +1,450 @@
+// Package fakepivnet is an in-process stand-in for the Broadcom Support Portal
+// (Pivnet) API. It serves products, releases, product files, dependencies,
+// EULAs and file downloads from fixture files, so the app can be exercised
+// end to end without network access or a real API token.
+//
+// Fixtures are JSON files laid out like the API paths:
+//
+//	products.json                                       {"products": [...]}
+//	eula.json                                           EULA returned with every release
+//	products/<slug>/releases.json                       {"releases": [...]}
+//	products/<slug>/eula.json                           optional EULA of one product
+//	products/<slug>/releases/<id>/product_files.json    {"product_files": [...]}
+//	products/<slug>/releases/<id>/dependencies.json     {"dependencies": [...]}
+//	products/<slug>/releases/<id>/dependency_specifiers.json
+//	products/<slug>/releases/<id>/files/<file-id>       optional file content
+//
+// Files without content get a small generated body. The size, md5 and sha256
+// of every product file are computed from the content that is served.
+package fakepivnet
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed fixtures
+var embeddedFixtures embed.FS
+
+// accessTokenLifetime is the expires_in reported for issued access tokens
+const accessTokenLifetime = time.Hour
+
+// DefaultFixtures returns the fixtures bundled with the package: Ops Manager,
+// Elastic Runtime, a Jammy stemcell and a few tiles with dependency
+// specifiers the download planner can resolve
+func DefaultFixtures() fs.FS {
+	fixtures, err := fs.Sub(embeddedFixtures, "fixtures")
+	if err != nil {
+		panic(err)
+	}
+	return fixtures
+}
+
+// Server is a fake Pivnet API. Its zero value is not usable; create it with New.
+type Server struct {
+	// RefreshToken is the only refresh token accepted by the token exchange.
+	// When empty, any non-empty refresh token is accepted.
+	RefreshToken string
+	// PageSize splits collections into pages linked with a Link header.
+	// Zero returns every item at once unless the client asks for per_page.
+	PageSize int
+	// SkipEULA lets downloads through without a prior EULA acceptance
+	SkipEULA bool
+
+	fixtures fs.FS
+	mux      *http.ServeMux
+
+	mu           sync.Mutex
+	accessTokens map[string]bool
+	accepted     map[string]time.Time
+	downloads    []string
+}
+
+// New creates a server for the given fixtures, or the bundled ones when nil
+func New(fixtures fs.FS) *Server {
+	if fixtures == nil {
+		fixtures = DefaultFixtures()
+	}
+
+	s := &Server{
+		fixtures:     fixtures,
+		mux:          http.NewServeMux(),
+		accessTokens: make(map[string]bool),
+		accepted:     make(map[string]time.Time),
+	}
+
+	s.mux.HandleFunc("POST /api/v2/authentication/access_tokens", s.handleAccessToken)
+	s.mux.HandleFunc("GET /api/v2/authentication", s.authorized(s.handleAuthentication))
+	s.mux.HandleFunc("GET /api/v2/products", s.authorized(s.handleProducts))
+	s.mux.HandleFunc("GET /api/v2/products/{slug}/releases", s.authorized(s.handleReleases))
+	s.mux.HandleFunc("GET /api/v2/products/{slug}/releases/{release}", s.authorized(s.handleRelease))
+	s.mux.HandleFunc("GET /api/v2/products/{slug}/releases/{release}/product_files", s.authorized(s.handleProductFiles))
+	s.mux.HandleFunc("GET /api/v2/products/{slug}/releases/{release}/product_files/{file}", s.authorized(s.handleProductFile))
+	s.mux.HandleFunc("GET /api/v2/products/{slug}/releases/{release}/dependencies", s.authorized(s.handleCollection("dependencies")))
+	s.mux.HandleFunc("GET /api/v2/products/{slug}/releases/{release}/dependency_specifiers", s.authorized(s.handleCollection("dependency_specifiers")))
+	s.mux.HandleFunc("POST /api/v2/products/{slug}/releases/{release}/pivnet_resource_eula_acceptance", s.authorized(s.handleEULAAcceptance))
+	s.mux.HandleFunc("POST /api/v2/products/{slug}/releases/{release}/product_files/{file}/download", s.authorized(s.handleDownload))
+	s.mux.HandleFunc("GET /object-store/{slug}/{release}/{file}/{name}", s.handleObject)
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no route for "+r.Method+" "+r.URL.Path)
+	})
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// EULAAccepted reports whether the EULA of a release was accepted
+func (s *Server) EULAAccepted(productSlug string, releaseID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, accepted := s.accepted[releaseKey(productSlug, strconv.Itoa(releaseID))]
+	return accepted
+}
+
+// Downloads returns the object paths served so far, in order
+func (s *Server) Downloads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.downloads...)
+}
+
+// releaseKey identifies a release in the acceptance record
+func releaseKey(productSlug string, releaseID string) string {
+	return productSlug + "/" + releaseID
+}
+
+// writeJSON writes v with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error body shaped like the real API's
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{"status": status, "message": message})
+}
+
+// authorized rejects requests without an access token issued by this server
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		valid := s.accessTokens[token]
+		s.mu.Unlock()
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "invalid or missing access token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAccessToken exchanges a refresh token for an access token
+func (s *Server) handleAccessToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		writeError(w, http.StatusBadRequest, "refresh_token is required")
+		return
+	}
+	if s.RefreshToken != "" && body.RefreshToken != s.RefreshToken {
+		writeError(w, http.StatusUnauthorized, "refresh token is invalid or expired")
+		return
+	}
+
+	s.mu.Lock()
+	token := fmt.Sprintf("fake-access-token-%d", len(s.accessTokens)+1)
+	s.accessTokens[token] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"expires_in":   int(accessTokenLifetime.Seconds()),
+	})
+}
+
+// handleAuthentication answers the token check clients such as om perform
+func (s *Server) handleAuthentication(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok"})
+}
+
+// readItems loads the items stored under key in a fixture file
+func (s *Server) readItems(name string, key string) ([]map[string]interface{}, error) {
+	data, err := fs.ReadFile(s.fixtures, name)
+	if err != nil {
+		return nil, err
+	}
+	var body map[string][]map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", name, err)
+	}
+	return body[key], nil
+}
+
+// writeFixtureError reports a missing fixture as 404 and anything else as 500
+func writeFixtureError(w http.ResponseWriter, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+// writeCollection writes items under key, one page at a time when paging is on
+func (s *Server) writeCollection(w http.ResponseWriter, r *http.Request, key string, items []map[string]interface{}) {
+	if items == nil {
+		items = []map[string]interface{}{}
+	}
+
+	pageSize := s.PageSize
+	if perPage, err := strconv.Atoi(r.URL.Query().Get("per_page")); err == nil && perPage > 0 {
+		pageSize = perPage
+	}
+	if pageSize <= 0 || len(items) <= pageSize {
+		writeJSON(w, http.StatusOK, map[string]interface{}{key: items})
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	start := (page - 1) * pageSize
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + pageSize
+	if end > len(items) {
+		end = len(items)
+	}
+
+	if end < len(items) {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page+1))
+		query.Set("per_page", strconv.Itoa(pageSize))
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{key: items[start:end]})
+}
+
+// handleProducts lists all products
+func (s *Server) handleProducts(w http.ResponseWriter, r *http.Request) {
+	items, err := s.readItems("products.json", "products")
+	if err != nil {
+		writeFixtureError(w, err)
+		return
+	}
+	s.writeCollection(w, r, "products", items)
+}
+
+// handleReleases lists the releases of a product
+func (s *Server) handleReleases(w http.ResponseWriter, r *http.Request) {
+	items, err := s.readItems(path.Join("products", r.PathValue("slug"), "releases.json"), "releases")
+	if err != nil {
+		writeFixtureError(w, err)
+		return
+	}
+	s.writeCollection(w, r, "releases", items)
+}
+
+// findRelease returns a release of a product from its releases fixture
+func (s *Server) findRelease(productSlug string, releaseID string) (map[string]interface{}, error) {
+	releases, err := s.readItems(path.Join("products", productSlug, "releases.json"), "releases")
+	if err != nil {
+		return nil, err
+	}
+	for _, release := range releases {
+		if fmt.Sprint(release["id"]) == releaseID {
+			return release, nil
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// handleRelease returns one release with its EULA
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	release, err := s.findRelease(slug, r.PathValue("release"))
+	if err != nil {
+		writeFixtureError(w, err)
+		return
+	}
+
+	for _, name := range []string{path.Join("products", slug, "eula.json"), "eula.json"} {
+		data, err := fs.ReadFile(s.fixtures, name)
+		if err != nil {
+			continue
+		}
+		var eula map[string]interface{}
+		if err := json.Unmarshal(data, &eula); err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("invalid fixture %s: %v", name, err))
+			return
+		}
+		release["eula"] = eula
+		break
+	}
+	writeJSON(w, http.StatusOK, release)
+}
+
+// releaseDir is the fixture directory of a release
+func releaseDir(r *http.Request) string {
+	return path.Join("products", r.PathValue("slug"), "releases", r.PathValue("release"))
+}
+
+// fileContent returns the bytes served for a product file
+func (s *Server) fileContent(dir string, file map[string]interface{}) []byte {
+	id := fmt.Sprint(file["id"])
+	if data, err := fs.ReadFile(s.fixtures, path.Join(dir, "files", id)); err == nil {
+		return data
+	}
+	return []byte(fmt.Sprintf("fake content of %v (product file %s)\n", file["name"], id))
+}
+
+// productFiles loads the product files of a release with checksums matching their content
+func (s *Server) productFiles(dir string) ([]map[string]interface{}, error) {
+	files, err := s.readItems(path.Join(dir, "product_files.json"), "product_files")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		content := s.fileContent(dir, file)
+		sha := sha256.Sum256(content)
+		sum := md5.Sum(content)
+		file["size"] = len(content)
+		file["sha256"] = hex.EncodeToString(sha[:])
+		file["md5"] = hex.EncodeToString(sum[:])
+	}
+	return files, nil
+}
+
+// findProductFile returns one product file of a release
+func (s *Server) findProductFile(dir string, fileID string) (map[string]interface{}, error) {
+	files, err := s.productFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if fmt.Sprint(file["id"]) == fileID {
+			return file, nil
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// handleProductFiles lists the files of a release
+func (s *Server) handleProductFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := s.productFiles(releaseDir(r))
+	if err != nil {
+		writeFixtureError(w, err)
+		return
+	}
+	s.writeCollection(w, r, "product_files", files)
+}
+
+// handleProductFile returns one file of a release
+func (s *Server) handleProductFile(w http.ResponseWriter, r *http.Request) {
+	file, err := s.findProductFile(releaseDir(r), r.PathValue("file"))
+	if err != nil {
+		writeFixtureError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"product_file": file})
+}
+
+// handleCollection serves a release collection fixture such as dependencies.
+// A release without the fixture has an empty collection.
+func (s *Server) handleCollection(key string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := s.findRelease(r.PathValue("slug"), r.PathValue("release")); err != nil {
+			writeFixtureError(w, err)
+			return
+		}
+		items, err := s.readItems(path.Join(releaseDir(r), key+".json"), key)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			writeFixtureError(w, err)
+			return
+		}
+		s.writeCollection(w, r, key, items)
+	}
+}
+
+// handleEULAAcceptance records the EULA acceptance of a release
+func (s *Server) handleEULAAcceptance(w http.ResponseWriter, r *http.Request) {
+	slug, releaseID := r.PathValue("slug"), r.PathValue("release")
+	if _, err := s.findRelease(slug, releaseID); err != nil {
+		writeFixtureError(w, err)
+		return
+	}
+
+	acceptedAt := time.Now().UTC()
+	s.mu.Lock()
+	s.accepted[releaseKey(slug, releaseID)] = acceptedAt
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"accepted_at": acceptedAt.Format(time.RFC3339)})
+}
+
+// handleDownload redirects to the object store URL of a product file, like
+// the real API redirects to a signed URL
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
+	slug, releaseID := r.PathValue("slug"), r.PathValue("release")
+	file, err := s.findProductFile(releaseDir(r), r.PathValue("file"))
+	if err != nil {
+		writeFixtureError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	_, accepted := s.accepted[releaseKey(slug, releaseID)]
+	s.mu.Unlock()
+	if !accepted && !s.SkipEULA {
+		writeError(w, http.StatusUnavailableForLegalReasons, "user must accept the EULA before downloading this release")
+		return
+	}
+
+	name := path.Base(fmt.Sprint(file["aws_object_key"]))
+	if name == "." || name == "/" || name == "<nil>" {
+		name = fmt.Sprint(file["id"])
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	location := fmt.Sprintf("%s://%s/object-store/%s/%s/%v/%s", scheme, r.Host, slug, releaseID, file["id"], name)
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+// handleObject serves file content with range support and no authentication,
+// like a signed object store URL
+func (s *Server) handleObject(w http.ResponseWriter, r *http.Request) {
+	dir := releaseDir(r)
+	file, err := s.findProductFile(dir, r.PathValue("file"))
+	if err != nil {
+		writeFixtureError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.downloads = append(s.downloads, r.URL.Path)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, r.PathValue("name"), time.Time{}, strings.NewReader(string(s.fileContent(dir, file))))
+}
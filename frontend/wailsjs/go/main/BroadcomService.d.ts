@@ -2,26 +2,80 @@
 // This file is automatically generated. DO NOT EDIT
 import {main} from '../models';
 
+export function AcceptEULA(arg1:string,arg2:number):Promise<void>;
+
 export function AcceptEULAAndDownload(arg1:string,arg2:number,arg3:number,arg4:string):Promise<void>;
 
+export function AnnotateLocalStatus(arg1:string,arg2:string,arg3:Array<main.ProductFile>):Promise<Array<main.ProductFile>>;
+
 export function CancelDownload(arg1:number):Promise<void>;
 
+export function ClearFinishedDownloads():Promise<void>;
+
+export function CreateProfile(arg1:string,arg2:string):Promise<void>;
+
+export function DeleteProfile(arg1:string):Promise<void>;
+
 export function DownloadFileWithOM(arg1:string,arg2:string,arg3:string,arg4:string,arg5:string,arg6:number):Promise<void>;
 
 export function DownloadOpsManagerWithOM(arg1:string,arg2:string,arg3:string,arg4:string,arg5:string,arg6:number):Promise<void>;
 
+export function DownloadProductFile(arg1:string,arg2:number,arg3:number,arg4:string):Promise<void>;
+
 export function DownloadStemcellWithOM(arg1:string,arg2:string,arg3:string,arg4:string,arg5:string,arg6:number):Promise<void>;
 
+export function EnqueueDownload(arg1:main.DownloadRequest):Promise<void>;
+
+export function ExportBundle(arg1:Array<number>,arg2:string,arg3:boolean):Promise<main.BundleExportResult>;
+
 export function GetAPIToken():Promise<string>;
 
+export function GetActiveProfile():Promise<string>;
+
+export function GetBandwidthLimit():Promise<number>;
+
+export function GetBaseURL():Promise<string>;
+
+export function GetBundleSigningKey():Promise<string>;
+
+export function GetCACertFiles():Promise<Array<string>>;
+
+export function GetCompatibleElasticRuntimeReleases(arg1:string):Promise<Array<main.Release>>;
+
+export function GetDefaultIaaS():Promise<string>;
+
+export function GetDownloadEngine():Promise<string>;
+
+export function GetDownloadLayout():Promise<string>;
+
+export function GetDownloadLayoutPresets():Promise<Array<main.DownloadLayoutPreset>>;
+
 export function GetDownloadLocation():Promise<string>;
 
+export function GetDownloadQueue():Promise<Array<main.DownloadJob>>;
+
+export function GetDownloadRetries():Promise<number>;
+
+export function GetDownloadWindows():Promise<Array<main.DownloadWindow>>;
+
+export function GetEULAAcceptances():Promise<Array<main.EULAAcceptance>>;
+
 export function GetHTTPProxy():Promise<string>;
 
 export function GetHTTPSProxy():Promise<string>;
 
+export function GetInsecureSkipVerify():Promise<boolean>;
+
+export function GetMaxParallelDownloads():Promise<number>;
+
+export function GetNoProxy():Promise<string>;
+
 export function GetProductReleases(arg1:string):Promise<Array<main.Release>>;
 
+export function GetProxyCAFile():Promise<string>;
+
+export function GetProxyUsername():Promise<string>;
+
 export function GetReleaseDependencies(arg1:string,arg2:number):Promise<Array<main.Dependency>>;
 
 export function GetReleaseDependencySpecifiers(arg1:string,arg2:number):Promise<Array<main.DependencySpecifier>>;
@@ -30,12 +84,78 @@ export function GetReleaseEULA(arg1:string,arg2:number):Promise<main.EULA>;
 
 export function GetReleaseFiles(arg1:string,arg2:number):Promise<Array<main.ProductFile>>;
 
+export function GetTokenProtection():Promise<string>;
+
+export function ImportBundle(arg1:string,arg2:boolean):Promise<main.BundleImportReport>;
+
+export function IsEULAAccepted(arg1:string,arg2:number):Promise<boolean>;
+
+export function IsTokenLocked():Promise<boolean>;
+
 export function ListProducts():Promise<Array<main.Product>>;
 
+export function ListProfiles():Promise<Array<main.ProfileInfo>>;
+
+export function PauseDownload(arg1:number):Promise<void>;
+
+export function PlanDownloads(arg1:string,arg2:string,arg3:string):Promise<main.DownloadPlan>;
+
+export function QueryDownloadHistory(arg1:main.HistoryQuery):Promise<Array<main.HistoryEntry>>;
+
+export function RemoveDownload(arg1:number):Promise<void>;
+
+export function ResumeDownload(arg1:number):Promise<void>;
+
+export function RetryDownload(arg1:number):Promise<void>;
+
+export function ScanInventory(arg1:boolean):Promise<Array<main.InventoryFile>>;
+
 export function SetAPIToken(arg1:string):Promise<void>;
 
+export function SetBandwidthLimit(arg1:number):Promise<void>;
+
+export function SetBaseURL(arg1:string):Promise<void>;
+
+export function SetCACertFiles(arg1:Array<string>):Promise<void>;
+
+export function SetDefaultIaaS(arg1:string):Promise<void>;
+
+export function SetDownloadBandwidthLimit(arg1:number,arg2:number):Promise<void>;
+
+export function SetDownloadEngine(arg1:string):Promise<void>;
+
+export function SetDownloadLayout(arg1:string):Promise<void>;
+
 export function SetDownloadLocation(arg1:string):Promise<void>;
 
+export function SetDownloadPriority(arg1:number,arg2:number):Promise<void>;
+
+export function SetDownloadRetries(arg1:number):Promise<void>;
+
+export function SetDownloadWindows(arg1:Array<main.DownloadWindow>):Promise<void>;
+
 export function SetHTTPProxy(arg1:string):Promise<void>;
 
 export function SetHTTPSProxy(arg1:string):Promise<void>;
+
+export function SetInsecureSkipVerify(arg1:boolean):Promise<void>;
+
+export function SetMaxParallelDownloads(arg1:number):Promise<void>;
+
+export function SetNoProxy(arg1:string):Promise<void>;
+
+export function SetProxyCAFile(arg1:string):Promise<void>;
+
+export function SetProxyCredentials(arg1:string,arg2:string):Promise<void>;
+
+export function SetTokenPassphrase(arg1:string):Promise<void>;
+
+export function SwitchProfile(arg1:string):Promise<void>;
+
+export function TestConnectivity():Promise<main.ConnectivityReport>;
+
+export function TrustBundleKey(arg1:string):Promise<string>;
+
+export function UnlockToken(arg1:string):Promise<void>;
+
+export function VerifyBundle(arg1:string):Promise<main.BundleImportReport>;
@@ -2,14 +2,34 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function AcceptEULA(arg1, arg2) {
+  return window['go']['main']['BroadcomService']['AcceptEULA'](arg1, arg2);
+}
+
 export function AcceptEULAAndDownload(arg1, arg2, arg3, arg4) {
   return window['go']['main']['BroadcomService']['AcceptEULAAndDownload'](arg1, arg2, arg3, arg4);
 }
 
+export function AnnotateLocalStatus(arg1, arg2, arg3) {
+  return window['go']['main']['BroadcomService']['AnnotateLocalStatus'](arg1, arg2, arg3);
+}
+
 export function CancelDownload(arg1) {
   return window['go']['main']['BroadcomService']['CancelDownload'](arg1);
 }
 
+export function ClearFinishedDownloads() {
+  return window['go']['main']['BroadcomService']['ClearFinishedDownloads']();
+}
+
+export function CreateProfile(arg1, arg2) {
+  return window['go']['main']['BroadcomService']['CreateProfile'](arg1, arg2);
+}
+
+export function DeleteProfile(arg1) {
+  return window['go']['main']['BroadcomService']['DeleteProfile'](arg1);
+}
+
 export function DownloadFileWithOM(arg1, arg2, arg3, arg4, arg5, arg6) {
   return window['go']['main']['BroadcomService']['DownloadFileWithOM'](arg1, arg2, arg3, arg4, arg5, arg6);
 }
@@ -18,18 +38,86 @@ export function DownloadOpsManagerWithOM(arg1, arg2, arg3, arg4, arg5, arg6) {
   return window['go']['main']['BroadcomService']['DownloadOpsManagerWithOM'](arg1, arg2, arg3, arg4, arg5, arg6);
 }
 
+export function DownloadProductFile(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['BroadcomService']['DownloadProductFile'](arg1, arg2, arg3, arg4);
+}
+
 export function DownloadStemcellWithOM(arg1, arg2, arg3, arg4, arg5, arg6) {
   return window['go']['main']['BroadcomService']['DownloadStemcellWithOM'](arg1, arg2, arg3, arg4, arg5, arg6);
 }
 
+export function EnqueueDownload(arg1) {
+  return window['go']['main']['BroadcomService']['EnqueueDownload'](arg1);
+}
+
+export function ExportBundle(arg1, arg2, arg3) {
+  return window['go']['main']['BroadcomService']['ExportBundle'](arg1, arg2, arg3);
+}
+
 export function GetAPIToken() {
   return window['go']['main']['BroadcomService']['GetAPIToken']();
 }
 
+export function GetActiveProfile() {
+  return window['go']['main']['BroadcomService']['GetActiveProfile']();
+}
+
+export function GetBandwidthLimit() {
+  return window['go']['main']['BroadcomService']['GetBandwidthLimit']();
+}
+
+export function GetBaseURL() {
+  return window['go']['main']['BroadcomService']['GetBaseURL']();
+}
+
+export function GetBundleSigningKey() {
+  return window['go']['main']['BroadcomService']['GetBundleSigningKey']();
+}
+
+export function GetCACertFiles() {
+  return window['go']['main']['BroadcomService']['GetCACertFiles']();
+}
+
+export function GetCompatibleElasticRuntimeReleases(arg1) {
+  return window['go']['main']['BroadcomService']['GetCompatibleElasticRuntimeReleases'](arg1);
+}
+
+export function GetDefaultIaaS() {
+  return window['go']['main']['BroadcomService']['GetDefaultIaaS']();
+}
+
+export function GetDownloadEngine() {
+  return window['go']['main']['BroadcomService']['GetDownloadEngine']();
+}
+
+export function GetDownloadLayout() {
+  return window['go']['main']['BroadcomService']['GetDownloadLayout']();
+}
+
+export function GetDownloadLayoutPresets() {
+  return window['go']['main']['BroadcomService']['GetDownloadLayoutPresets']();
+}
+
 export function GetDownloadLocation() {
   return window['go']['main']['BroadcomService']['GetDownloadLocation']();
 }
 
+export function GetDownloadQueue() {
+  return window['go']['main']['BroadcomService']['GetDownloadQueue']();
+}
+
+export function GetDownloadRetries() {
+  return window['go']['main']['BroadcomService']['GetDownloadRetries']();
+}
+
+export function GetDownloadWindows() {
+  return window['go']['main']['BroadcomService']['GetDownloadWindows']();
+}
+
+export function GetEULAAcceptances() {
+  return window['go']['main']['BroadcomService']['GetEULAAcceptances']();
+}
+
 export function GetHTTPProxy() {
   return window['go']['main']['BroadcomService']['GetHTTPProxy']();
 }
@@ -38,10 +126,30 @@ export function GetHTTPSProxy() {
   return window['go']['main']['BroadcomService']['GetHTTPSProxy']();
 }
 
+export function GetInsecureSkipVerify() {
+  return window['go']['main']['BroadcomService']['GetInsecureSkipVerify']();
+}
+
+export function GetMaxParallelDownloads() {
+  return window['go']['main']['BroadcomService']['GetMaxParallelDownloads']();
+}
+
+export function GetNoProxy() {
+  return window['go']['main']['BroadcomService']['GetNoProxy']();
+}
+
 export function GetProductReleases(arg1) {
   return window['go']['main']['BroadcomService']['GetProductReleases'](arg1);
 }
 
+export function GetProxyCAFile() {
+  return window['go']['main']['BroadcomService']['GetProxyCAFile']();
+}
+
+export function GetProxyUsername() {
+  return window['go']['main']['BroadcomService']['GetProxyUsername']();
+}
+
 export function GetReleaseDependencies(arg1, arg2) {
   return window['go']['main']['BroadcomService']['GetReleaseDependencies'](arg1, arg2);
 }
@@ -58,18 +166,106 @@ export function GetReleaseFiles(arg1, arg2) {
   return window['go']['main']['BroadcomService']['GetReleaseFiles'](arg1, arg2);
 }
 
+export function GetTokenProtection() {
+  return window['go']['main']['BroadcomService']['GetTokenProtection']();
+}
+
+export function ImportBundle(arg1, arg2) {
+  return window['go']['main']['BroadcomService']['ImportBundle'](arg1, arg2);
+}
+
+export function IsEULAAccepted(arg1, arg2) {
+  return window['go']['main']['BroadcomService']['IsEULAAccepted'](arg1, arg2);
+}
+
+export function IsTokenLocked() {
+  return window['go']['main']['BroadcomService']['IsTokenLocked']();
+}
+
 export function ListProducts() {
   return window['go']['main']['BroadcomService']['ListProducts']();
 }
 
+export function ListProfiles() {
+  return window['go']['main']['BroadcomService']['ListProfiles']();
+}
+
+export function PauseDownload(arg1) {
+  return window['go']['main']['BroadcomService']['PauseDownload'](arg1);
+}
+
+export function PlanDownloads(arg1, arg2, arg3) {
+  return window['go']['main']['BroadcomService']['PlanDownloads'](arg1, arg2, arg3);
+}
+
+export function QueryDownloadHistory(arg1) {
+  return window['go']['main']['BroadcomService']['QueryDownloadHistory'](arg1);
+}
+
+export function RemoveDownload(arg1) {
+  return window['go']['main']['BroadcomService']['RemoveDownload'](arg1);
+}
+
+export function ResumeDownload(arg1) {
+  return window['go']['main']['BroadcomService']['ResumeDownload'](arg1);
+}
+
+export function RetryDownload(arg1) {
+  return window['go']['main']['BroadcomService']['RetryDownload'](arg1);
+}
+
+export function ScanInventory(arg1) {
+  return window['go']['main']['BroadcomService']['ScanInventory'](arg1);
+}
+
 export function SetAPIToken(arg1) {
   return window['go']['main']['BroadcomService']['SetAPIToken'](arg1);
 }
 
+export function SetBandwidthLimit(arg1) {
+  return window['go']['main']['BroadcomService']['SetBandwidthLimit'](arg1);
+}
+
+export function SetBaseURL(arg1) {
+  return window['go']['main']['BroadcomService']['SetBaseURL'](arg1);
+}
+
+export function SetCACertFiles(arg1) {
+  return window['go']['main']['BroadcomService']['SetCACertFiles'](arg1);
+}
+
+export function SetDefaultIaaS(arg1) {
+  return window['go']['main']['BroadcomService']['SetDefaultIaaS'](arg1);
+}
+
+export function SetDownloadBandwidthLimit(arg1, arg2) {
+  return window['go']['main']['BroadcomService']['SetDownloadBandwidthLimit'](arg1, arg2);
+}
+
+export function SetDownloadEngine(arg1) {
+  return window['go']['main']['BroadcomService']['SetDownloadEngine'](arg1);
+}
+
+export function SetDownloadLayout(arg1) {
+  return window['go']['main']['BroadcomService']['SetDownloadLayout'](arg1);
+}
+
 export function SetDownloadLocation(arg1) {
   return window['go']['main']['BroadcomService']['SetDownloadLocation'](arg1);
 }
 
+export function SetDownloadPriority(arg1, arg2) {
+  return window['go']['main']['BroadcomService']['SetDownloadPriority'](arg1, arg2);
+}
+
+export function SetDownloadRetries(arg1) {
+  return window['go']['main']['BroadcomService']['SetDownloadRetries'](arg1);
+}
+
+export function SetDownloadWindows(arg1) {
+  return window['go']['main']['BroadcomService']['SetDownloadWindows'](arg1);
+}
+
 export function SetHTTPProxy(arg1) {
   return window['go']['main']['BroadcomService']['SetHTTPProxy'](arg1);
 }
@@ -77,3 +273,47 @@ export function SetHTTPProxy(arg1) {
 export function SetHTTPSProxy(arg1) {
   return window['go']['main']['BroadcomService']['SetHTTPSProxy'](arg1);
 }
+
+export function SetInsecureSkipVerify(arg1) {
+  return window['go']['main']['BroadcomService']['SetInsecureSkipVerify'](arg1);
+}
+
+export function SetMaxParallelDownloads(arg1) {
+  return window['go']['main']['BroadcomService']['SetMaxParallelDownloads'](arg1);
+}
+
+export function SetNoProxy(arg1) {
+  return window['go']['main']['BroadcomService']['SetNoProxy'](arg1);
+}
+
+export function SetProxyCAFile(arg1) {
+  return window['go']['main']['BroadcomService']['SetProxyCAFile'](arg1);
+}
+
+export function SetProxyCredentials(arg1, arg2) {
+  return window['go']['main']['BroadcomService']['SetProxyCredentials'](arg1, arg2);
+}
+
+export function SetTokenPassphrase(arg1) {
+  return window['go']['main']['BroadcomService']['SetTokenPassphrase'](arg1);
+}
+
+export function SwitchProfile(arg1) {
+  return window['go']['main']['BroadcomService']['SwitchProfile'](arg1);
+}
+
+export function TestConnectivity() {
+  return window['go']['main']['BroadcomService']['TestConnectivity']();
+}
+
+export function TrustBundleKey(arg1) {
+  return window['go']['main']['BroadcomService']['TrustBundleKey'](arg1);
+}
+
+export function UnlockToken(arg1) {
+  return window['go']['main']['BroadcomService']['UnlockToken'](arg1);
+}
+
+export function VerifyBundle(arg1) {
+  return window['go']['main']['BroadcomService']['VerifyBundle'](arg1);
+}
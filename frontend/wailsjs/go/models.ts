@@ -1,5 +1,244 @@
 export namespace main {
 	
+	export class BundleEntry {
+	    product_slug: string;
+	    product_name?: string;
+	    version: string;
+	    release_id: number;
+	    file_id: number;
+	    file_name: string;
+	    path: string;
+	    sha256: string;
+	    size: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new BundleEntry(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.product_slug = source["product_slug"];
+	        this.product_name = source["product_name"];
+	        this.version = source["version"];
+	        this.release_id = source["release_id"];
+	        this.file_id = source["file_id"];
+	        this.file_name = source["file_name"];
+	        this.path = source["path"];
+	        this.sha256 = source["sha256"];
+	        this.size = source["size"];
+	    }
+	}
+	export class BundleManifest {
+	    format_version: number;
+	    // Go type: time
+	    created_at: any;
+	    key_fingerprint: string;
+	    files: BundleEntry[];
+	
+	    static createFrom(source: any = {}) {
+	        return new BundleManifest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.format_version = source["format_version"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.key_fingerprint = source["key_fingerprint"];
+	        this.files = this.convertValues(source["files"], BundleEntry);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class BundleExportResult {
+	    location: string;
+	    key_fingerprint: string;
+	    manifest: BundleManifest;
+	
+	    static createFrom(source: any = {}) {
+	        return new BundleExportResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.location = source["location"];
+	        this.key_fingerprint = source["key_fingerprint"];
+	        this.manifest = this.convertValues(source["manifest"], BundleManifest);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class BundleFileCheck {
+	    product_slug: string;
+	    product_name?: string;
+	    version: string;
+	    release_id: number;
+	    file_id: number;
+	    file_name: string;
+	    path: string;
+	    sha256: string;
+	    size: number;
+	    status: string;
+	    actual_sha256?: string;
+	    destination?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new BundleFileCheck(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.product_slug = source["product_slug"];
+	        this.product_name = source["product_name"];
+	        this.version = source["version"];
+	        this.release_id = source["release_id"];
+	        this.file_id = source["file_id"];
+	        this.file_name = source["file_name"];
+	        this.path = source["path"];
+	        this.sha256 = source["sha256"];
+	        this.size = source["size"];
+	        this.status = source["status"];
+	        this.actual_sha256 = source["actual_sha256"];
+	        this.destination = source["destination"];
+	    }
+	}
+	export class BundleImportReport {
+	    source: string;
+	    destination?: string;
+	    // Go type: time
+	    created_at: any;
+	    key_fingerprint: string;
+	    trusted: boolean;
+	    files: BundleFileCheck[];
+	    missing: number;
+	    corrupt: number;
+	    extra: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new BundleImportReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.source = source["source"];
+	        this.destination = source["destination"];
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.key_fingerprint = source["key_fingerprint"];
+	        this.trusted = source["trusted"];
+	        this.files = this.convertValues(source["files"], BundleFileCheck);
+	        this.missing = source["missing"];
+	        this.corrupt = source["corrupt"];
+	        this.extra = source["extra"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	export class ConnectivityHop {
+	    name: string;
+	    target: string;
+	    ok: boolean;
+	    error?: string;
+	    hint?: string;
+	    duration_ms: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ConnectivityHop(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.target = source["target"];
+	        this.ok = source["ok"];
+	        this.error = source["error"];
+	        this.hint = source["hint"];
+	        this.duration_ms = source["duration_ms"];
+	    }
+	}
+	export class ConnectivityReport {
+	    ok: boolean;
+	    failed_hop?: string;
+	    proxy?: string;
+	    hops: ConnectivityHop[];
+	
+	    static createFrom(source: any = {}) {
+	        return new ConnectivityReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.ok = source["ok"];
+	        this.failed_hop = source["failed_hop"];
+	        this.proxy = source["proxy"];
+	        this.hops = this.convertValues(source["hops"], ConnectivityHop);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class Release {
 	    id: number;
 	    version: string;
@@ -83,6 +322,260 @@ export namespace main {
 		    return a;
 		}
 	}
+	export class DownloadJob {
+	    product_slug: string;
+	    product_name: string;
+	    release_id: number;
+	    version: string;
+	    file_id: number;
+	    file_name: string;
+	    output_dir: string;
+	    priority: number;
+	    profile?: string;
+	    max_retries?: number;
+	    bandwidth_limit?: number;
+	    state: string;
+	    progress: number;
+	    downloaded: number;
+	    total: number;
+	    path?: string;
+	    object_name?: string;
+	    partial_path?: string;
+	    sha256?: string;
+	    error?: string;
+	    retries: number;
+	    last_error?: string;
+	    // Go type: time
+	    next_retry_at?: any;
+	    // Go type: time
+	    created_at: any;
+	    // Go type: time
+	    started_at?: any;
+	    // Go type: time
+	    finished_at?: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new DownloadJob(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.product_slug = source["product_slug"];
+	        this.product_name = source["product_name"];
+	        this.release_id = source["release_id"];
+	        this.version = source["version"];
+	        this.file_id = source["file_id"];
+	        this.file_name = source["file_name"];
+	        this.output_dir = source["output_dir"];
+	        this.priority = source["priority"];
+	        this.profile = source["profile"];
+	        this.max_retries = source["max_retries"];
+	        this.bandwidth_limit = source["bandwidth_limit"];
+	        this.state = source["state"];
+	        this.progress = source["progress"];
+	        this.downloaded = source["downloaded"];
+	        this.total = source["total"];
+	        this.path = source["path"];
+	        this.object_name = source["object_name"];
+	        this.partial_path = source["partial_path"];
+	        this.sha256 = source["sha256"];
+	        this.error = source["error"];
+	        this.retries = source["retries"];
+	        this.last_error = source["last_error"];
+	        this.next_retry_at = this.convertValues(source["next_retry_at"], null);
+	        this.created_at = this.convertValues(source["created_at"], null);
+	        this.started_at = this.convertValues(source["started_at"], null);
+	        this.finished_at = this.convertValues(source["finished_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DownloadLayoutPreset {
+	    name: string;
+	    template: string;
+	    description: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new DownloadLayoutPreset(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.template = source["template"];
+	        this.description = source["description"];
+	    }
+	}
+	export class ProductFile {
+	    id: number;
+	    name: string;
+	    aws_object_key: string;
+	    file_type: string;
+	    file_version: string;
+	    md5: string;
+	    sha256: string;
+	    size: number;
+	    local_status?: string;
+	    local_path?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ProductFile(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.aws_object_key = source["aws_object_key"];
+	        this.file_type = source["file_type"];
+	        this.file_version = source["file_version"];
+	        this.md5 = source["md5"];
+	        this.sha256 = source["sha256"];
+	        this.size = source["size"];
+	        this.local_status = source["local_status"];
+	        this.local_path = source["local_path"];
+	    }
+	}
+	export class PlannedProduct {
+	    product_name: string;
+	    product_slug: string;
+	    version: string;
+	    release_id: number;
+	    files: ProductFile[];
+	    priority: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PlannedProduct(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.product_name = source["product_name"];
+	        this.product_slug = source["product_slug"];
+	        this.version = source["version"];
+	        this.release_id = source["release_id"];
+	        this.files = this.convertValues(source["files"], ProductFile);
+	        this.priority = source["priority"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DownloadPlan {
+	    ops_manager_version: string;
+	    elastic_runtime_version: string;
+	    runtime_type: string;
+	    products: PlannedProduct[];
+	    warnings: string[];
+	
+	    static createFrom(source: any = {}) {
+	        return new DownloadPlan(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.ops_manager_version = source["ops_manager_version"];
+	        this.elastic_runtime_version = source["elastic_runtime_version"];
+	        this.runtime_type = source["runtime_type"];
+	        this.products = this.convertValues(source["products"], PlannedProduct);
+	        this.warnings = source["warnings"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class DownloadRequest {
+	    product_slug: string;
+	    product_name: string;
+	    release_id: number;
+	    version: string;
+	    file_id: number;
+	    file_name: string;
+	    output_dir: string;
+	    priority: number;
+	    profile?: string;
+	    max_retries?: number;
+	    bandwidth_limit?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DownloadRequest(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.product_slug = source["product_slug"];
+	        this.product_name = source["product_name"];
+	        this.release_id = source["release_id"];
+	        this.version = source["version"];
+	        this.file_id = source["file_id"];
+	        this.file_name = source["file_name"];
+	        this.output_dir = source["output_dir"];
+	        this.priority = source["priority"];
+	        this.profile = source["profile"];
+	        this.max_retries = source["max_retries"];
+	        this.bandwidth_limit = source["bandwidth_limit"];
+	    }
+	}
+	export class DownloadWindow {
+	    start: string;
+	    end: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new DownloadWindow(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.start = source["start"];
+	        this.end = source["end"];
+	    }
+	}
 	export class EULA {
 	    id: number;
 	    slug: string;
@@ -101,6 +594,193 @@ export namespace main {
 	        this.content = source["content"];
 	    }
 	}
+	export class EULAAcceptance {
+	    profile: string;
+	    base_url: string;
+	    product_slug: string;
+	    release_id: number;
+	    // Go type: time
+	    accepted_at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new EULAAcceptance(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.profile = source["profile"];
+	        this.base_url = source["base_url"];
+	        this.product_slug = source["product_slug"];
+	        this.release_id = source["release_id"];
+	        this.accepted_at = this.convertValues(source["accepted_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class HistoryEntry {
+	    product_slug: string;
+	    product_name?: string;
+	    release_id: number;
+	    version?: string;
+	    file_id: number;
+	    file_name?: string;
+	    object_name?: string;
+	    sha256?: string;
+	    size?: number;
+	    duration_seconds: number;
+	    profile?: string;
+	    outcome: string;
+	    error?: string;
+	    path?: string;
+	    retries?: number;
+	    // Go type: time
+	    started_at: any;
+	    // Go type: time
+	    finished_at: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new HistoryEntry(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.product_slug = source["product_slug"];
+	        this.product_name = source["product_name"];
+	        this.release_id = source["release_id"];
+	        this.version = source["version"];
+	        this.file_id = source["file_id"];
+	        this.file_name = source["file_name"];
+	        this.object_name = source["object_name"];
+	        this.sha256 = source["sha256"];
+	        this.size = source["size"];
+	        this.duration_seconds = source["duration_seconds"];
+	        this.profile = source["profile"];
+	        this.outcome = source["outcome"];
+	        this.error = source["error"];
+	        this.path = source["path"];
+	        this.retries = source["retries"];
+	        this.started_at = this.convertValues(source["started_at"], null);
+	        this.finished_at = this.convertValues(source["finished_at"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class HistoryQuery {
+	    profile?: string;
+	    product?: string;
+	    version?: string;
+	    outcome?: string;
+	    search?: string;
+	    // Go type: time
+	    since?: any;
+	    // Go type: time
+	    until?: any;
+	    limit?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new HistoryQuery(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.profile = source["profile"];
+	        this.product = source["product"];
+	        this.version = source["version"];
+	        this.outcome = source["outcome"];
+	        this.search = source["search"];
+	        this.since = this.convertValues(source["since"], null);
+	        this.until = this.convertValues(source["until"], null);
+	        this.limit = source["limit"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class InventoryFile {
+	    path: string;
+	    size: number;
+	    // Go type: time
+	    mod_time: any;
+	    sha256?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new InventoryFile(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.size = source["size"];
+	        this.mod_time = this.convertValues(source["mod_time"], null);
+	        this.sha256 = source["sha256"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
 	export class Product {
 	    id: number;
 	    slug: string;
@@ -119,28 +799,31 @@ export namespace main {
 	        this.description = source["description"];
 	    }
 	}
-	export class ProductFile {
-	    id: number;
+	
+	export class ProfileInfo {
 	    name: string;
-	    aws_object_key: string;
-	    file_type: string;
-	    file_version: string;
-	    md5: string;
-	    sha256: string;
+	    active: boolean;
+	    has_token: boolean;
+	    download_location: string;
+	    http_proxy?: string;
+	    https_proxy?: string;
+	    default_iaas: string;
+	    base_url: string;
 	
 	    static createFrom(source: any = {}) {
-	        return new ProductFile(source);
+	        return new ProfileInfo(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.id = source["id"];
 	        this.name = source["name"];
-	        this.aws_object_key = source["aws_object_key"];
-	        this.file_type = source["file_type"];
-	        this.file_version = source["file_version"];
-	        this.md5 = source["md5"];
-	        this.sha256 = source["sha256"];
+	        this.active = source["active"];
+	        this.has_token = source["has_token"];
+	        this.download_location = source["download_location"];
+	        this.http_proxy = source["http_proxy"];
+	        this.https_proxy = source["https_proxy"];
+	        this.default_iaas = source["default_iaas"];
+	        this.base_url = source["base_url"];
 	    }
 	}
 
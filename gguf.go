@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ggufMagic opens every GGUF file ("GGUF" read as a little-endian uint32)
+const ggufMagic = 0x46554747
+
+// ggufDefaultAlignment applies when general.alignment is not set
+const ggufDefaultAlignment = 32
+
+// GGUF metadata value types
+const (
+	ggufTypeUint8   uint32 = 0
+	ggufTypeInt8    uint32 = 1
+	ggufTypeUint16  uint32 = 2
+	ggufTypeInt16   uint32 = 3
+	ggufTypeUint32  uint32 = 4
+	ggufTypeInt32   uint32 = 5
+	ggufTypeFloat32 uint32 = 6
+	ggufTypeBool    uint32 = 7
+	ggufTypeString  uint32 = 8
+	ggufTypeArray   uint32 = 9
+	ggufTypeUint64  uint32 = 10
+	ggufTypeInt64   uint32 = 11
+	ggufTypeFloat64 uint32 = 12
+)
+
+// Metadata keys written by llama.cpp's gguf-split
+const (
+	ggufKeyAlignment         = "general.alignment"
+	ggufKeySplitNo           = "split.no"
+	ggufKeySplitCount        = "split.count"
+	ggufKeySplitTensorsCount = "split.tensors.count"
+)
+
+// ggufShardPattern matches shard names such as model-00001-of-00003.gguf
+var ggufShardPattern = regexp.MustCompile(`^(.*)-(\d{5})-of-(\d{5})\.gguf$`)
+
+// ggufKV is one metadata entry. The value is kept in its encoded form so it
+// can be written back unchanged.
+type ggufKV struct {
+	Key   string
+	Type  uint32
+	Raw   []byte
+	Value interface{} // Decoded integer value for integer types, nil otherwise
+}
+
+// ggufTensorInfo describes one tensor; Offset is relative to the data section
+type ggufTensorInfo struct {
+	Name   string
+	Dims   []uint64
+	Type   uint32
+	Offset uint64
+}
+
+// ggufFile is the parsed header of a GGUF file
+type ggufFile struct {
+	Path       string
+	Version    uint32
+	KV         []ggufKV
+	Tensors    []ggufTensorInfo
+	Alignment  uint64
+	DataOffset int64 // Start of the tensor data section
+	Size       int64
+}
+
+// uint returns the value of an integer metadata key
+func (f *ggufFile) uint(key string) (uint64, bool) {
+	for _, kv := range f.KV {
+		if kv.Key == key && kv.Value != nil {
+			return kv.Value.(uint64), true
+		}
+	}
+	return 0, false
+}
+
+// tensorSpans returns the number of data bytes, including alignment padding,
+// that each tensor occupies, indexed like f.Tensors
+func (f *ggufFile) tensorSpans() ([]uint64, error) {
+	order := make([]int, len(f.Tensors))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return f.Tensors[order[i]].Offset < f.Tensors[order[j]].Offset
+	})
+
+	dataSize := uint64(f.Size - f.DataOffset)
+	spans := make([]uint64, len(f.Tensors))
+	for n, i := range order {
+		end := dataSize
+		if n+1 < len(order) {
+			end = f.Tensors[order[n+1]].Offset
+		}
+		if end < f.Tensors[i].Offset || f.Tensors[i].Offset > dataSize {
+			return nil, fmt.Errorf("%s: tensor %s lies outside the data section", filepath.Base(f.Path), f.Tensors[i].Name)
+		}
+		spans[i] = end - f.Tensors[i].Offset
+	}
+	return spans, nil
+}
+
+// ggufReader decodes little-endian GGUF values and counts consumed bytes
+type ggufReader struct {
+	r   *bufio.Reader
+	n   int64
+	raw *bytes.Buffer // When set, receives a copy of everything read
+}
+
+func (r *ggufReader) read(p []byte) error {
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		return err
+	}
+	r.n += int64(len(p))
+	if r.raw != nil {
+		r.raw.Write(p)
+	}
+	return nil
+}
+
+func (r *ggufReader) uint32() (uint32, error) {
+	var b [4]byte
+	if err := r.read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func (r *ggufReader) uint64() (uint64, error) {
+	var b [8]byte
+	if err := r.read(b[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func (r *ggufReader) string() (string, error) {
+	length, err := r.uint64()
+	if err != nil {
+		return "", err
+	}
+	if length > 1<<30 {
+		return "", fmt.Errorf("string of %d bytes is too long", length)
+	}
+	b := make([]byte, length)
+	if err := r.read(b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// ggufScalarSize returns the encoded size of a fixed-width value type
+func ggufScalarSize(valueType uint32) (int, bool) {
+	switch valueType {
+	case ggufTypeUint8, ggufTypeInt8, ggufTypeBool:
+		return 1, true
+	case ggufTypeUint16, ggufTypeInt16:
+		return 2, true
+	case ggufTypeUint32, ggufTypeInt32, ggufTypeFloat32:
+		return 4, true
+	case ggufTypeUint64, ggufTypeInt64, ggufTypeFloat64:
+		return 8, true
+	}
+	return 0, false
+}
+
+// value reads one metadata value, returning the decoded integer for integer types
+func (r *ggufReader) value(valueType uint32) (interface{}, error) {
+	if size, ok := ggufScalarSize(valueType); ok {
+		b := make([]byte, size)
+		if err := r.read(b); err != nil {
+			return nil, err
+		}
+		switch valueType {
+		case ggufTypeUint8, ggufTypeInt8:
+			return uint64(b[0]), nil
+		case ggufTypeUint16, ggufTypeInt16:
+			return uint64(binary.LittleEndian.Uint16(b)), nil
+		case ggufTypeUint32, ggufTypeInt32:
+			return uint64(binary.LittleEndian.Uint32(b)), nil
+		case ggufTypeUint64, ggufTypeInt64:
+			return binary.LittleEndian.Uint64(b), nil
+		}
+		return nil, nil
+	}
+
+	switch valueType {
+	case ggufTypeString:
+		_, err := r.string()
+		return nil, err
+	case ggufTypeArray:
+		elemType, err := r.uint32()
+		if err != nil {
+			return nil, err
+		}
+		count, err := r.uint64()
+		if err != nil {
+			return nil, err
+		}
+		if size, ok := ggufScalarSize(elemType); ok {
+			// Read fixed-width arrays in one piece
+			if count > 1<<34/uint64(size) {
+				return nil, fmt.Errorf("array of %d elements is too long", count)
+			}
+			return nil, r.read(make([]byte, count*uint64(size)))
+		}
+		for i := uint64(0); i < count; i++ {
+			if _, err := r.value(elemType); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown metadata value type %d", valueType)
+}
+
+// alignUp rounds n up to a multiple of alignment
+func alignUp(n uint64, alignment uint64) uint64 {
+	return (n + alignment - 1) / alignment * alignment
+}
+
+// readGGUF parses the header and tensor index of a GGUF file
+func readGGUF(path string) (*ggufFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ggufReader{r: bufio.NewReaderSize(f, 1024*1024)}
+	file := &ggufFile{Path: path, Size: info.Size(), Alignment: ggufDefaultAlignment}
+	name := filepath.Base(path)
+
+	magic, err := r.uint32()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if magic != ggufMagic {
+		return nil, fmt.Errorf("%s is not a GGUF file", name)
+	}
+	if file.Version, err = r.uint32(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if file.Version < 2 || file.Version > 3 {
+		return nil, fmt.Errorf("%s: unsupported GGUF version %d", name, file.Version)
+	}
+
+	tensorCount, err := r.uint64()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	kvCount, err := r.uint64()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	for i := uint64(0); i < kvCount; i++ {
+		key, err := r.string()
+		if err != nil {
+			return nil, fmt.Errorf("%s: metadata key %d: %w", name, i, err)
+		}
+		valueType, err := r.uint32()
+		if err != nil {
+			return nil, fmt.Errorf("%s: metadata %s: %w", name, key, err)
+		}
+
+		r.raw = &bytes.Buffer{}
+		value, err := r.value(valueType)
+		raw := r.raw.Bytes()
+		r.raw = nil
+		if err != nil {
+			return nil, fmt.Errorf("%s: metadata %s: %w", name, key, err)
+		}
+
+		file.KV = append(file.KV, ggufKV{Key: key, Type: valueType, Raw: raw, Value: value})
+		if key == ggufKeyAlignment && value != nil && value.(uint64) > 0 {
+			file.Alignment = value.(uint64)
+		}
+	}
+
+	for i := uint64(0); i < tensorCount; i++ {
+		var tensor ggufTensorInfo
+		if tensor.Name, err = r.string(); err != nil {
+			return nil, fmt.Errorf("%s: tensor %d: %w", name, i, err)
+		}
+		dims, err := r.uint32()
+		if err != nil {
+			return nil, fmt.Errorf("%s: tensor %s: %w", name, tensor.Name, err)
+		}
+		if dims > 8 {
+			return nil, fmt.Errorf("%s: tensor %s has %d dimensions", name, tensor.Name, dims)
+		}
+		tensor.Dims = make([]uint64, dims)
+		for d := range tensor.Dims {
+			if tensor.Dims[d], err = r.uint64(); err != nil {
+				return nil, fmt.Errorf("%s: tensor %s: %w", name, tensor.Name, err)
+			}
+		}
+		if tensor.Type, err = r.uint32(); err != nil {
+			return nil, fmt.Errorf("%s: tensor %s: %w", name, tensor.Name, err)
+		}
+		if tensor.Offset, err = r.uint64(); err != nil {
+			return nil, fmt.Errorf("%s: tensor %s: %w", name, tensor.Name, err)
+		}
+		if tensor.Offset%file.Alignment != 0 {
+			return nil, fmt.Errorf("%s: tensor %s is not aligned", name, tensor.Name)
+		}
+		file.Tensors = append(file.Tensors, tensor)
+	}
+
+	file.DataOffset = int64(alignUp(uint64(r.n), file.Alignment))
+	if file.DataOffset > file.Size {
+		return nil, fmt.Errorf("%s is truncated", name)
+	}
+	return file, nil
+}
+
+// ggufWriter encodes a GGUF file and counts written bytes
+type ggufWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *ggufWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *ggufWriter) uint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.write(b[:])
+}
+
+func (w *ggufWriter) uint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.write(b[:])
+}
+
+func (w *ggufWriter) string(s string) {
+	w.uint64(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+// pad writes zero bytes up to the next multiple of alignment
+func (w *ggufWriter) pad(alignment uint64) {
+	if padding := alignUp(uint64(w.n), alignment) - uint64(w.n); padding > 0 {
+		w.write(make([]byte, padding))
+	}
+}
+
+// header writes the magic, metadata and tensor index, padded to the data section
+func (w *ggufWriter) header(version uint32, kvs []ggufKV, tensors []ggufTensorInfo, alignment uint64) {
+	w.uint32(ggufMagic)
+	w.uint32(version)
+	w.uint64(uint64(len(tensors)))
+	w.uint64(uint64(len(kvs)))
+	for _, kv := range kvs {
+		w.string(kv.Key)
+		w.uint32(kv.Type)
+		w.write(kv.Raw)
+	}
+	for _, tensor := range tensors {
+		w.string(tensor.Name)
+		w.uint32(uint32(len(tensor.Dims)))
+		for _, dim := range tensor.Dims {
+			w.uint64(dim)
+		}
+		w.uint32(tensor.Type)
+		w.uint64(tensor.Offset)
+	}
+	w.pad(alignment)
+}
+
+// ggufShardGroups groups gguf-split shard files by model, keyed by the name
+// before the -NNNNN-of-NNNNN suffix. Files that are not shards are ignored.
+func ggufShardGroups(paths []string) map[string][]string {
+	groups := make(map[string][]string)
+	for _, p := range paths {
+		matches := ggufShardPattern.FindStringSubmatch(filepath.Base(p))
+		if matches == nil || matches[3] == "00001" {
+			continue
+		}
+		groups[matches[1]] = append(groups[matches[1]], p)
+	}
+	return groups
+}
+
+// mergeGGUFShards merges the shards of a gguf-split model into one GGUF file at
+// outPath. The tensor index of every shard is combined, tensor data is copied
+// in order and the split.* metadata is dropped. The result is re-read and
+// checked before it replaces outPath; the shards are left untouched.
+func mergeGGUFShards(paths []string, outPath string, onShard func(index int, total int)) error {
+	shards := make([]*ggufFile, 0, len(paths))
+	for _, p := range paths {
+		shard, err := readGGUF(p)
+		if err != nil {
+			return err
+		}
+		shards = append(shards, shard)
+	}
+
+	splitNo := func(f *ggufFile) uint64 {
+		n, _ := f.uint(ggufKeySplitNo)
+		return n
+	}
+	sort.Slice(shards, func(i, j int) bool { return splitNo(shards[i]) < splitNo(shards[j]) })
+
+	first := shards[0]
+	splitCount, ok := first.uint(ggufKeySplitCount)
+	if !ok {
+		return fmt.Errorf("%s has no %s metadata", filepath.Base(first.Path), ggufKeySplitCount)
+	}
+	if splitCount != uint64(len(shards)) {
+		return fmt.Errorf("model has %d shards but %d were found", splitCount, len(shards))
+	}
+	tensorsCount, ok := first.uint(ggufKeySplitTensorsCount)
+	if !ok {
+		return fmt.Errorf("%s has no %s metadata", filepath.Base(first.Path), ggufKeySplitTensorsCount)
+	}
+
+	// Check the shards belong together and build the merged tensor index
+	var tensors []ggufTensorInfo
+	var spans []uint64
+	names := make(map[string]bool)
+	var offset uint64
+	for i, shard := range shards {
+		name := filepath.Base(shard.Path)
+		if splitNo(shard) != uint64(i) {
+			return fmt.Errorf("shard %d of the model is missing", i+1)
+		}
+		if count, _ := shard.uint(ggufKeySplitCount); count != splitCount {
+			return fmt.Errorf("%s belongs to a model with %d shards, expected %d", name, count, splitCount)
+		}
+		if shard.Alignment != first.Alignment {
+			return fmt.Errorf("%s uses a different alignment than %s", name, filepath.Base(first.Path))
+		}
+
+		shardSpans, err := shard.tensorSpans()
+		if err != nil {
+			return err
+		}
+		for t, tensor := range shard.Tensors {
+			if names[tensor.Name] {
+				return fmt.Errorf("tensor %s appears in more than one shard", tensor.Name)
+			}
+			names[tensor.Name] = true
+
+			merged := tensor
+			merged.Offset = offset
+			tensors = append(tensors, merged)
+			spans = append(spans, alignUp(shardSpans[t], first.Alignment))
+			offset += spans[len(spans)-1]
+		}
+	}
+	if uint64(len(tensors)) != tensorsCount {
+		return fmt.Errorf("shards hold %d tensors but the model declares %d", len(tensors), tensorsCount)
+	}
+
+	var kvs []ggufKV
+	for _, kv := range first.KV {
+		if !strings.HasPrefix(kv.Key, "split.") {
+			kvs = append(kvs, kv)
+		}
+	}
+
+	tmpPath := outPath + partialSuffix
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := &ggufWriter{w: bufio.NewWriterSize(out, 4*1024*1024)}
+	w.header(first.Version, kvs, tensors, first.Alignment)
+	dataStart := w.n
+
+	checksum := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	index := 0
+	for i, shard := range shards {
+		if onShard != nil {
+			onShard(i+1, len(shards))
+		}
+		if err := copyGGUFTensors(w, checksum, shard, spans[index:index+len(shard.Tensors)]); err != nil {
+			out.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		index += len(shard.Tensors)
+	}
+
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if closeErr := out.Close(); w.err == nil {
+		w.err = closeErr
+	}
+	if w.err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(outPath), w.err)
+	}
+
+	if err := validateMergedGGUF(tmpPath, tensors, dataStart, offset, checksum.Sum32()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, outPath)
+}
+
+// copyGGUFTensors appends the data of every tensor in a shard, each padded to
+// its span, and feeds the written bytes to checksum. It stops at the first
+// write error, as w.n no longer tracks the output offset after one.
+func copyGGUFTensors(w *ggufWriter, checksum io.Writer, shard *ggufFile, spans []uint64) error {
+	if w.err != nil {
+		return w.err
+	}
+	f, err := os.Open(shard.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dest := io.MultiWriter(w.w, checksum)
+	for t, tensor := range shard.Tensors {
+		available := uint64(shard.Size-shard.DataOffset) - tensor.Offset
+		length := spans[t]
+		if length > available {
+			length = available
+		}
+
+		section := io.NewSectionReader(f, shard.DataOffset+int64(tensor.Offset), int64(length))
+		n, err := io.Copy(dest, section)
+		w.n += n
+		if err != nil {
+			return fmt.Errorf("failed to copy tensor %s from %s: %w", tensor.Name, filepath.Base(shard.Path), err)
+		}
+		if padding := spans[t] - length; padding > 0 {
+			zeros := make([]byte, padding)
+			checksum.Write(zeros)
+			w.write(zeros)
+			if w.err != nil {
+				return w.err
+			}
+		}
+	}
+	return nil
+}
+
+// validateMergedGGUF re-reads a merged file and checks its tensor index, size
+// and data checksum against what was written
+func validateMergedGGUF(path string, tensors []ggufTensorInfo, dataStart int64, dataSize uint64, expectedChecksum uint32) error {
+	merged, err := readGGUF(path)
+	if err != nil {
+		return fmt.Errorf("merged file is invalid: %w", err)
+	}
+	if merged.DataOffset != dataStart {
+		return fmt.Errorf("merged file has its data at offset %d, expected %d", merged.DataOffset, dataStart)
+	}
+	if merged.Size != dataStart+int64(dataSize) {
+		return fmt.Errorf("merged file is %d bytes, expected %d", merged.Size, dataStart+int64(dataSize))
+	}
+	if len(merged.Tensors) != len(tensors) {
+		return fmt.Errorf("merged file has %d tensors, expected %d", len(merged.Tensors), len(tensors))
+	}
+	for i, tensor := range merged.Tensors {
+		if tensor.Name != tensors[i].Name || tensor.Offset != tensors[i].Offset || tensor.Type != tensors[i].Type {
+			return fmt.Errorf("merged file has a corrupt index entry for tensor %s", tensors[i].Name)
+		}
+	}
+	if _, split := merged.uint(ggufKeySplitCount); split {
+		return fmt.Errorf("merged file still carries split metadata")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	checksum := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(checksum, io.NewSectionReader(f, dataStart, int64(dataSize))); err != nil {
+		return fmt.Errorf("failed to validate merged file: %w", err)
+	}
+	if checksum.Sum32() != expectedChecksum {
+		return fmt.Errorf("merged file data does not match the shards")
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testTensor is a tensor written into a synthetic GGUF file
+type testTensor struct {
+	name string
+	data []byte
+}
+
+// testShard describes one synthetic gguf-split shard
+type testShard struct {
+	splitNo      uint64
+	splitCount   uint64
+	tensorsCount uint64
+	tensors      []testTensor
+}
+
+// ggufUintKV encodes an integer metadata entry
+func ggufUintKV(key string, valueType uint32, value uint64) ggufKV {
+	size, _ := ggufScalarSize(valueType)
+	raw := make([]byte, 8)
+	binary.LittleEndian.PutUint64(raw, value)
+	return ggufKV{Key: key, Type: valueType, Raw: raw[:size], Value: value}
+}
+
+// ggufStringKV encodes a string metadata entry
+func ggufStringKV(key string, value string) ggufKV {
+	raw := binary.LittleEndian.AppendUint64(nil, uint64(len(value)))
+	return ggufKV{Key: key, Type: ggufTypeString, Raw: append(raw, value...)}
+}
+
+// writeTestShard writes a shard the way gguf-split lays it out: split.*
+// metadata, then tensors aligned to the default alignment
+func writeTestShard(t *testing.T, path string, shard testShard) {
+	t.Helper()
+	kvs := []ggufKV{
+		ggufStringKV("general.architecture", "llama"),
+		ggufUintKV(ggufKeySplitNo, ggufTypeUint16, shard.splitNo),
+		ggufUintKV(ggufKeySplitCount, ggufTypeUint16, shard.splitCount),
+		ggufUintKV(ggufKeySplitTensorsCount, ggufTypeInt32, shard.tensorsCount),
+	}
+
+	var tensors []ggufTensorInfo
+	var offset uint64
+	for _, tensor := range shard.tensors {
+		tensors = append(tensors, ggufTensorInfo{Name: tensor.name, Dims: []uint64{uint64(len(tensor.data))}, Type: ggufTypeUint8, Offset: offset})
+		offset = alignUp(offset+uint64(len(tensor.data)), ggufDefaultAlignment)
+	}
+
+	var buf bytes.Buffer
+	w := &ggufWriter{w: bufio.NewWriter(&buf)}
+	w.header(3, kvs, tensors, ggufDefaultAlignment)
+	for i, tensor := range shard.tensors {
+		w.write(tensor.data)
+		if i+1 < len(shard.tensors) {
+			w.pad(ggufDefaultAlignment)
+		}
+	}
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	if w.err != nil {
+		t.Fatal(w.err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tensorData returns deterministic, unaligned tensor content
+func tensorData(seed byte, length int) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = seed + byte(i)
+	}
+	return data
+}
+
+// writeTestShards writes a model split into count shards of two tensors each
+// and returns the shard paths and the tensors in order
+func writeTestShards(t *testing.T, dir string, count int) ([]string, []testTensor) {
+	t.Helper()
+	var paths []string
+	var all []testTensor
+	for i := 0; i < count; i++ {
+		tensors := []testTensor{
+			{name: fmt.Sprintf("blk.%d.attn_q.weight", i), data: tensorData(byte(i*50), 45+i)},
+			{name: fmt.Sprintf("blk.%d.ffn_up.weight", i), data: tensorData(byte(i*50+25), 64)},
+		}
+		path := filepath.Join(dir, fmt.Sprintf("model-%05d-of-%05d.gguf", i+1, count))
+		writeTestShard(t, path, testShard{splitNo: uint64(i), splitCount: uint64(count), tensorsCount: uint64(2 * count), tensors: tensors})
+		paths = append(paths, path)
+		all = append(all, tensors...)
+	}
+	return paths, all
+}
+
+func TestMergeGGUFShards(t *testing.T) {
+	for _, count := range []int{2, 3} {
+		t.Run(fmt.Sprintf("%d shards", count), func(t *testing.T) {
+			dir := t.TempDir()
+			paths, tensors := writeTestShards(t, dir, count)
+			outPath := filepath.Join(dir, "model.gguf")
+
+			// Shards are sorted by split.no, not by the order they are given in
+			reversed := make([]string, len(paths))
+			for i, p := range paths {
+				reversed[len(paths)-1-i] = p
+			}
+			var progress []int
+			if err := mergeGGUFShards(reversed, outPath, func(index int, total int) {
+				progress = append(progress, index)
+			}); err != nil {
+				t.Fatalf("mergeGGUFShards: %v", err)
+			}
+			if len(progress) != count {
+				t.Errorf("got progress %v for %d shards", progress, count)
+			}
+
+			merged, err := readGGUF(outPath)
+			if err != nil {
+				t.Fatalf("readGGUF: %v", err)
+			}
+			if _, split := merged.uint(ggufKeySplitCount); split {
+				t.Error("merged file still has split.count")
+			}
+			if len(merged.KV) != 1 || merged.KV[0].Key != "general.architecture" {
+				t.Errorf("got metadata %v, want only general.architecture", merged.KV)
+			}
+			if len(merged.Tensors) != len(tensors) {
+				t.Fatalf("got %d tensors, want %d", len(merged.Tensors), len(tensors))
+			}
+
+			content, err := os.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, tensor := range merged.Tensors {
+				want := tensors[i]
+				if tensor.Name != want.name {
+					t.Errorf("tensor %d is %s, want %s", i, tensor.Name, want.name)
+				}
+				if tensor.Offset%merged.Alignment != 0 {
+					t.Errorf("tensor %s at unaligned offset %d", tensor.Name, tensor.Offset)
+				}
+				start := merged.DataOffset + int64(tensor.Offset)
+				if got := content[start : start+int64(len(want.data))]; !bytes.Equal(got, want.data) {
+					t.Errorf("tensor %s data differs", tensor.Name)
+				}
+			}
+			if _, err := os.Stat(outPath + partialSuffix); !os.IsNotExist(err) {
+				t.Errorf("partial file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestMergeGGUFShardsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, dir string) []string
+		want    string
+	}{
+		{
+			name: "missing shard",
+			prepare: func(t *testing.T, dir string) []string {
+				paths, _ := writeTestShards(t, dir, 3)
+				return []string{paths[0], paths[2]}
+			},
+			want: "model has 3 shards but 2 were found",
+		},
+		{
+			name: "gap in split numbers",
+			prepare: func(t *testing.T, dir string) []string {
+				paths, _ := writeTestShards(t, dir, 2)
+				writeTestShard(t, paths[1], testShard{splitNo: 2, splitCount: 2, tensorsCount: 4, tensors: []testTensor{{name: "blk.1.attn_q.weight", data: tensorData(1, 8)}}})
+				return paths
+			},
+			want: "shard 2 of the model is missing",
+		},
+		{
+			name: "mismatched split count",
+			prepare: func(t *testing.T, dir string) []string {
+				paths, _ := writeTestShards(t, dir, 2)
+				writeTestShard(t, paths[1], testShard{splitNo: 1, splitCount: 3, tensorsCount: 4, tensors: []testTensor{{name: "blk.1.attn_q.weight", data: tensorData(1, 8)}}})
+				return paths
+			},
+			want: "belongs to a model with 3 shards, expected 2",
+		},
+		{
+			name: "duplicate tensor",
+			prepare: func(t *testing.T, dir string) []string {
+				paths, _ := writeTestShards(t, dir, 2)
+				writeTestShard(t, paths[1], testShard{splitNo: 1, splitCount: 2, tensorsCount: 4, tensors: []testTensor{
+					{name: "blk.0.attn_q.weight", data: tensorData(1, 8)},
+					{name: "blk.1.ffn_up.weight", data: tensorData(2, 8)},
+				}})
+				return paths
+			},
+			want: "tensor blk.0.attn_q.weight appears in more than one shard",
+		},
+		{
+			name: "tensor count mismatch",
+			prepare: func(t *testing.T, dir string) []string {
+				paths, _ := writeTestShards(t, dir, 2)
+				writeTestShard(t, paths[1], testShard{splitNo: 1, splitCount: 2, tensorsCount: 4, tensors: []testTensor{{name: "blk.1.attn_q.weight", data: tensorData(1, 8)}}})
+				return paths
+			},
+			want: "shards hold 3 tensors but the model declares 4",
+		},
+		{
+			name: "truncated shard",
+			prepare: func(t *testing.T, dir string) []string {
+				paths, _ := writeTestShards(t, dir, 2)
+				if err := os.Truncate(paths[1], 40); err != nil {
+					t.Fatal(err)
+				}
+				return paths
+			},
+			want: "model-00002-of-00002.gguf",
+		},
+		{
+			name: "not a GGUF file",
+			prepare: func(t *testing.T, dir string) []string {
+				paths, _ := writeTestShards(t, dir, 2)
+				if err := os.WriteFile(paths[0], []byte("not a model file"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return paths
+			},
+			want: "model-00001-of-00002.gguf is not a GGUF file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			paths := tt.prepare(t, dir)
+			outPath := filepath.Join(dir, "model.gguf")
+
+			err := mergeGGUFShards(paths, outPath, nil)
+			if err == nil {
+				t.Fatal("mergeGGUFShards succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %q, want it to contain %q", err, tt.want)
+			}
+			for _, p := range []string{outPath, outPath + partialSuffix} {
+				if _, err := os.Stat(p); !os.IsNotExist(err) {
+					t.Errorf("%s exists after a failed merge", filepath.Base(p))
+				}
+			}
+		})
+	}
+}
+
+func TestCopyGGUFTensorsStopsOnWriteError(t *testing.T) {
+	dir := t.TempDir()
+	paths, _ := writeTestShards(t, dir, 1)
+	shard, err := readGGUF(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans, err := shard.tensorSpans()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out, checksum bytes.Buffer
+	errDiskFull := errors.New("disk full")
+	w := &ggufWriter{w: bufio.NewWriter(&out), n: 100, err: errDiskFull}
+	if err := copyGGUFTensors(w, &checksum, shard, spans); !errors.Is(err, errDiskFull) {
+		t.Fatalf("got %v, want %v", err, errDiskFull)
+	}
+	if w.n != 100 || w.w.Buffered() != 0 || checksum.Len() != 0 {
+		t.Errorf("wrote %d bytes after a write error", w.w.Buffered()+checksum.Len())
+	}
+}
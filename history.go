@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyFileName is the append-only download history, one JSON entry per line
+const historyFileName = "history.jsonl"
+
+// historyLineLimit bounds a single history line when reading
+const historyLineLimit = 1 << 20
+
+// Outcomes recorded in the download history
+const (
+	HistoryDone      = "done"
+	HistoryFailed    = "failed"
+	HistoryCancelled = "cancelled"
+)
+
+// HistoryEntry records one finished, failed or cancelled product file download
+type HistoryEntry struct {
+	ProductSlug string    `json:"product_slug"`
+	ProductName string    `json:"product_name,omitempty"`
+	ReleaseID   int       `json:"release_id"`
+	Version     string    `json:"version,omitempty"`
+	FileID      int       `json:"file_id"`
+	FileName    string    `json:"file_name,omitempty"`
+	ObjectName  string    `json:"object_name,omitempty"` // Product file name the download layout was applied to
+	SHA256      string    `json:"sha256,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	Duration    float64   `json:"duration_seconds"`
+	Profile     string    `json:"profile,omitempty"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Retries     int       `json:"retries,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+// HistoryQuery selects history entries. Empty fields match everything.
+type HistoryQuery struct {
+	Profile string    `json:"profile,omitempty"`
+	Product string    `json:"product,omitempty"` // Part of the product slug or name
+	Version string    `json:"version,omitempty"`
+	Outcome string    `json:"outcome,omitempty"`
+	Search  string    `json:"search,omitempty"` // Part of the product, version, file name or path
+	Since   time.Time `json:"since,omitempty"`
+	Until   time.Time `json:"until,omitempty"`
+	Limit   int       `json:"limit,omitempty"` // Newest entries only, all when zero
+}
+
+// matches reports whether an entry fits the query
+func (q HistoryQuery) matches(entry HistoryEntry) bool {
+	if q.Profile != "" && entry.Profile != q.Profile {
+		return false
+	}
+	if q.Product != "" {
+		product := strings.ToLower(q.Product)
+		if !strings.Contains(strings.ToLower(entry.ProductSlug), product) &&
+			!strings.Contains(strings.ToLower(entry.ProductName), product) {
+			return false
+		}
+	}
+	if q.Version != "" && entry.Version != q.Version {
+		return false
+	}
+	if q.Outcome != "" && entry.Outcome != q.Outcome {
+		return false
+	}
+	if !q.Since.IsZero() && entry.FinishedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !entry.FinishedAt.Before(q.Until) {
+		return false
+	}
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		fields := []string{entry.ProductSlug, entry.ProductName, entry.Version, entry.FileName, entry.Path}
+		found := false
+		for _, field := range fields {
+			if strings.Contains(strings.ToLower(field), search) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// getHistoryPath returns the path to the download history
+func (b *BroadcomService) getHistoryPath() (string, error) {
+	configDir, err := b.getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, historyFileName), nil
+}
+
+// newHistoryEntry describes the outcome of a download. A nil error is a
+// completed download; cancellations are recorded as cancelled.
+func newHistoryEntry(req DownloadRequest, startedAt time.Time, path string, file *ProductFile, err error) HistoryEntry {
+	finishedAt := time.Now()
+	entry := HistoryEntry{
+		ProductSlug: req.ProductSlug,
+		ProductName: req.ProductName,
+		ReleaseID:   req.ReleaseID,
+		Version:     req.Version,
+		FileID:      req.FileID,
+		FileName:    req.FileName,
+		Profile:     req.Profile,
+		Path:        path,
+		StartedAt:   startedAt,
+		FinishedAt:  finishedAt,
+		Duration:    finishedAt.Sub(startedAt).Seconds(),
+	}
+	if file != nil {
+		entry.SHA256 = file.SHA256
+		entry.Size = file.Size
+		entry.ObjectName = productFileName(*file)
+		if entry.FileName == "" {
+			entry.FileName = productFileName(*file)
+		}
+	}
+
+	switch {
+	case err == nil:
+		entry.Outcome = HistoryDone
+	case errors.Is(err, errDownloadCancelled):
+		entry.Outcome = HistoryCancelled
+		entry.Path = ""
+	default:
+		entry.Outcome = HistoryFailed
+		entry.Error = err.Error()
+		entry.Path = ""
+	}
+	return entry
+}
+
+// recordHistory appends an entry to the download history. Failures are
+// logged, since the download itself is not affected.
+func (b *BroadcomService) recordHistory(entry HistoryEntry) {
+	if entry.Profile == "" {
+		entry.Profile = b.activeProfileName()
+	}
+
+	if err := b.appendHistory(entry); err != nil {
+		fmt.Printf("Could not record download history: %v\n", err)
+	}
+}
+
+// appendHistory writes one entry as a JSON line
+func (b *BroadcomService) appendHistory(entry HistoryEntry) error {
+	path, err := b.getHistoryPath()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	b.historyMutex.Lock()
+	defer b.historyMutex.Unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// QueryDownloadHistory returns the recorded downloads that match the query,
+// newest first
+func (b *BroadcomService) QueryDownloadHistory(query HistoryQuery) ([]HistoryEntry, error) {
+	path, err := b.getHistoryPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b.historyMutex.Lock()
+	defer b.historyMutex.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []HistoryEntry{}, nil
+		}
+		return nil, fmt.Errorf("failed to read download history: %w", err)
+	}
+	defer f.Close()
+
+	entries := []HistoryEntry{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), historyLineLimit)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A line cut short by a crash does not hide the rest
+			continue
+		}
+		if query.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read download history: %w", err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].FinishedAt.After(entries[j].FinishedAt)
+	})
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+	return entries, nil
+}
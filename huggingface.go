@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultHuggingFaceEndpoint is used unless HF_ENDPOINT points at a mirror
+const defaultHuggingFaceEndpoint = "https://huggingface.co"
+
+// hfTreeEntry is a file or directory returned by the Hub tree API
+type hfTreeEntry struct {
+	Type string `json:"type"` // "file" or "directory"
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	OID  string `json:"oid"`
+	LFS  *struct {
+		OID  string `json:"oid"` // SHA256 of the file contents
+		Size int64  `json:"size"`
+	} `json:"lfs,omitempty"`
+}
+
+// sha256 returns the content checksum the Hub publishes for LFS files
+func (e hfTreeEntry) sha256() string {
+	if e.LFS != nil {
+		return e.LFS.OID
+	}
+	return ""
+}
+
+// hfRepoRef identifies a location inside a model repository
+type hfRepoRef struct {
+	Repo     string // owner/name
+	Revision string // Branch, tag or commit
+	Path     string // Folder inside the repo, empty for the root
+}
+
+// hfClient talks to the HuggingFace Hub HTTP API
+type hfClient struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// newHuggingFaceClient creates a Hub client configured from HF_ENDPOINT and
+// HF_TOKEN. The transport carries proxy and TLS settings; nil uses the
+// proxies from the environment.
+func newHuggingFaceClient(transport *http.Transport) *hfClient {
+	if transport == nil {
+		transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+	transport.TLSHandshakeTimeout = 30 * time.Second
+	transport.ResponseHeaderTimeout = 60 * time.Second
+
+	endpoint := strings.TrimSuffix(os.Getenv("HF_ENDPOINT"), "/")
+	if endpoint == "" {
+		endpoint = defaultHuggingFaceEndpoint
+	}
+
+	return &hfClient{
+		endpoint: endpoint,
+		token:    huggingFaceToken(),
+		client:   &http.Client{Transport: transport},
+	}
+}
+
+// huggingFaceToken returns the access token from the environment, falling back
+// to the token saved by `hf auth login`
+func huggingFaceToken() string {
+	for _, name := range []string{"HF_TOKEN", "HUGGING_FACE_HUB_TOKEN"} {
+		if token := strings.TrimSpace(os.Getenv(name)); token != "" {
+			return token
+		}
+	}
+
+	tokenPath := os.Getenv("HF_TOKEN_PATH")
+	if tokenPath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		tokenPath = filepath.Join(home, ".cache", "huggingface", "token")
+	}
+	data, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// parseHuggingFaceURL splits a model URL such as
+// https://huggingface.co/unsloth/Llama-3.3-70B-Instruct-GGUF/tree/main/UD-Q6_K_XL
+// into repository, revision and folder. A bare owner/name is also accepted.
+func (c *hfClient) parseHuggingFaceURL(repoURL string) (hfRepoRef, error) {
+	trimmed := strings.TrimSpace(repoURL)
+	for _, prefix := range []string{c.endpoint + "/", defaultHuggingFaceEndpoint + "/"} {
+		trimmed = strings.TrimPrefix(trimmed, prefix)
+	}
+	if strings.Contains(trimmed, "://") {
+		u, err := url.Parse(trimmed)
+		if err != nil {
+			return hfRepoRef{}, fmt.Errorf("invalid HuggingFace URL: %w", err)
+		}
+		trimmed = u.Path
+	}
+	trimmed = strings.Trim(trimmed, "/")
+
+	parts := strings.Split(trimmed, "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return hfRepoRef{}, fmt.Errorf("invalid HuggingFace URL format: %s", repoURL)
+	}
+
+	ref := hfRepoRef{Repo: parts[0] + "/" + parts[1], Revision: "main"}
+	if len(parts) >= 4 && (parts[2] == "tree" || parts[2] == "blob" || parts[2] == "resolve") {
+		ref.Revision = parts[3]
+		ref.Path = strings.Join(parts[4:], "/")
+	}
+	return ref, nil
+}
+
+// newRequest creates an authenticated request against the Hub
+func (c *hfClient) newRequest(ctx context.Context, method string, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("User-Agent", "tile-downloader")
+	return req, nil
+}
+
+// checkResponse turns Hub error statuses into actionable errors
+func (c *hfClient) checkResponse(resp *http.Response, repo string) error {
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return nil
+	case http.StatusUnauthorized:
+		if c.token == "" {
+			return fmt.Errorf("HuggingFace requires authentication for %s; set HF_TOKEN to an access token", repo)
+		}
+		return fmt.Errorf("HuggingFace rejected the access token for %s; check HF_TOKEN", repo)
+	case http.StatusForbidden:
+		return fmt.Errorf("access to %s is gated; accept its license on HuggingFace and set HF_TOKEN", repo)
+	case http.StatusNotFound:
+		return fmt.Errorf("%s was not found on HuggingFace", repo)
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("HuggingFace request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
+
+// escapePath escapes each segment of a repository path for use in a URL
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// listFiles returns the files in a repository folder, following pagination
+func (c *hfClient) listFiles(ctx context.Context, ref hfRepoRef, recursive bool) ([]hfTreeEntry, error) {
+	next := fmt.Sprintf("%s/api/models/%s/tree/%s", c.endpoint, ref.Repo, url.PathEscape(ref.Revision))
+	if ref.Path != "" {
+		next += "/" + escapePath(ref.Path)
+	}
+	if recursive {
+		next += "?recursive=true"
+	}
+
+	var files []hfTreeEntry
+	for next != "" {
+		req, err := c.newRequest(ctx, http.MethodGet, next)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", ref.Repo, err)
+		}
+
+		if err := c.checkResponse(resp, ref.Repo); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var page []hfTreeEntry
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse file list of %s: %w", ref.Repo, err)
+		}
+
+		for _, entry := range page {
+			if entry.Type == "file" {
+				files = append(files, entry)
+			}
+		}
+
+		next = ""
+		if matches := linkNextPattern.FindStringSubmatch(resp.Header.Get("Link")); matches != nil {
+			next = matches[1]
+		}
+	}
+	return files, nil
+}
+
+// downloadFile fetches one repository file to dest, resuming a partial download
+// and verifying the LFS checksum when the Hub publishes one. onProgress receives
+// the number of bytes written so far.
+func (c *hfClient) downloadFile(ctx context.Context, ref hfRepoRef, file hfTreeEntry, dest string, onProgress func(written int64)) error {
+	if info, err := os.Stat(dest); err == nil && info.Size() == file.Size {
+		// Already downloaded by an earlier run
+		if onProgress != nil {
+			onProgress(file.Size)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	partialPath := dest + partialSuffix
+	var offset int64
+	if info, err := os.Stat(partialPath); err == nil {
+		offset = info.Size()
+	}
+
+	fileURL := fmt.Sprintf("%s/%s/resolve/%s/%s", c.endpoint, ref.Repo, url.PathEscape(ref.Revision), escapePath(file.Path))
+	req, err := c.newRequest(ctx, http.MethodGet, fileURL)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", file.Path, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			// The server resumed somewhere else; start over without the partial file
+			resp.Body.Close()
+			if err := os.Remove(partialPath); err != nil {
+				return err
+			}
+			return c.downloadFile(ctx, ref, file, dest, onProgress)
+		}
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		if offset != file.Size {
+			// The partial file is longer than the file; start over without it
+			resp.Body.Close()
+			if err := os.Remove(partialPath); err != nil {
+				return err
+			}
+			return c.downloadFile(ctx, ref, file, dest, onProgress)
+		}
+		// The partial file already holds every byte
+		flags |= os.O_APPEND
+	default:
+		if err := c.checkResponse(resp, ref.Repo); err != nil {
+			return err
+		}
+		// The server ignored the range and sent the whole file
+		flags |= os.O_TRUNC
+		offset = 0
+	}
+
+	out, err := os.OpenFile(partialPath, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	written := offset
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		buf := make([]byte, 1024*1024)
+		for {
+			n, readErr := resp.Body.Read(buf)
+			if n > 0 {
+				if _, err := out.Write(buf[:n]); err != nil {
+					out.Close()
+					return err
+				}
+				written += int64(n)
+				if onProgress != nil {
+					onProgress(written)
+				}
+			}
+			if readErr == io.EOF {
+				break
+			}
+			if readErr != nil {
+				out.Close()
+				if errors.Is(readErr, context.Canceled) {
+					return readErr
+				}
+				return fmt.Errorf("failed to download %s: %w", file.Path, readErr)
+			}
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if onProgress != nil {
+		onProgress(written)
+	}
+
+	if written != file.Size {
+		os.Remove(partialPath)
+		return fmt.Errorf("download of %s is incomplete: expected %d bytes, got %d", file.Path, file.Size, written)
+	}
+
+	if expected := file.sha256(); expected != "" {
+		actual, err := hashFile(partialPath, sha256.New())
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(actual, expected) {
+			os.Remove(partialPath)
+			return fmt.Errorf("%w for %s: expected %s, got %s", errChecksumMismatch, file.Path, expected, actual)
+		}
+	}
+
+	return os.Rename(partialPath, dest)
+}
+
+// contentRangeStart returns the first byte of a "bytes start-end/size" Content-Range header
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return start, true
+}
+
+// matchHuggingFaceFiles returns the files whose base name matches any of the
+// patterns. With rootOnly, files in subfolders are skipped.
+func matchHuggingFaceFiles(files []hfTreeEntry, patterns []string, rootOnly bool) []hfTreeEntry {
+	var matched []hfTreeEntry
+	for _, file := range files {
+		if rootOnly && strings.Contains(file.Path, "/") {
+			continue
+		}
+		name := path.Base(file.Path)
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, strings.ToLower(name)); ok {
+				matched = append(matched, file)
+				break
+			}
+		}
+	}
+	return matched
+}
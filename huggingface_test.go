@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHuggingFaceDownloadFileResume(t *testing.T) {
+	const content = "0123456789"
+
+	tests := []struct {
+		name    string
+		partial string
+		// serve answers a request that asked for the bytes from start, or -1 without a Range header
+		serve   func(w http.ResponseWriter, start int)
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "resumes from the partial file",
+			partial: "01234",
+			serve:   serveRange(content),
+			want:    content,
+		},
+		{
+			name:    "complete partial file",
+			partial: content,
+			serve:   serveRange(content),
+			want:    content,
+		},
+		{
+			name:    "partial file longer than the file",
+			partial: content + "XX",
+			serve:   serveRange(content),
+			want:    content,
+		},
+		{
+			name:    "range not satisfiable for a truncated partial file",
+			partial: "01234",
+			serve: func(w http.ResponseWriter, start int) {
+				if start >= 0 {
+					w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+					return
+				}
+				w.Write([]byte(content))
+			},
+			want: content,
+		},
+		{
+			name:    "content range at another offset",
+			partial: "01234",
+			serve: func(w http.ResponseWriter, start int) {
+				if start >= 0 {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(content)-1, len(content)))
+					w.WriteHeader(http.StatusPartialContent)
+				}
+				w.Write([]byte(content))
+			},
+			want: content,
+		},
+		{
+			name: "short body",
+			serve: func(w http.ResponseWriter, start int) {
+				w.Write([]byte(content[:8]))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				start := -1
+				if rng := r.Header.Get("Range"); rng != "" {
+					start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+				}
+				tt.serve(w, start)
+			}))
+			t.Cleanup(srv.Close)
+
+			dest := filepath.Join(t.TempDir(), "model.safetensors")
+			if tt.partial != "" {
+				if err := os.WriteFile(dest+partialSuffix, []byte(tt.partial), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			client := &hfClient{endpoint: srv.URL, client: srv.Client()}
+			ref := hfRepoRef{Repo: "owner/model", Revision: "main"}
+			file := hfTreeEntry{Type: "file", Path: "model.safetensors", Size: int64(len(content))}
+			err := client.downloadFile(context.Background(), ref, file, dest, nil)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if _, err := os.Stat(dest); !os.IsNotExist(err) {
+					t.Errorf("incomplete file was moved into place")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("downloadFile: %v", err)
+			}
+			data, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("got %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
+
+// serveRange answers like the Hub: the requested bytes with 206, or 416 past the end
+func serveRange(content string) func(w http.ResponseWriter, start int) {
+	return func(w http.ResponseWriter, start int) {
+		if start < 0 {
+			w.Write([]byte(content))
+			return
+		}
+		if start >= len(content) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(content[start:]))
+	}
+}
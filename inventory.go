@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// inventoryFileName caches the SHA256 of files in the download location,
+// so they are only hashed again after they change
+const inventoryFileName = "inventory.json"
+
+// Local statuses of a product file, set by AnnotateLocalStatus
+const (
+	LocalPresent  = "present"  // A file with the expected size is at its layout path, not yet hashed
+	LocalVerified = "verified" // A file with the expected SHA256 is on disk
+	LocalStale    = "stale"    // The file at its layout path differs from the API record
+)
+
+// InventoryFile is a file found in the download location
+type InventoryFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256,omitempty"` // Empty until the file has been hashed
+}
+
+// inventoryHash is a cached checksum, valid while size and modification time match
+type inventoryHash struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// getInventoryPath returns the path to the inventory hash cache
+func (b *BroadcomService) getInventoryPath() (string, error) {
+	configDir, err := b.getConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, inventoryFileName), nil
+}
+
+// loadInventoryHashes reads the hash cache. Callers must hold b.inventoryMutex.
+func (b *BroadcomService) loadInventoryHashes() map[string]inventoryHash {
+	hashes := make(map[string]inventoryHash)
+	path, err := b.getInventoryPath()
+	if err != nil {
+		return hashes
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &hashes)
+	}
+	return hashes
+}
+
+// saveInventoryHashes writes the hash cache. Callers must hold b.inventoryMutex.
+func (b *BroadcomService) saveInventoryHashes(hashes map[string]inventoryHash) error {
+	path, err := b.getInventoryPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(hashes, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// cachedChecksum returns the cached SHA256 of a file, or an empty string when
+// the file changed since it was hashed
+func (b *BroadcomService) cachedChecksum(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+
+	b.inventoryMutex.Lock()
+	defer b.inventoryMutex.Unlock()
+
+	cached, exists := b.loadInventoryHashes()[path]
+	if !exists || cached.Size != info.Size() || !cached.ModTime.Equal(info.ModTime()) {
+		return ""
+	}
+	return cached.SHA256
+}
+
+// rememberChecksum caches the SHA256 of a verified download
+func (b *BroadcomService) rememberChecksum(path string, checksum string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	b.inventoryMutex.Lock()
+	defer b.inventoryMutex.Unlock()
+
+	hashes := b.loadInventoryHashes()
+	hashes[path] = inventoryHash{Size: info.Size(), ModTime: info.ModTime(), SHA256: checksum}
+	if err := b.saveInventoryHashes(hashes); err != nil {
+		fmt.Printf("Could not save inventory: %v\n", err)
+	}
+}
+
+// scanInventory lists the files under root with their cached checksums.
+// With hash set, files without a valid cached checksum are hashed. Partial
+// downloads and quarantined files are skipped.
+func (b *BroadcomService) scanInventory(root string, hash bool) ([]InventoryFile, error) {
+	// Cached paths are clean, so a trailing separator or ./ must not hide them
+	root = filepath.Clean(root)
+
+	b.inventoryMutex.Lock()
+	defer b.inventoryMutex.Unlock()
+
+	hashes := b.loadInventoryHashes()
+	changed := false
+	seen := make(map[string]bool)
+	files := []InventoryFile{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return nil // Unreadable entries are left out
+		}
+		if d.IsDir() {
+			if d.Name() == quarantineDirName {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), partialSuffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		file := InventoryFile{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+		seen[path] = true
+		if cached, exists := hashes[path]; exists && cached.Size == file.Size && cached.ModTime.Equal(file.ModTime) {
+			file.SHA256 = cached.SHA256
+		} else if hash {
+			checksum, err := hashFile(path, sha256.New())
+			if err != nil {
+				return fmt.Errorf("failed to hash %s: %w", path, err)
+			}
+			file.SHA256 = checksum
+			hashes[path] = inventoryHash{Size: file.Size, ModTime: file.ModTime, SHA256: checksum}
+			changed = true
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Forget files under root that are gone
+	prefix := root
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	for path := range hashes {
+		if strings.HasPrefix(path, prefix) && !seen[path] {
+			delete(hashes, path)
+			changed = true
+		}
+	}
+	if changed {
+		if err := b.saveInventoryHashes(hashes); err != nil {
+			return nil, fmt.Errorf("failed to save inventory: %w", err)
+		}
+	}
+	return files, nil
+}
+
+// localStatus matches a product file against the inventory by SHA256 anywhere
+// in the download location, or by the path the download layout gives it. A
+// file of the same name elsewhere may belong to another product or version,
+// so it only counts once its checksum matches. It returns an empty status when
+// the file is not on disk.
+func localStatus(file ProductFile, expectedPath string, inventory []InventoryFile) (state string, path string) {
+	expected := strings.ToLower(file.SHA256)
+
+	rank := map[string]int{"": 0, LocalStale: 1, LocalPresent: 2, LocalVerified: 3}
+	for _, candidate := range inventory {
+		var candidateState string
+		switch {
+		case expected != "" && candidate.SHA256 == expected:
+			// Found by checksum, whatever the file is called
+			candidateState = LocalVerified
+		case expectedPath == "" || candidate.Path != expectedPath:
+			continue
+		case candidate.SHA256 != "" && expected != "":
+			candidateState = LocalStale
+		case file.Size > 0 && candidate.Size != file.Size:
+			candidateState = LocalStale
+		default:
+			candidateState = LocalPresent
+		}
+		if rank[candidateState] > rank[state] {
+			state, path = candidateState, candidate.Path
+		}
+	}
+	return state, path
+}
+
+// localRelease is a release whose files are annotated with their local status
+type localRelease struct {
+	productSlug string
+	version     string
+	files       []ProductFile
+}
+
+// annotateLocalStatus sets the local status of the files of one or more
+// releases with a single scan of the download location. Files are not hashed
+// here; run ScanInventory with verify to check the ones that are only present.
+func (b *BroadcomService) annotateLocalStatus(releases ...localRelease) error {
+	location, err := b.GetDownloadLocation()
+	if err != nil {
+		return err
+	}
+	inventory, err := b.scanInventory(location, false)
+	if err != nil {
+		return err
+	}
+	for _, release := range releases {
+		for i := range release.files {
+			file := &release.files[i]
+			expectedPath, _ := b.productFilePath(location, release.productSlug, release.version, *file)
+			file.LocalStatus, file.LocalPath = localStatus(*file, expectedPath, inventory)
+		}
+	}
+	return nil
+}
+
+// AnnotateLocalStatus marks the files of a release that are already in the
+// download location as present, verified or stale. It scans the download
+// location once per call, so listings only pay for it when they ask.
+func (b *BroadcomService) AnnotateLocalStatus(productSlug string, version string, files []ProductFile) ([]ProductFile, error) {
+	if err := b.annotateLocalStatus(localRelease{productSlug: productSlug, version: version, files: files}); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ScanInventory indexes the download location of the active profile. With
+// verify set, files that have not been hashed yet are hashed, which turns
+// "present" product files into "verified" or "stale" ones.
+func (b *BroadcomService) ScanInventory(verify bool) ([]InventoryFile, error) {
+	location, err := b.GetDownloadLocation()
+	if err != nil {
+		return nil, err
+	}
+	return b.scanInventory(location, verify)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocalStatus(t *testing.T) {
+	const sum = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	file := ProductFile{AWSObjectKey: "product-files/p-rabbitmq/p-rabbitmq.pivotal", Size: 3, SHA256: sum}
+	expectedPath := filepath.Join("downloads", "p-rabbitmq", "10.0.3", "p-rabbitmq.pivotal")
+	otherVersion := filepath.Join("downloads", "p-rabbitmq", "10.0.2", "p-rabbitmq.pivotal")
+
+	tests := []struct {
+		name      string
+		file      ProductFile
+		inventory []InventoryFile
+		wantState string
+		wantPath  string
+	}{
+		{name: "not on disk", file: file},
+		{name: "at its layout path", file: file, inventory: []InventoryFile{{Path: expectedPath, Size: 3}}, wantState: LocalPresent, wantPath: expectedPath},
+		{name: "wrong size", file: file, inventory: []InventoryFile{{Path: expectedPath, Size: 4}}, wantState: LocalStale, wantPath: expectedPath},
+		{name: "wrong checksum", file: file, inventory: []InventoryFile{{Path: expectedPath, Size: 3, SHA256: "00"}}, wantState: LocalStale, wantPath: expectedPath},
+		{name: "verified at its layout path", file: file, inventory: []InventoryFile{{Path: expectedPath, Size: 3, SHA256: sum}}, wantState: LocalVerified, wantPath: expectedPath},
+		{name: "same name in another version", file: file, inventory: []InventoryFile{{Path: otherVersion, Size: 3}}},
+		{name: "same name and other checksum", file: file, inventory: []InventoryFile{{Path: otherVersion, Size: 3, SHA256: "00"}}},
+		{name: "verified under another name", file: file, inventory: []InventoryFile{{Path: "renamed.pivotal", Size: 3, SHA256: sum}}, wantState: LocalVerified, wantPath: "renamed.pivotal"},
+		{
+			name:      "verified copy wins over a stale one",
+			file:      file,
+			inventory: []InventoryFile{{Path: expectedPath, Size: 4}, {Path: otherVersion, Size: 3, SHA256: sum}},
+			wantState: LocalVerified,
+			wantPath:  otherVersion,
+		},
+		{name: "no size or checksum from the API", file: ProductFile{AWSObjectKey: file.AWSObjectKey}, inventory: []InventoryFile{{Path: expectedPath, Size: 4}}, wantState: LocalPresent, wantPath: expectedPath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, path := localStatus(tt.file, expectedPath, tt.inventory)
+			if state != tt.wantState || path != tt.wantPath {
+				t.Errorf("localStatus = %q, %q; want %q, %q", state, path, tt.wantState, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestScanInventory(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	b := NewBroadcomService()
+
+	root := t.TempDir()
+	kept := filepath.Join(root, "tile", "kept.pivotal")
+	removed := filepath.Join(root, "removed.pivotal")
+	for path, content := range map[string]string{kept: "foo", removed: "bar", kept + partialSuffix: "partial"} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := b.scanInventory(root+string(filepath.Separator), true)
+	if err != nil {
+		t.Fatalf("scanInventory: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("scanInventory listed %+v, want the two finished files", files)
+	}
+	if sum := b.cachedChecksum(kept); sum != "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" {
+		t.Errorf("cached checksum %q, want the SHA256 of foo", sum)
+	}
+
+	// A removed file is forgotten, however the download location is spelled
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.scanInventory(root+string(filepath.Separator)+".", false); err != nil {
+		t.Fatalf("scanInventory: %v", err)
+	}
+	b.inventoryMutex.Lock()
+	hashes := b.loadInventoryHashes()
+	b.inventoryMutex.Unlock()
+	if _, exists := hashes[removed]; exists || len(hashes) != 1 {
+		t.Errorf("hash cache %v still holds %s", hashes, removed)
+	}
+}
+
+func TestCachedChecksum(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	b := NewBroadcomService()
+
+	path := filepath.Join(t.TempDir(), "kept.pivotal")
+	if err := os.WriteFile(path, []byte("foo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.rememberChecksum(path, "cafe")
+	if sum := b.cachedChecksum(path); sum != "cafe" {
+		t.Fatalf("cached checksum %q, want cafe", sum)
+	}
+
+	// Files that changed since they were hashed are hashed again
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if sum := b.cachedChecksum(path); sum != "" {
+		t.Errorf("cached checksum %q of a changed file, want none", sum)
+	}
+	files, err := b.scanInventory(filepath.Dir(path), false)
+	if err != nil || len(files) != 1 || files[0].SHA256 != "" {
+		t.Errorf("scanInventory without hashing = %+v, %v; want the file without a checksum", files, err)
+	}
+	if files, err = b.scanInventory(filepath.Dir(path), true); err != nil || len(files) != 1 || files[0].SHA256 != "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae" {
+		t.Errorf("scanInventory with hashing = %+v, %v; want the SHA256 of foo", files, err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultDownloadLayout puts every file directly into the download location
+const defaultDownloadLayout = "{file}"
+
+// Kinds of downloads, available to layouts as {kind}
+const (
+	KindTile       = "tile"
+	KindStemcell   = "stemcell"
+	KindOpsManager = "ops-manager"
+	KindAIModel    = "ai-model"
+	KindFile       = "file" // Anything else, such as CLIs and documentation
+)
+
+// layoutPlaceholderPattern matches placeholders such as {product_slug}
+var layoutPlaceholderPattern = regexp.MustCompile(`\{([a-z_]*)\}`)
+
+// layoutValueReplacer keeps placeholder values within one path element
+var layoutValueReplacer = strings.NewReplacer("/", "-", "\\", "-", ":", "-")
+
+// DownloadLayoutPreset is a named layout offered in settings
+type DownloadLayoutPreset struct {
+	Name        string `json:"name"`
+	Template    string `json:"template"`
+	Description string `json:"description"`
+}
+
+// downloadLayoutPresets lists the layouts that can be selected by name
+var downloadLayoutPresets = []DownloadLayoutPreset{
+	{Name: "flat", Template: defaultDownloadLayout, Description: "All files directly in the download location"},
+	{Name: "product", Template: "{product_slug}/{version}/{file}", Description: "One directory per product and version"},
+	{Name: "kind", Template: "{kind}/{product_slug}/{version}/{file}", Description: "Tiles, stemcells, Ops Manager images and AI models apart, then by product and version"},
+	{Name: "platform-automation", Template: "{kind}/[{product_slug},{version}]{file}", Description: "File names as Platform Automation's download-product task stores them in a blobstore"},
+}
+
+// layoutFields are the values a layout template is rendered with
+type layoutFields struct {
+	Kind        string
+	ProductSlug string
+	Version     string
+	File        string
+	Profile     string
+}
+
+// value returns the value of a placeholder, reporting false for unknown names
+func (f layoutFields) value(name string) (string, bool) {
+	var value string
+	switch name {
+	case "kind":
+		value = f.Kind
+	case "product_slug":
+		value = f.ProductSlug
+	case "version":
+		value = f.Version
+	case "file":
+		value = f.File
+	case "profile":
+		value = f.Profile
+	default:
+		return "", false
+	}
+
+	value = layoutValueReplacer.Replace(value)
+	if value == "" || value == "." || value == ".." {
+		value = "_"
+	}
+	return value, true
+}
+
+// fileKind classifies a product file for the {kind} placeholder
+func fileKind(productSlug string, fileName string) string {
+	slug := strings.ToLower(productSlug)
+	switch {
+	case strings.Contains(slug, "stemcell"):
+		return KindStemcell
+	case strings.Contains(slug, "ops-manager"):
+		return KindOpsManager
+	case strings.HasSuffix(strings.ToLower(fileName), ".pivotal"):
+		return KindTile
+	default:
+		return KindFile
+	}
+}
+
+// renderLayout turns a layout template into a relative path
+func renderLayout(template string, fields layoutFields) (string, error) {
+	var unknown string
+	rendered := layoutPlaceholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := fields.value(strings.Trim(placeholder, "{}"))
+		if !ok && unknown == "" {
+			unknown = placeholder
+		}
+		return value
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown placeholder %s in download layout", unknown)
+	}
+
+	path := filepath.Clean(filepath.FromSlash(rendered))
+	if filepath.IsAbs(path) || filepath.VolumeName(path) != "" || path == "." || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("download layout %q must stay inside the download location", template)
+	}
+	return path, nil
+}
+
+// layoutFileName recovers the {file} value from base, the last element of a path
+// rendered with template and fields, or returns base when the template does
+// not match it
+func layoutFileName(template string, fields layoutFields, base string) string {
+	elements := strings.Split(filepath.ToSlash(template), "/")
+	last := elements[len(elements)-1]
+	if strings.Count(last, "{file}") != 1 {
+		return base
+	}
+
+	// Render the text around {file} on its own
+	before, after, _ := strings.Cut(last, "{file}")
+	fields.File = "_"
+	prefix, suffix := "", ""
+	if before != "" {
+		rendered, err := renderLayout(before+"{file}", fields)
+		if err != nil {
+			return base
+		}
+		prefix = strings.TrimSuffix(rendered, "_")
+	}
+	if after != "" {
+		rendered, err := renderLayout("{file}"+after, fields)
+		if err != nil {
+			return base
+		}
+		suffix = strings.TrimPrefix(rendered, "_")
+	}
+
+	if len(base) <= len(prefix)+len(suffix) || !strings.HasPrefix(base, prefix) || !strings.HasSuffix(base, suffix) {
+		return base
+	}
+	return base[len(prefix) : len(base)-len(suffix)]
+}
+
+// resolveDownloadLayout accepts a preset name or a template and returns the template
+func resolveDownloadLayout(layout string) (string, error) {
+	layout = strings.TrimSpace(layout)
+	if layout == "" {
+		return defaultDownloadLayout, nil
+	}
+	for _, preset := range downloadLayoutPresets {
+		if layout == preset.Name {
+			return preset.Template, nil
+		}
+	}
+
+	// The file name must be part of the last path element
+	elements := strings.Split(filepath.ToSlash(layout), "/")
+	if !strings.Contains(elements[len(elements)-1], "{file}") {
+		return "", fmt.Errorf("download layout %q must end with a file name containing {file}", layout)
+	}
+	sample := layoutFields{Kind: KindTile, ProductSlug: "p-product", Version: "1.0.0", File: "file.pivotal", Profile: defaultProfileName}
+	if _, err := renderLayout(layout, sample); err != nil {
+		return "", err
+	}
+	return layout, nil
+}
+
+// downloadPath returns where a download is stored below root with the
+// configured layout
+func (b *BroadcomService) downloadPath(root string, fields layoutFields) (string, error) {
+	template := defaultDownloadLayout
+	if config, err := b.loadConfig(); err == nil && config.DownloadLayout != "" {
+		template = config.DownloadLayout
+	}
+	if fields.Profile == "" {
+		fields.Profile = b.activeProfileName()
+	}
+
+	rel, err := renderLayout(template, fields)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, rel), nil
+}
+
+// productFilePath returns where a product file is stored below root
+func (b *BroadcomService) productFilePath(root string, productSlug string, version string, file ProductFile) (string, error) {
+	name := productFileName(file)
+	return b.downloadPath(root, layoutFields{
+		Kind:        fileKind(productSlug, name),
+		ProductSlug: productSlug,
+		Version:     version,
+		File:        name,
+	})
+}
+
+// GetDownloadLayout returns the template that places downloads below the
+// download location
+func (b *BroadcomService) GetDownloadLayout() (string, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return "", err
+	}
+	if config.DownloadLayout == "" {
+		return defaultDownloadLayout, nil
+	}
+	return config.DownloadLayout, nil
+}
+
+// SetDownloadLayout sets how downloads are arranged below the download
+// location, either by preset name or as a template such as
+// {product_slug}/{version}/{file}. Files already on disk are not moved.
+func (b *BroadcomService) SetDownloadLayout(layout string) error {
+	template, err := resolveDownloadLayout(layout)
+	if err != nil {
+		return err
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+	config.DownloadLayout = template
+	if template == defaultDownloadLayout {
+		config.DownloadLayout = ""
+	}
+	return b.saveConfig(config)
+}
+
+// GetDownloadLayoutPresets returns the named layouts
+func (b *BroadcomService) GetDownloadLayoutPresets() []DownloadLayoutPreset {
+	return downloadLayoutPresets
+}
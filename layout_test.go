@@ -0,0 +1,174 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLayoutFileName(t *testing.T) {
+	fields := layoutFields{Kind: KindTile, ProductSlug: "p-rabbitmq", Version: "10.0.3"}
+
+	tests := []struct {
+		name     string
+		template string
+		base     string
+		want     string
+	}{
+		{
+			name:     "flat",
+			template: defaultDownloadLayout,
+			base:     "p-rabbitmq-10.0.3.pivotal",
+			want:     "p-rabbitmq-10.0.3.pivotal",
+		},
+		{
+			name:     "file in its own directory",
+			template: "{kind}/{product_slug}/{version}/{file}",
+			base:     "p-rabbitmq-10.0.3.pivotal",
+			want:     "p-rabbitmq-10.0.3.pivotal",
+		},
+		{
+			name:     "prefix",
+			template: "{kind}/[{product_slug},{version}]{file}",
+			base:     "[p-rabbitmq,10.0.3]p-rabbitmq-10.0.3.pivotal",
+			want:     "p-rabbitmq-10.0.3.pivotal",
+		},
+		{
+			name:     "suffix",
+			template: "{file}.{version}",
+			base:     "p-rabbitmq.pivotal.10.0.3",
+			want:     "p-rabbitmq.pivotal",
+		},
+		{
+			name:     "name from another layout",
+			template: "{kind}/[{product_slug},{version}]{file}",
+			base:     "p-rabbitmq-10.0.3.pivotal",
+			want:     "p-rabbitmq-10.0.3.pivotal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := layoutFileName(tt.template, fields, tt.base); got != tt.want {
+				t.Errorf("layoutFileName(%q, %q) = %q, want %q", tt.template, tt.base, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderLayout(t *testing.T) {
+	fields := layoutFields{Kind: KindTile, ProductSlug: "p-rabbitmq", Version: "10.0.3", File: "p-rabbitmq-10.0.3.pivotal", Profile: "lab"}
+
+	tests := []struct {
+		name     string
+		template string
+		fields   layoutFields
+		want     string
+		wantErr  bool
+	}{
+		{name: "profile directory", template: "{profile}/{file}", fields: fields, want: "lab/p-rabbitmq-10.0.3.pivotal"},
+		{name: "separators in values", template: "{product_slug}/{version}/{file}", fields: layoutFields{ProductSlug: "a/b", Version: `1\2:3`, File: "f"}, want: "a-b/1-2-3/f"},
+		{name: "dot values", template: "{product_slug}/{version}/{file}", fields: layoutFields{ProductSlug: "..", Version: ".", File: "f"}, want: "_/_/f"},
+		{name: "empty values", template: "{product_slug}/{file}", fields: layoutFields{File: "f"}, want: "_/f"},
+		{name: "value cannot climb out", template: "{file}", fields: layoutFields{File: "../../etc/passwd"}, want: "..-..-etc-passwd"},
+		{name: "unknown placeholder", template: "{owner}/{file}", fields: fields, wantErr: true},
+		{name: "absolute template", template: "/srv/{file}", fields: fields, wantErr: true},
+		{name: "parent directory", template: "../{file}", fields: fields, wantErr: true},
+		{name: "parent directory after cleaning", template: "{kind}/../../{file}", fields: fields, wantErr: true},
+		{name: "nothing but dots", template: "{kind}/..", fields: fields, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderLayout(tt.template, tt.fields)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("renderLayout(%q) = %q, want an error", tt.template, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderLayout(%q): %v", tt.template, err)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("renderLayout(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayoutPresets(t *testing.T) {
+	want := map[string]map[string]string{
+		"flat": {
+			KindTile:     "p-rabbitmq-10.0.3.pivotal",
+			KindStemcell: "bosh-stemcell-1.820-vsphere-esxi-ubuntu-jammy-go_agent.tgz",
+		},
+		"product": {
+			KindTile:     "p-rabbitmq/10.0.3/p-rabbitmq-10.0.3.pivotal",
+			KindStemcell: "stemcells-ubuntu-jammy/1.820/bosh-stemcell-1.820-vsphere-esxi-ubuntu-jammy-go_agent.tgz",
+		},
+		"kind": {
+			KindTile:     "tile/p-rabbitmq/10.0.3/p-rabbitmq-10.0.3.pivotal",
+			KindStemcell: "stemcell/stemcells-ubuntu-jammy/1.820/bosh-stemcell-1.820-vsphere-esxi-ubuntu-jammy-go_agent.tgz",
+		},
+		"platform-automation": {
+			KindTile:     "tile/[p-rabbitmq,10.0.3]p-rabbitmq-10.0.3.pivotal",
+			KindStemcell: "stemcell/[stemcells-ubuntu-jammy,1.820]bosh-stemcell-1.820-vsphere-esxi-ubuntu-jammy-go_agent.tgz",
+		},
+	}
+	files := map[string]layoutFields{
+		KindTile:     {ProductSlug: "p-rabbitmq", Version: "10.0.3", File: "p-rabbitmq-10.0.3.pivotal"},
+		KindStemcell: {ProductSlug: "stemcells-ubuntu-jammy", Version: "1.820", File: "bosh-stemcell-1.820-vsphere-esxi-ubuntu-jammy-go_agent.tgz"},
+	}
+
+	for _, preset := range downloadLayoutPresets {
+		t.Run(preset.Name, func(t *testing.T) {
+			template, err := resolveDownloadLayout(preset.Name)
+			if err != nil || template != preset.Template {
+				t.Fatalf("resolveDownloadLayout(%q) = %q, %v", preset.Name, template, err)
+			}
+			for kind, fields := range files {
+				fields.Kind = fileKind(fields.ProductSlug, fields.File)
+				if fields.Kind != kind {
+					t.Fatalf("fileKind(%s, %s) = %s, want %s", fields.ProductSlug, fields.File, fields.Kind, kind)
+				}
+				got, err := renderLayout(template, fields)
+				if err != nil {
+					t.Fatalf("renderLayout: %v", err)
+				}
+				if got != filepath.FromSlash(want[preset.Name][kind]) {
+					t.Errorf("%s renders as %q, want %q", kind, got, want[preset.Name][kind])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveDownloadLayout(t *testing.T) {
+	tests := []struct {
+		layout  string
+		want    string
+		wantErr bool
+	}{
+		{layout: "", want: defaultDownloadLayout},
+		{layout: " product ", want: "{product_slug}/{version}/{file}"},
+		{layout: "{profile}/{kind}/{file}", want: "{profile}/{kind}/{file}"},
+		{layout: "{file}/{version}", wantErr: true},
+		{layout: "{product_slug}/{version}", wantErr: true},
+		{layout: "../{file}", wantErr: true},
+		{layout: "/tmp/{file}", wantErr: true},
+		{layout: "{owner}/{file}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveDownloadLayout(tt.layout)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveDownloadLayout(%q) = %q, want an error", tt.layout, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveDownloadLayout(%q) = %q, %v; want %q", tt.layout, got, err, tt.want)
+		}
+	}
+}
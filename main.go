@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"embed"
+	"os"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -13,10 +14,18 @@ import (
 var assets embed.FS
 
 func main() {
+	// Subcommands run headless against the same services as the desktop app
+	if isCLIInvocation(os.Args[1:]) {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	// Create an instance of the app structure
 	app := NewApp()
 	broadcom := NewBroadcomService()
 	aiModel := NewAIModelService()
+	aiModel.activeProfile = broadcom.activeProfileName
+	aiModel.transport = broadcom.aiModelTransport
+	aiModel.layout = broadcom.downloadPath
 
 	// Create application with options
 	err := wails.Run(&options.App{
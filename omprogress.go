@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// omStderrTailLines is how many lines of om output are kept for error messages
+	omStderrTailLines = 40
+	// omStderrLineLimit truncates single lines, such as a dumped HTML page
+	omStderrLineLimit = 1024
+	// omSpeedSampleInterval is the minimum time between two speed samples
+	omSpeedSampleInterval = 500 * time.Millisecond
+	// omSpeedSmoothing weighs a new speed sample against the running average
+	omSpeedSmoothing = 0.3
+)
+
+var (
+	// omProgressPattern matches om progress bars such as
+	// " 211.14 MiB / 18.47 GiB [>------]   1.12% 02m56s". Units are optional,
+	// for counters in plain bytes.
+	omProgressPattern = regexp.MustCompile(`([\d.]+)\s*([KMGT]?i?B)?\s*/\s*([\d.]+)\s*([KMGT]?i?B)?\s*\[.*?\]\s*([\d.]+)%(.*)$`)
+	// omPercentPattern is the fallback for output that only carries a percentage
+	omPercentPattern = regexp.MustCompile(`([\d.]+)%`)
+	// omSpeedPattern matches a transfer rate such as "12.50 MiB/s"
+	omSpeedPattern = regexp.MustCompile(`([\d.]+)\s*([KMGT]?i?B)/s`)
+	// omETAPattern matches a remaining time such as "02m56s" or "ETA 1h02m03s"
+	omETAPattern = regexp.MustCompile(`(?:^|\s)(?:ETA\s+)?((?:\d+h)?(?:\d+m)?\d+s)(?:\s|$)`)
+)
+
+// omProgress is a progress update parsed from om output
+type omProgress struct {
+	Percent        float64
+	Downloaded     int64 // Zero when om printed only a percentage
+	Total          int64
+	BytesPerSecond float64
+	ETA            time.Duration
+}
+
+// omDownloadError is a failed om download with the last lines of its output,
+// which tell retryable failures from fatal ones
+type omDownloadError struct {
+	Command string
+	Err     error
+	Output  string
+}
+
+func (e *omDownloadError) Error() string {
+	if e.Output != "" {
+		return fmt.Sprintf("%s failed: %s\n%s", e.Command, e.Err, e.Output)
+	}
+	return fmt.Sprintf("%s failed: %s", e.Command, e.Err)
+}
+
+func (e *omDownloadError) Unwrap() error {
+	return e.Err
+}
+
+// omSizeBytes converts a size printed by om into bytes. KB and KiB are both
+// read as binary units, like om prints them.
+func omSizeBytes(value string, unit string) int64 {
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "i") {
+	case "K":
+		size *= 1 << 10
+	case "M":
+		size *= 1 << 20
+	case "G":
+		size *= 1 << 30
+	case "T":
+		size *= 1 << 40
+	}
+	return int64(size)
+}
+
+// parseOMProgressLine reads one line of om output. It reports false for lines
+// that are not progress updates.
+func parseOMProgressLine(line string) (omProgress, bool) {
+	if !strings.Contains(line, "%") {
+		return omProgress{}, false
+	}
+
+	matches := omProgressPattern.FindStringSubmatch(line)
+	if matches == nil {
+		percentMatch := omPercentPattern.FindStringSubmatch(line)
+		if percentMatch == nil {
+			return omProgress{}, false
+		}
+		percent, err := strconv.ParseFloat(percentMatch[1], 64)
+		if err != nil {
+			return omProgress{}, false
+		}
+		return omProgress{Percent: percent}, true
+	}
+
+	progress := omProgress{
+		Downloaded: omSizeBytes(matches[1], matches[2]),
+		Total:      omSizeBytes(matches[3], matches[4]),
+	}
+	progress.Percent, _ = strconv.ParseFloat(matches[5], 64)
+
+	rest := matches[6]
+	if speed := omSpeedPattern.FindStringSubmatch(rest); speed != nil {
+		progress.BytesPerSecond = float64(omSizeBytes(speed[1], speed[2]))
+	}
+	if eta := omETAPattern.FindStringSubmatch(rest); eta != nil {
+		progress.ETA, _ = time.ParseDuration(eta[1])
+	}
+	return progress, true
+}
+
+// omOutputParser turns om stderr into progress updates and keeps the last
+// lines of other output for error messages. It is an io.Writer, so it can be
+// set as the Stderr of an om command; exec then finishes all writes before
+// Wait returns.
+type omOutputParser struct {
+	onProgress func(omProgress)
+	now        func() time.Time
+
+	mu            sync.Mutex
+	pending       []byte   // Output after the last line break
+	pendingParsed bool     // The pending output was already reported as progress
+	tail          []string // Last lines that were not progress updates
+	sampleBytes   int64
+	sampleTime    time.Time
+	speed         float64 // Smoothed bytes per second
+}
+
+// newOMOutputParser creates a parser that calls onProgress for every progress update
+func newOMOutputParser(onProgress func(omProgress)) *omOutputParser {
+	return &omOutputParser{onProgress: onProgress, now: time.Now}
+}
+
+// Write consumes om output. Progress bars are redrawn with carriage returns,
+// so both \r and \n end a line.
+func (p *omOutputParser) Write(data []byte) (int, error) {
+	var updates []omProgress
+
+	p.mu.Lock()
+	p.pending = append(p.pending, data...)
+	for {
+		end := -1
+		for i, c := range p.pending {
+			if c == '\n' || c == '\r' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			break
+		}
+		line := string(p.pending[:end])
+		parsed := p.pendingParsed
+		p.pending = p.pending[end+1:]
+		p.pendingParsed = false
+
+		if update, ok := p.handleLine(line, parsed); ok {
+			updates = append(updates, update)
+		}
+	}
+
+	// A redrawn progress bar is only terminated by the next redraw; report
+	// it now instead of one refresh later
+	if len(p.pending) > 0 && !p.pendingParsed {
+		if update, ok := parseOMProgressLine(string(p.pending)); ok {
+			p.pendingParsed = true
+			updates = append(updates, p.withRate(update))
+		}
+	}
+	p.mu.Unlock()
+
+	if p.onProgress != nil {
+		for _, update := range updates {
+			p.onProgress(update)
+		}
+	}
+	return len(data), nil
+}
+
+// handleLine records a complete line. It returns a progress update unless the
+// line was already reported while it was pending.
+func (p *omOutputParser) handleLine(line string, alreadyParsed bool) (omProgress, bool) {
+	if strings.TrimSpace(line) == "" {
+		return omProgress{}, false
+	}
+	update, ok := parseOMProgressLine(line)
+	if ok {
+		if alreadyParsed {
+			return omProgress{}, false
+		}
+		return p.withRate(update), true
+	}
+
+	if len(line) > omStderrLineLimit {
+		line = line[:omStderrLineLimit] + "..."
+	}
+	p.tail = append(p.tail, line)
+	if len(p.tail) > omStderrTailLines {
+		p.tail = p.tail[len(p.tail)-omStderrTailLines:]
+	}
+	return omProgress{}, false
+}
+
+// withRate fills in speed and ETA. Values printed by om are used as they are;
+// otherwise the speed is averaged from the byte counts between updates.
+func (p *omOutputParser) withRate(update omProgress) omProgress {
+	now := p.now()
+	if update.Downloaded > 0 {
+		switch {
+		case p.sampleTime.IsZero() || update.Downloaded < p.sampleBytes:
+			p.sampleTime, p.sampleBytes = now, update.Downloaded
+		case now.Sub(p.sampleTime) >= omSpeedSampleInterval:
+			sample := float64(update.Downloaded-p.sampleBytes) / now.Sub(p.sampleTime).Seconds()
+			if p.speed == 0 {
+				p.speed = sample
+			} else {
+				p.speed = omSpeedSmoothing*sample + (1-omSpeedSmoothing)*p.speed
+			}
+			p.sampleTime, p.sampleBytes = now, update.Downloaded
+		}
+	}
+
+	if update.BytesPerSecond > 0 {
+		p.speed = update.BytesPerSecond
+	} else {
+		update.BytesPerSecond = p.speed
+	}
+	if update.BytesPerSecond > 0 && update.Total > update.Downloaded && update.Downloaded > 0 {
+		remaining := float64(update.Total-update.Downloaded) / update.BytesPerSecond
+		update.ETA = time.Duration(remaining * float64(time.Second)).Round(time.Second)
+	}
+	return update
+}
+
+// Tail returns the last lines of om output that were not progress updates
+func (p *omOutputParser) Tail() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	lines := append([]string(nil), p.tail...)
+	if pending := strings.TrimSpace(string(p.pending)); pending != "" {
+		if _, ok := parseOMProgressLine(pending); !ok {
+			lines = append(lines, pending)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseOMProgressLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want omProgress
+		ok   bool
+	}{
+		{
+			name: "om progress bar",
+			line: " 211.14 MiB / 18.47 GiB [>------------------]   1.12% 02m56s",
+			want: omProgress{Percent: 1.12, Downloaded: 221396336, Total: 19832011489, ETA: 2*time.Minute + 56*time.Second},
+			ok:   true,
+		},
+		{
+			name: "speed and ETA prefix",
+			line: "3.43 MiB / 100.00 MiB [==>_____________] 3.43% 1.50 MiB/s ETA 1m04s",
+			want: omProgress{Percent: 3.43, Downloaded: 3596615, Total: 104857600, BytesPerSecond: 1572864, ETA: time.Minute + 4*time.Second},
+			ok:   true,
+		},
+		{
+			name: "decimal units and hours",
+			line: "1.5 GB / 20 GB [=>---] 7.50% 1h02m03s",
+			want: omProgress{Percent: 7.5, Downloaded: 1610612736, Total: 21474836480, ETA: time.Hour + 2*time.Minute + 3*time.Second},
+			ok:   true,
+		},
+		{
+			name: "plain byte counter",
+			line: "512 / 2048 [=====>-----] 25.00%",
+			want: omProgress{Percent: 25, Downloaded: 512, Total: 2048},
+			ok:   true,
+		},
+		{
+			name: "percentage only",
+			line: "downloading... 42.5%",
+			want: omProgress{Percent: 42.5},
+			ok:   true,
+		},
+		{
+			name: "log line",
+			line: "attempting to download the file from Pivotal Network",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseOMProgressLine(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeClock returns the times in order, repeating the last one
+type fakeClock struct {
+	times []time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	current := c.times[0]
+	if len(c.times) > 1 {
+		c.times = c.times[1:]
+	}
+	return current
+}
+
+func TestOMOutputParserCarriageReturnsAndSplitWrites(t *testing.T) {
+	var updates []omProgress
+	parser := newOMOutputParser(func(p omProgress) { updates = append(updates, p) })
+
+	// A redraw split across writes, then a second redraw
+	writes := []string{
+		"\r 10.00 MiB / 100.00 MiB [==",
+		">----]  10.00% 01m00s",
+		"\r 20.00 MiB / 100.00 MiB [====>----]  20.00% 00m50s",
+		"\n",
+	}
+	for _, w := range writes {
+		if _, err := parser.Write([]byte(w)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, want 2: %+v", len(updates), updates)
+	}
+	if updates[0].Percent != 10 || updates[1].Percent != 20 {
+		t.Errorf("percentages = %v, %v", updates[0].Percent, updates[1].Percent)
+	}
+	if updates[1].Downloaded != 20<<20 || updates[1].Total != 100<<20 {
+		t.Errorf("bytes = %d / %d", updates[1].Downloaded, updates[1].Total)
+	}
+	if tail := parser.Tail(); tail != "" {
+		t.Errorf("progress bars ended up in the tail: %q", tail)
+	}
+}
+
+func TestOMOutputParserComputesSpeedAndETA(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{times: []time.Time{start, start.Add(2 * time.Second)}}
+
+	var updates []omProgress
+	parser := newOMOutputParser(func(p omProgress) { updates = append(updates, p) })
+	parser.now = clock.now
+
+	parser.Write([]byte("10 MiB / 100 MiB [=>---] 10.00%\n"))
+	parser.Write([]byte("30 MiB / 100 MiB [===>-] 30.00%\n"))
+
+	if len(updates) != 2 {
+		t.Fatalf("got %d updates, want 2", len(updates))
+	}
+	if updates[0].BytesPerSecond != 0 {
+		t.Errorf("first update has speed %v without a previous sample", updates[0].BytesPerSecond)
+	}
+	wantSpeed := float64(10 << 20) // 20 MiB in 2 seconds
+	if updates[1].BytesPerSecond != wantSpeed {
+		t.Errorf("speed = %v, want %v", updates[1].BytesPerSecond, wantSpeed)
+	}
+	if updates[1].ETA != 7*time.Second {
+		t.Errorf("ETA = %v, want 7s", updates[1].ETA)
+	}
+}
+
+func TestOMOutputParserTailIsBounded(t *testing.T) {
+	parser := newOMOutputParser(nil)
+	for i := 0; i < omStderrTailLines+10; i++ {
+		fmt.Fprintf(parser, "line %d\n", i)
+	}
+	parser.Write([]byte(strings.Repeat("x", omStderrLineLimit*2) + "\n"))
+	parser.Write([]byte("could not download: 403 Forbidden"))
+
+	lines := strings.Split(parser.Tail(), "\n")
+	if len(lines) != omStderrTailLines+1 {
+		t.Fatalf("tail has %d lines, want %d", len(lines), omStderrTailLines+1)
+	}
+	if lines[0] != "line 11" {
+		t.Errorf("oldest line = %q, want line 11", lines[0])
+	}
+	if got := len(lines[len(lines)-2]); got != omStderrLineLimit+3 {
+		t.Errorf("long line has %d bytes, want it truncated to %d", got, omStderrLineLimit+3)
+	}
+	if last := lines[len(lines)-1]; last != "could not download: 403 Forbidden" {
+		t.Errorf("unterminated last line = %q", last)
+	}
+}
+
+func TestOMOutputParserConcurrentTail(t *testing.T) {
+	parser := newOMOutputParser(func(omProgress) {})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(parser, "\r %d MiB / 1000 MiB [=>--] %d.00%%", i, i/10)
+			fmt.Fprintf(parser, "\nwarning %d\n", i)
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		parser.Tail()
+	}
+	wg.Wait()
+
+	if !strings.HasSuffix(parser.Tail(), "warning 999") {
+		t.Errorf("tail does not end with the last warning: %q", parser.Tail())
+	}
+}
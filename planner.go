@@ -0,0 +1,506 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RuntimeTypeFull selects the full Elastic Runtime tile
+	RuntimeTypeFull = "full"
+	// RuntimeTypeSmallFootprint selects the Small Footprint Runtime tile
+	RuntimeTypeSmallFootprint = "srt"
+
+	// plannerRuntimeScanLimit is how many recent Elastic Runtime releases are checked
+	// against the selected Ops Manager
+	plannerRuntimeScanLimit = 50
+	// plannerTileScanLimit is how many recent releases of each tile are checked
+	// against the selected Elastic Runtime
+	plannerTileScanLimit = 20
+)
+
+// plannerTarget is a tile the planner recommends alongside Elastic Runtime
+type plannerTarget struct {
+	Slug     string
+	Name     string
+	Priority float64
+}
+
+// plannerTargets lists the tiles recommended for a foundation, in display order
+var plannerTargets = []plannerTarget{
+	{"vmware-postgres-for-tas", "Postgres", 1},
+	{"genai-for-tas", "AI Services (GenAI)", 2},
+	{"p-rabbitmq", "RabbitMQ", 3},
+	{"pivotal-mysql", "MySQL", 4},
+	{"p-redis", "Valkey", 5},
+	{"tanzu-gemfire-for-vms", "Gemfire", 6},
+	{"apm", "App Metrics", 7},
+	{"p-metric-store", "Metric Store", 8},
+	{"pas-windows", "Windows Add On", 9},
+	{"pivotal_single_sign-on_service", "Single Sign-On", 10},
+	{"p-spring-cloud-services", "Spring Cloud Services", 11},
+	{"spring-cloud-gateway", "Spring Cloud Gateway", 12},
+	{"dataflow", "Tanzu Data Flow", 13},
+	{"stemcells-ubuntu-jammy", "Stemcells (Ubuntu Jammy)", 14},
+	{"credhub-service-broker", "Credhub", 15},
+	{"p-scheduler", "Scheduler", 16},
+}
+
+// PlannedProduct is a product release selected by the download planner
+type PlannedProduct struct {
+	ProductName string        `json:"product_name"`
+	ProductSlug string        `json:"product_slug"`
+	Version     string        `json:"version"`
+	ReleaseID   int           `json:"release_id"`
+	Files       []ProductFile `json:"files"`
+	Priority    float64       `json:"priority"`
+}
+
+// DownloadPlan is the resolved set of compatible releases for a foundation
+type DownloadPlan struct {
+	OpsManagerVersion     string           `json:"ops_manager_version"`
+	ElasticRuntimeVersion string           `json:"elastic_runtime_version"`
+	RuntimeType           string           `json:"runtime_type"`
+	Products              []PlannedProduct `json:"products"`
+	Warnings              []string         `json:"warnings"`
+}
+
+// cleanVersion strips pre-release and build metadata such as "+LTS-T"
+func cleanVersion(version string) string {
+	version = strings.TrimSpace(version)
+	version = strings.SplitN(version, "+", 2)[0]
+	return strings.SplitN(version, "-", 2)[0]
+}
+
+// versionParts parses the numeric components of a version; non-numeric parts count as zero
+func versionParts(version string) []int {
+	fields := strings.Split(cleanVersion(version), ".")
+	parts := make([]int, len(fields))
+	for i, field := range fields {
+		parts[i], _ = strconv.Atoi(field)
+	}
+	return parts
+}
+
+// compareVersions compares two versions numerically.
+// It returns 1 if v1 > v2, -1 if v1 < v2 and 0 if they are equal.
+func compareVersions(v1, v2 string) int {
+	parts1 := versionParts(v1)
+	parts2 := versionParts(v2)
+
+	for i := 0; i < len(parts1) || i < len(parts2); i++ {
+		var p1, p2 int
+		if i < len(parts1) {
+			p1 = parts1[i]
+		}
+		if i < len(parts2) {
+			p2 = parts2[i]
+		}
+
+		if p1 > p2 {
+			return 1
+		}
+		if p1 < p2 {
+			return -1
+		}
+	}
+
+	return 0
+}
+
+// versionMatchesSpecifier reports whether a version satisfies a single dependency
+// specifier. Supported forms are ranges ("2.11.16 - 2.11.58"), pessimistic
+// constraints ("~> 3" and "~> 3.0"), comparisons (">=", ">", "<=", "<", "="),
+// wildcards ("6.0.*") and exact versions.
+func versionMatchesSpecifier(version, specifier string) bool {
+	specifier = strings.TrimSpace(specifier)
+	version = cleanVersion(version)
+
+	if strings.Contains(specifier, " - ") {
+		bounds := strings.SplitN(specifier, " - ", 2)
+		return compareVersions(version, bounds[0]) >= 0 && compareVersions(version, bounds[1]) <= 0
+	}
+
+	switch {
+	case strings.HasPrefix(specifier, "~>"):
+		base := versionParts(strings.TrimSpace(strings.TrimPrefix(specifier, "~>")))
+		parts := versionParts(version)
+		for len(parts) < 2 {
+			parts = append(parts, 0)
+		}
+		if len(base) == 1 {
+			// ~> 3 means >= 3.0 and < 4.0
+			return parts[0] == base[0]
+		}
+		// ~> 3.0 means >= 3.0 and < 3.1
+		return parts[0] == base[0] && parts[1] == base[1]
+	case strings.HasPrefix(specifier, ">="):
+		return compareVersions(version, strings.TrimPrefix(specifier, ">=")) >= 0
+	case strings.HasPrefix(specifier, "<="):
+		return compareVersions(version, strings.TrimPrefix(specifier, "<=")) <= 0
+	case strings.HasPrefix(specifier, ">"):
+		return compareVersions(version, strings.TrimPrefix(specifier, ">")) > 0
+	case strings.HasPrefix(specifier, "<"):
+		return compareVersions(version, strings.TrimPrefix(specifier, "<")) < 0
+	case strings.HasPrefix(specifier, "="):
+		return compareVersions(version, strings.TrimPrefix(specifier, "=")) == 0
+	case strings.Contains(specifier, "*"):
+		pattern := strings.ReplaceAll(regexp.QuoteMeta(specifier), `\*`, `\d+`)
+		matched, _ := regexp.MatchString("^"+pattern+"$", version)
+		return matched
+	default:
+		return version == cleanVersion(specifier)
+	}
+}
+
+// versionMatchesAnySpecifier reports whether a version satisfies any of the
+// comma-separated specifiers
+func versionMatchesAnySpecifier(version, specifiers string) bool {
+	for _, specifier := range strings.Split(specifiers, ",") {
+		if strings.TrimSpace(specifier) != "" && versionMatchesSpecifier(version, specifier) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortReleasesDescending orders releases from newest to oldest version
+func sortReleasesDescending(releases []Release) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		return compareVersions(releases[i].Version, releases[j].Version) > 0
+	})
+}
+
+// dependenciesMatch reports whether any specifier for the products selected by
+// isProduct is satisfied by version
+func dependenciesMatch(specifiers []DependencySpecifier, isProduct func(DependencySpecifier) bool, version string) bool {
+	for _, dep := range specifiers {
+		if isProduct(dep) && versionMatchesAnySpecifier(version, dep.Specifier) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpsManagerDependency selects Ops Manager dependency specifiers
+func isOpsManagerDependency(dep DependencySpecifier) bool {
+	return dep.Product.Slug == "ops-manager" ||
+		strings.Contains(strings.ToLower(dep.Product.Name), "ops manager")
+}
+
+// isElasticRuntimeDependency selects Elastic Runtime dependency specifiers
+func isElasticRuntimeDependency(dep DependencySpecifier) bool {
+	name := strings.ToLower(dep.Product.Name)
+	return dep.Product.Slug == "elastic-runtime" ||
+		dep.Product.Slug == "cf" ||
+		strings.Contains(name, "elastic runtime") ||
+		strings.Contains(name, "tanzu application service")
+}
+
+// findReleaseByVersion returns the release of a product with the given version
+func (b *BroadcomService) findReleaseByVersion(productSlug string, version string) (*Release, error) {
+	releases, err := b.GetProductReleases(productSlug)
+	if err != nil {
+		return nil, err
+	}
+	for i := range releases {
+		if releases[i].Version == version {
+			return &releases[i], nil
+		}
+	}
+	return nil, fmt.Errorf("version %s of %s not found", version, productSlug)
+}
+
+// plannerProgress reports what the planner is currently checking
+func (b *BroadcomService) plannerProgress(message string) {
+	b.emit("planner-progress", map[string]interface{}{
+		"message": message,
+	})
+}
+
+// GetCompatibleElasticRuntimeReleases returns the recent Elastic Runtime releases
+// whose Ops Manager dependency is satisfied by the given Ops Manager version
+func (b *BroadcomService) GetCompatibleElasticRuntimeReleases(opsManagerVersion string) ([]Release, error) {
+	releases, err := b.GetProductReleases("elastic-runtime")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Elastic Runtime releases: %w", err)
+	}
+	sortReleasesDescending(releases)
+	if len(releases) > plannerRuntimeScanLimit {
+		releases = releases[:plannerRuntimeScanLimit]
+	}
+
+	compatible := []Release{}
+	var lastErr error
+	skipped := 0
+	for _, release := range releases {
+		b.plannerProgress(fmt.Sprintf("Checking Elastic Runtime %s...", release.Version))
+
+		specifiers, err := b.GetReleaseDependencySpecifiers("elastic-runtime", release.ID)
+		if err != nil {
+			if isFatalPlannerError(err) {
+				return nil, fmt.Errorf("failed to load dependencies of Elastic Runtime %s: %w", release.Version, err)
+			}
+			fmt.Printf("Skipping Elastic Runtime %s: could not load its dependencies: %v\n", release.Version, err)
+			lastErr = err
+			skipped++
+			continue
+		}
+		if dependenciesMatch(specifiers, isOpsManagerDependency, opsManagerVersion) {
+			compatible = append(compatible, release)
+		}
+	}
+
+	if len(compatible) == 0 && skipped > 0 {
+		return nil, fmt.Errorf("could not load the dependencies of %d Elastic Runtime releases: %w", skipped, lastErr)
+	}
+	return compatible, nil
+}
+
+// isFatalPlannerError reports API errors that every later request would hit
+// too, so the planner stops instead of skipping the release
+func isFatalPlannerError(err error) bool {
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrRateLimited)
+}
+
+// PlanDownloads resolves the Ops Manager and Elastic Runtime releases with the
+// given versions and the latest compatible release of every recommended tile.
+// runtimeType is "full" or "srt".
+func (b *BroadcomService) PlanDownloads(opsManagerVersion string, elasticRuntimeVersion string, runtimeType string) (*DownloadPlan, error) {
+	if runtimeType == "" {
+		runtimeType = RuntimeTypeFull
+	}
+	if runtimeType != RuntimeTypeFull && runtimeType != RuntimeTypeSmallFootprint {
+		return nil, fmt.Errorf("unknown runtime type %q", runtimeType)
+	}
+
+	plan := &DownloadPlan{
+		OpsManagerVersion:     opsManagerVersion,
+		ElasticRuntimeVersion: elasticRuntimeVersion,
+		RuntimeType:           runtimeType,
+		Products:              []PlannedProduct{},
+		Warnings:              []string{},
+	}
+	iaas := b.defaultIaaS()
+
+	// Ops Manager and Elastic Runtime come first
+	b.plannerProgress("Adding Ops Manager...")
+	opsManager, err := b.findReleaseByVersion("ops-manager", opsManagerVersion)
+	if err != nil {
+		return nil, err
+	}
+	opsManagerFiles, err := b.GetReleaseFiles("ops-manager", opsManager.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Ops Manager files: %w", err)
+	}
+	plan.Products = append(plan.Products, PlannedProduct{
+		ProductName: "Ops Manager",
+		ProductSlug: "ops-manager",
+		Version:     opsManager.Version,
+		ReleaseID:   opsManager.ID,
+		Files:       selectMainFiles("ops-manager", opsManagerFiles, iaas),
+		Priority:    0,
+	})
+
+	b.plannerProgress("Adding Tanzu Application Service...")
+	runtime, err := b.findReleaseByVersion("elastic-runtime", elasticRuntimeVersion)
+	if err != nil {
+		return nil, err
+	}
+	runtimeFiles, err := b.GetReleaseFiles("elastic-runtime", runtime.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Elastic Runtime files: %w", err)
+	}
+	runtimeName := "Tanzu Application Service (Full)"
+	if runtimeType == RuntimeTypeSmallFootprint {
+		runtimeName = "Tanzu Application Service (Small Footprint)"
+	}
+	plan.Products = append(plan.Products, PlannedProduct{
+		ProductName: runtimeName,
+		ProductSlug: "elastic-runtime",
+		Version:     runtime.Version,
+		ReleaseID:   runtime.ID,
+		Files:       selectElasticRuntimeFiles(runtimeFiles, runtimeType),
+		Priority:    0.5,
+	})
+
+	// Then the newest release of each tile that supports this Elastic Runtime
+	for _, target := range plannerTargets {
+		b.plannerProgress(fmt.Sprintf("Checking %s...", target.Name))
+
+		release, skipped, err := b.findCompatibleRelease(target.Slug, runtime.Version)
+		for _, version := range skipped {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("Skipped %s %s: could not load its dependencies", target.Name, version))
+		}
+		if isFatalPlannerError(err) {
+			return nil, fmt.Errorf("failed to check %s: %w", target.Name, err)
+		}
+		if err != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("Could not load %s: %v", target.Name, err))
+			continue
+		}
+		if release == nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("No compatible version of %s found for Elastic Runtime %s", target.Name, runtime.Version))
+			continue
+		}
+
+		files, err := b.GetReleaseFiles(target.Slug, release.ID)
+		if err != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("Could not load files of %s %s: %v", target.Name, release.Version, err))
+			continue
+		}
+
+		plan.Products = append(plan.Products, PlannedProduct{
+			ProductName: target.Name,
+			ProductSlug: target.Slug,
+			Version:     release.Version,
+			ReleaseID:   release.ID,
+			Files:       selectMainFiles(target.Slug, files, iaas),
+			Priority:    target.Priority,
+		})
+	}
+
+	sort.SliceStable(plan.Products, func(i, j int) bool {
+		return plan.Products[i].Priority < plan.Products[j].Priority
+	})
+
+	// Files already on disk are marked, so bulk downloads can skip them
+	releases := make([]localRelease, 0, len(plan.Products))
+	for _, product := range plan.Products {
+		releases = append(releases, localRelease{productSlug: product.ProductSlug, version: product.Version, files: product.Files})
+	}
+	if err := b.annotateLocalStatus(releases...); err != nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("Could not check the download location: %v", err))
+	}
+
+	return plan, nil
+}
+
+// findCompatibleRelease returns the newest of the recent releases of a tile whose
+// Elastic Runtime dependency accepts runtimeVersion, or nil if there is none,
+// with the versions of newer releases skipped because their dependencies
+// could not be loaded. Stemcells carry no such dependency, so their latest
+// release is used.
+func (b *BroadcomService) findCompatibleRelease(productSlug string, runtimeVersion string) (*Release, []string, error) {
+	releases, err := b.GetProductReleases(productSlug)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(releases) == 0 {
+		return nil, nil, nil
+	}
+	sortReleasesDescending(releases)
+
+	if strings.Contains(strings.ToLower(productSlug), "stemcell") {
+		return &releases[0], nil, nil
+	}
+
+	if len(releases) > plannerTileScanLimit {
+		releases = releases[:plannerTileScanLimit]
+	}
+	var skipped []string
+	for i := range releases {
+		specifiers, err := b.GetReleaseDependencySpecifiers(productSlug, releases[i].ID)
+		if err != nil {
+			if isFatalPlannerError(err) {
+				return nil, skipped, err
+			}
+			fmt.Printf("Skipping %s %s: could not load its dependencies: %v\n", productSlug, releases[i].Version, err)
+			skipped = append(skipped, releases[i].Version)
+			continue
+		}
+		if dependenciesMatch(specifiers, isElasticRuntimeDependency, runtimeVersion) {
+			return &releases[i], skipped, nil
+		}
+	}
+
+	return nil, skipped, nil
+}
+
+// selectMainFiles picks the files worth downloading for a product: images for
+// the given IaaS for stemcells and Ops Manager, .pivotal tiles for everything
+// else. When nothing matches, all files are returned.
+func selectMainFiles(productSlug string, files []ProductFile, iaas string) []ProductFile {
+	slug := strings.ToLower(productSlug)
+	isStemcell := strings.Contains(slug, "stemcell")
+	isOpsManager := strings.Contains(slug, "ops-manager")
+	keywords := iaasKeywords(iaas)
+	if isStemcell && iaas == "vsphere" {
+		// Stemcell names never mention VMware on its own
+		keywords = []string{"vsphere"}
+	}
+
+	var mainFiles []ProductFile
+	for _, file := range files {
+		name := strings.ToLower(file.Name)
+		key := strings.ToLower(file.AWSObjectKey)
+		fileType := strings.ToLower(file.FileType)
+
+		switch {
+		case isStemcell || isOpsManager:
+			for _, keyword := range keywords {
+				if strings.Contains(name, keyword) {
+					mainFiles = append(mainFiles, file)
+					break
+				}
+			}
+		default:
+			if strings.HasSuffix(name, ".pivotal") || strings.HasSuffix(key, ".pivotal") || strings.Contains(fileType, "pivotal") {
+				mainFiles = append(mainFiles, file)
+			}
+		}
+	}
+
+	if len(mainFiles) == 0 {
+		return files
+	}
+	return mainFiles
+}
+
+// selectElasticRuntimeFiles picks the runtime tile of the requested type plus the CF CLI
+func selectElasticRuntimeFiles(files []ProductFile, runtimeType string) []ProductFile {
+	selected := []ProductFile{}
+
+	for _, file := range files {
+		name := strings.ToLower(file.Name)
+		key := strings.ToLower(file.AWSObjectKey)
+		if !strings.HasSuffix(key, ".pivotal") {
+			continue
+		}
+
+		isSmallFootprint := strings.Contains(name, "small") &&
+			(strings.Contains(name, "footprint") || strings.Contains(name, "tpcf"))
+
+		if runtimeType == RuntimeTypeSmallFootprint {
+			if isSmallFootprint {
+				selected = append(selected, file)
+			}
+			continue
+		}
+
+		// Full runtime: the main tile, without Small Footprint, OSL, ODP or plugins
+		isMainTile := strings.Contains(name, "tanzu platform for cloud foundry") ||
+			strings.Contains(name, "tanzu application service")
+		if isMainTile && !strings.Contains(name, "small") && !strings.Contains(name, "footprint") &&
+			!strings.Contains(name, "osl") && !strings.Contains(name, "odp") && !strings.Contains(name, "plugin") {
+			selected = append(selected, file)
+		}
+	}
+
+	// The CF CLI is included for both runtime types
+	for _, file := range files {
+		name := strings.ToLower(file.Name)
+		key := strings.ToLower(file.AWSObjectKey)
+		isCFCLI := strings.Contains(name, "cf") && strings.Contains(name, "cli")
+		hasCLIKey := strings.Contains(key, "cf-cli") || strings.Contains(key, "cf_cli")
+		if isCFCLI && hasCLIKey && !strings.Contains(name, "osl") && !strings.Contains(name, "plugin") {
+			selected = append(selected, file)
+		}
+	}
+
+	return selected
+}
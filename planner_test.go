@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		name string
+		v1   string
+		v2   string
+		want int
+	}{
+		{name: "equal", v1: "3.0.25", v2: "3.0.25", want: 0},
+		{name: "patch greater", v1: "3.0.25", v2: "3.0.9", want: 1},
+		{name: "minor less", v1: "6.0.5", v2: "6.10.0", want: -1},
+		{name: "major greater", v1: "10.0.3", v2: "2.4.9", want: 1},
+		{name: "shorter version padded with zeros", v1: "1.820", v2: "1.820.0", want: 0},
+		{name: "shorter version less", v1: "3.1", v2: "3.1.2", want: -1},
+		{name: "longer version greater", v1: "3.1.0.1", v2: "3.1", want: 1},
+		{name: "pre-release ignored", v1: "10.0.3-rc.1", v2: "10.0.3", want: 0},
+		{name: "build metadata ignored", v1: "4.0.40+LTS-T", v2: "4.0.40", want: 0},
+		{name: "build metadata does not outrank newer version", v1: "4.0.40+LTS-T", v2: "4.0.41", want: -1},
+		{name: "surrounding whitespace", v1: " 6.0.5 ", v2: "6.0.5", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareVersions(tt.v1, tt.v2); got != tt.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.v1, tt.v2, got, tt.want)
+			}
+			if got := compareVersions(tt.v2, tt.v1); got != -tt.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.v2, tt.v1, got, -tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionMatchesSpecifier(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   string
+		specifier string
+		want      bool
+	}{
+		{name: "pessimistic minor lower bound", version: "3.0.0", specifier: "~> 3.0", want: true},
+		{name: "pessimistic minor patch", version: "3.0.25", specifier: "~> 3.0", want: true},
+		{name: "pessimistic minor excludes next minor", version: "3.1.0", specifier: "~> 3.0", want: false},
+		{name: "pessimistic minor excludes other major", version: "4.0.0", specifier: "~> 3.0", want: false},
+		{name: "pessimistic major", version: "3.9.1", specifier: "~> 3", want: true},
+		{name: "pessimistic major excludes next major", version: "4.0.0", specifier: "~> 3", want: false},
+		{name: "pessimistic with one-part version", version: "6", specifier: "~> 6.0", want: true},
+		{name: "pessimistic with pre-release", version: "6.0.0-rc.2", specifier: "~> 6.0", want: true},
+		{name: "at least equal", version: "4.0.0", specifier: ">= 4.0", want: true},
+		{name: "at least newer", version: "6.0.5", specifier: ">= 4.0", want: true},
+		{name: "at least older", version: "2.13.9", specifier: ">= 4.0", want: false},
+		{name: "at least without space", version: "4.0.1", specifier: ">=4.0.1", want: true},
+		{name: "greater than", version: "4.0.0", specifier: "> 4.0", want: false},
+		{name: "at most", version: "4.0.0", specifier: "<= 4.0", want: true},
+		{name: "less than", version: "3.9.9", specifier: "< 4", want: true},
+		{name: "equals", version: "3.1.2", specifier: "= 3.1.2", want: true},
+		{name: "wildcard patch", version: "1.2.7", specifier: "1.2.*", want: true},
+		{name: "wildcard excludes other minor", version: "1.3.0", specifier: "1.2.*", want: false},
+		{name: "wildcard needs a patch", version: "1.2", specifier: "1.2.*", want: false},
+		{name: "wildcard with build metadata", version: "1.2.40+LTS-T", specifier: "1.2.*", want: true},
+		{name: "range inclusive lower", version: "2.11.16", specifier: "2.11.16 - 2.11.58", want: true},
+		{name: "range inclusive upper", version: "2.11.58", specifier: "2.11.16 - 2.11.58", want: true},
+		{name: "range above", version: "2.11.59", specifier: "2.11.16 - 2.11.58", want: false},
+		{name: "exact", version: "1.820", specifier: "1.820", want: true},
+		{name: "exact with pre-release", version: "1.820-build.1", specifier: "1.820", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := versionMatchesSpecifier(tt.version, tt.specifier); got != tt.want {
+				t.Errorf("versionMatchesSpecifier(%q, %q) = %v, want %v", tt.version, tt.specifier, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionMatchesAnySpecifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		specifiers string
+		want       bool
+	}{
+		{name: "first matches", version: "3.0.25", specifiers: "~> 3.0, ~> 3.1", want: true},
+		{name: "second matches", version: "3.1.2", specifiers: "~> 3.0, ~> 3.1", want: true},
+		{name: "none match", version: "3.2.0", specifiers: "~> 3.0, ~> 3.1", want: false},
+		{name: "empty", version: "3.0.0", specifiers: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := versionMatchesAnySpecifier(tt.version, tt.specifiers); got != tt.want {
+				t.Errorf("versionMatchesAnySpecifier(%q, %q) = %v, want %v", tt.version, tt.specifiers, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultProfileName is the profile older single-account configs are migrated into
+const defaultProfileName = "default"
+
+// profileEnv selects a profile for one headless run without switching the saved one
+const profileEnv = "TILE_DOWNLOADER_PROFILE"
+
+// profileNamePattern restricts profile names to something safe in paths and shells
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// supportedIaaS lists the values accepted as a profile's default IaaS
+var supportedIaaS = []string{"vsphere", "aws", "azure", "gcp", "openstack"}
+
+// Profile holds the settings of one account or customer foundation
+type Profile struct {
+	EncryptedAPIToken string `json:"encrypted_api_token,omitempty"`
+	DownloadLocation  string `json:"download_location,omitempty"`
+	HTTPProxy         string `json:"http_proxy,omitempty"`
+	HTTPSProxy        string `json:"https_proxy,omitempty"`
+	NoProxy           string `json:"no_proxy,omitempty"` // Comma-separated hosts, domains and CIDRs reached directly
+	ProxyUsername     string `json:"proxy_username,omitempty"`
+
+	EncryptedProxyPassword string `json:"encrypted_proxy_password,omitempty"`
+	ProxyCAFile            string `json:"proxy_ca_file,omitempty"` // PEM bundle of a TLS-intercepting proxy
+	DefaultIaaS            string `json:"default_iaas,omitempty"`  // Picks stemcell and Ops Manager images
+	BaseURL                string `json:"base_url,omitempty"`      // API endpoint, empty for the Broadcom Support Portal
+}
+
+// ProfileInfo describes a profile for listing, without its token
+type ProfileInfo struct {
+	Name             string `json:"name"`
+	Active           bool   `json:"active"`
+	HasToken         bool   `json:"has_token"`
+	DownloadLocation string `json:"download_location"`
+	HTTPProxy        string `json:"http_proxy,omitempty"`
+	HTTPSProxy       string `json:"https_proxy,omitempty"`
+	DefaultIaaS      string `json:"default_iaas"`
+	BaseURL          string `json:"base_url"`
+}
+
+// firstProfileName returns the default profile if present, otherwise the first name in order
+func firstProfileName(profiles map[string]Profile) string {
+	if _, exists := profiles[defaultProfileName]; exists || len(profiles) == 0 {
+		return defaultProfileName
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// validateIaaS normalizes an IaaS name, accepting google as an alias for gcp
+func validateIaaS(iaas string) (string, error) {
+	iaas = strings.ToLower(strings.TrimSpace(iaas))
+	if iaas == "google" {
+		iaas = "gcp"
+	}
+	for _, supported := range supportedIaaS {
+		if iaas == supported {
+			return iaas, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported IaaS %q, expected one of %s", iaas, strings.Join(supportedIaaS, ", "))
+}
+
+// iaasKeywords returns the words that identify an IaaS in product file names
+func iaasKeywords(iaas string) []string {
+	switch iaas {
+	case "gcp":
+		return []string{"gcp", "google"}
+	case "vsphere":
+		return []string{"vsphere", "vmware"}
+	default:
+		return []string{iaas}
+	}
+}
+
+// activeProfileName returns the profile downloads and events are tagged with
+func (b *BroadcomService) activeProfileName() string {
+	b.sessionMutex.Lock()
+	defer b.sessionMutex.Unlock()
+	if b.profile == "" {
+		return defaultProfileName
+	}
+	return b.profile
+}
+
+// defaultIaaS returns the default IaaS of the selected profile
+func (b *BroadcomService) defaultIaaS() string {
+	config, err := b.loadConfig()
+	if err != nil || config.DefaultIaaS == "" {
+		return "vsphere"
+	}
+	return config.DefaultIaaS
+}
+
+// GetActiveProfile returns the name of the selected profile
+func (b *BroadcomService) GetActiveProfile() string {
+	return b.activeProfileName()
+}
+
+// ListProfiles returns all profiles ordered by name
+func (b *BroadcomService) ListProfiles() ([]ProfileInfo, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Profiles[config.profile] = config.Profile
+
+	profiles := make([]ProfileInfo, 0, len(config.Profiles))
+	for name, profile := range config.Profiles {
+		info := ProfileInfo{
+			Name:             name,
+			Active:           name == config.profile,
+			HasToken:         profile.EncryptedAPIToken != "",
+			DownloadLocation: profile.DownloadLocation,
+			HTTPProxy:        profile.HTTPProxy,
+			HTTPSProxy:       profile.HTTPSProxy,
+			DefaultIaaS:      profile.DefaultIaaS,
+			BaseURL:          profile.BaseURL,
+		}
+		if info.DownloadLocation == "" {
+			info.DownloadLocation = b.getDefaultDownloadLocation()
+		}
+		if info.DefaultIaaS == "" {
+			info.DefaultIaaS = "vsphere"
+		}
+		if info.BaseURL == "" {
+			info.BaseURL = defaultBaseURL
+		}
+		profiles = append(profiles, info)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles, nil
+}
+
+// CreateProfile adds a profile without a token. Its download location,
+// proxies and default IaaS are copied from copyFrom, or left at the defaults
+// when copyFrom is empty. Proxy credentials are not copied.
+func (b *BroadcomService) CreateProfile(name string, copyFrom string) error {
+	name = strings.TrimSpace(name)
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, dots, dashes and underscores", name)
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		return err
+	}
+	config.Profiles[config.profile] = config.Profile
+
+	if _, exists := config.Profiles[name]; exists {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+
+	var profile Profile
+	if copyFrom != "" {
+		source, exists := config.Profiles[copyFrom]
+		if !exists {
+			return fmt.Errorf("profile %q does not exist", copyFrom)
+		}
+		profile = source
+		profile.EncryptedAPIToken = ""
+		profile.ProxyUsername = ""
+		profile.EncryptedProxyPassword = ""
+	}
+	config.Profiles[name] = profile
+	return b.saveConfig(config)
+}
+
+// updateProfile changes the stored settings of any profile
+func (b *BroadcomService) updateProfile(name string, update func(profile *Profile) error) error {
+	config, err := b.loadConfig()
+	if err != nil {
+		return err
+	}
+	config.Profiles[config.profile] = config.Profile
+
+	profile, exists := config.Profiles[name]
+	if !exists {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	if err := update(&profile); err != nil {
+		return err
+	}
+	config.Profiles[name] = profile
+	config.Profile = config.Profiles[config.profile]
+	if name == config.profile {
+		return b.saveConnectionConfig(config)
+	}
+	return b.saveConfig(config)
+}
+
+// setProfileToken validates, encrypts and stores the token of a profile that may
+// not be selected. The token of the selected profile replaces the session token.
+func (b *BroadcomService) setProfileToken(name string, token string) error {
+	token = strings.TrimSpace(token)
+	if name == b.activeProfileName() {
+		return b.SetAPIToken(token)
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		return err
+	}
+	profile, exists := config.Profiles[name]
+	if !exists {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	if token != "" {
+		// The profile may reach the API through its own proxy and CA bundle
+		target := *config
+		target.profile = name
+		target.Profile = profile
+		client, err := b.profileHTTPClient(&target)
+		if err != nil {
+			return err
+		}
+		defer client.CloseIdleConnections()
+		if err := b.validateTokenAt(client, resolveBaseURL(&target), token); err != nil {
+			return err
+		}
+	}
+
+	b.sessionMutex.Lock()
+	passphrase := b.passphrase
+	b.sessionMutex.Unlock()
+
+	encrypted, err := b.encryptSecret(token, tokenProtection(config), passphrase)
+	if err != nil {
+		return err
+	}
+	return b.updateProfile(name, func(profile *Profile) error {
+		profile.EncryptedAPIToken = encrypted
+		return nil
+	})
+}
+
+// SwitchProfile makes another profile the active one and loads its token.
+// Queued downloads of other profiles wait until their profile is active again.
+// When the token cannot be loaded the profile is active all the same, without one.
+func (b *BroadcomService) SwitchProfile(name string) error {
+	// The queue stays locked until the token of the new profile is loaded, so
+	// no download starts in between with the settings of one profile and the
+	// token of the other
+	m := b.queue
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, job := range m.jobs {
+		if job.State == JobRunning {
+			return fmt.Errorf("wait for running downloads to finish or pause them before switching profiles")
+		}
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, exists := config.Profiles[name]; !exists {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+
+	config.ActiveProfile = name
+	if err := b.saveConfig(config); err != nil {
+		return err
+	}
+
+	b.sessionMutex.Lock()
+	b.profileOverride = ""
+	b.sessionMutex.Unlock()
+	b.resetTransport()
+	tokenErr := b.loadToken()
+	if tokenErr != nil {
+		tokenErr = fmt.Errorf("switched to profile %q, but could not load its token: %w", name, tokenErr)
+	}
+
+	b.emit("profile-changed", map[string]interface{}{
+		"profile":     name,
+		"tokenLocked": b.IsTokenLocked(),
+	})
+
+	// Start the queued downloads of the new profile
+	m.ensureLoaded()
+	m.schedule()
+	return tokenErr
+}
+
+// DeleteProfile removes a profile that is neither active nor has unfinished downloads
+func (b *BroadcomService) DeleteProfile(name string) error {
+	config, err := b.loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, exists := config.Profiles[name]; !exists {
+		return fmt.Errorf("profile %q does not exist", name)
+	}
+	if name == config.profile || name == config.ActiveProfile {
+		return fmt.Errorf("cannot delete the active profile %q; switch to another profile first", name)
+	}
+
+	m := b.queue
+	m.mu.Lock()
+	m.ensureLoaded()
+	for _, job := range m.jobs {
+		if job.Profile == name && job.State != JobDone {
+			m.mu.Unlock()
+			return fmt.Errorf("profile %q still has unfinished downloads", name)
+		}
+	}
+	m.mu.Unlock()
+
+	config.Profiles[config.profile] = config.Profile
+	delete(config.Profiles, name)
+	return b.saveConfig(config)
+}
+
+// GetDefaultIaaS returns the default IaaS of the active profile
+func (b *BroadcomService) GetDefaultIaaS() string {
+	return b.defaultIaaS()
+}
+
+// SetDefaultIaaS sets the IaaS whose stemcells and Ops Manager images the
+// active profile downloads
+func (b *BroadcomService) SetDefaultIaaS(iaas string) error {
+	iaas, err := validateIaaS(iaas)
+	if err != nil {
+		return err
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		return err
+	}
+	config.DefaultIaaS = iaas
+	return b.saveConfig(config)
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// connectivityTimeout bounds each hop of TestConnectivity
+const connectivityTimeout = 15 * time.Second
+
+// Hops checked by TestConnectivity, in order
+const (
+	HopDNS       = "dns"        // Resolving the proxy, or the API host without a proxy
+	HopProxy     = "proxy"      // TCP connection to the proxy
+	HopProxyAuth = "proxy_auth" // CONNECT tunnel through the proxy, including its credentials
+	HopTLS       = "tls"        // TLS handshake with the API host
+	HopAPI       = "api"        // HTTP response from the API
+	HopToken     = "token"      // Exchange of the API token for an access token
+)
+
+// ConnectivityHop is the outcome of one step on the way to the API
+type ConnectivityHop struct {
+	Name       string `json:"name"`
+	Target     string `json:"target"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	Hint       string `json:"hint,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// ConnectivityReport lists the checked hops up to the first failure
+type ConnectivityReport struct {
+	OK        bool              `json:"ok"`
+	FailedHop string            `json:"failed_hop,omitempty"`
+	Proxy     string            `json:"proxy,omitempty"` // Proxy used for the API, without credentials
+	Hops      []ConnectivityHop `json:"hops"`
+}
+
+// noProxyMatch reports whether a URL bypasses the proxy according to a
+// NO_PROXY style list. Entries are "*", host names matching themselves and
+// their subdomains, ".domain" or "*.domain" for subdomains only, IP addresses
+// and CIDR ranges, each optionally with a port. Loopback is always direct.
+func noProxyMatch(noProxy string, u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return true
+	}
+
+	entries := strings.FieldsFunc(strings.ToLower(noProxy), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	for _, entry := range entries {
+		if entry == "*" {
+			return true
+		}
+
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		entryHost = strings.Trim(entryHost, "[]")
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+
+		if _, network, err := net.ParseCIDR(entryHost); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && ip.Equal(entryIP) {
+				return true
+			}
+			continue
+		}
+
+		entryHost = strings.TrimPrefix(entryHost, "*")
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return true
+			}
+			continue
+		}
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeNoProxy turns a NO_PROXY list separated by commas, spaces or
+// newlines into the comma-separated form child processes expect
+func normalizeNoProxy(noProxy string) string {
+	entries := strings.FieldsFunc(noProxy, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	return strings.Join(entries, ",")
+}
+
+// proxyURLWithCredentials adds the saved proxy credentials to a proxy URL
+// unless it already carries its own
+func (b *BroadcomService) proxyURLWithCredentials(config *Config, proxy string) (string, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return "", fmt.Errorf("invalid proxy URL %q: %w", proxy, err)
+	}
+	if config.ProxyUsername == "" || proxyURL.User != nil {
+		return proxyURL.String(), nil
+	}
+
+	password, err := b.revealSecret(config, config.EncryptedProxyPassword)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt proxy password: %w", err)
+	}
+	proxyURL.User = url.UserPassword(config.ProxyUsername, password)
+	return proxyURL.String(), nil
+}
+
+// proxyForURL returns the proxy a request to u goes through, or nil to connect directly
+func (b *BroadcomService) proxyForURL(config *Config, u *url.URL) (*url.URL, error) {
+	var proxy string
+	if u.Scheme == "https" {
+		proxy = config.HTTPSProxy
+	} else if u.Scheme == "http" {
+		proxy = config.HTTPProxy
+	}
+	if proxy == "" || noProxyMatch(config.NoProxy, u) {
+		return nil, nil
+	}
+
+	proxyURL, err := b.proxyURLWithCredentials(config, proxy)
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(proxyURL)
+}
+
+// GetNoProxy returns the hosts that are reached without the proxy
+func (b *BroadcomService) GetNoProxy() (string, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.NoProxy, nil
+}
+
+// SetNoProxy sets the comma-separated hosts, domains and CIDR ranges that
+// are reached without the proxy
+func (b *BroadcomService) SetNoProxy(noProxy string) error {
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+
+	config.NoProxy = normalizeNoProxy(noProxy)
+	return b.saveConnectionConfig(config)
+}
+
+// GetProxyUsername returns the user name sent to the proxy
+func (b *BroadcomService) GetProxyUsername() (string, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.ProxyUsername, nil
+}
+
+// SetProxyCredentials saves the proxy basic auth credentials, encrypting the
+// password like the API token. An empty password keeps the saved one; an
+// empty username removes both.
+func (b *BroadcomService) SetProxyCredentials(username string, password string) error {
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		config.ProxyUsername = ""
+		config.EncryptedProxyPassword = ""
+		return b.saveConnectionConfig(config)
+	}
+
+	if password != "" {
+		b.sessionMutex.Lock()
+		passphrase := b.passphrase
+		b.sessionMutex.Unlock()
+
+		encrypted, err := b.encryptSecret(password, tokenProtection(config), passphrase)
+		if err != nil {
+			return err
+		}
+		config.EncryptedProxyPassword = encrypted
+	}
+	config.ProxyUsername = username
+	return b.saveConnectionConfig(config)
+}
+
+// GetProxyCAFile returns the PEM bundle trusted for a TLS-intercepting proxy
+func (b *BroadcomService) GetProxyCAFile() (string, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.ProxyCAFile, nil
+}
+
+// SetProxyCAFile sets a PEM bundle that is trusted in addition to the system
+// roots, for proxies that re-sign TLS connections
+func (b *BroadcomService) SetProxyCAFile(path string) error {
+	path = strings.TrimSpace(path)
+	if path != "" {
+		if _, err := loadCertPool(path); err != nil {
+			return err
+		}
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+
+	config.ProxyCAFile = path
+	return b.saveConnectionConfig(config)
+}
+
+// TestConnectivity checks every hop between this machine and the API, from
+// name resolution through the proxy and TLS to the token exchange, and
+// reports the first one that fails
+func (b *BroadcomService) TestConnectivity() (*ConnectivityReport, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	baseURL := b.apiBaseURL()
+	apiURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	client, err := b.createHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ConnectivityReport{}
+	record := func(hop ConnectivityHop, started time.Time, err error) bool {
+		hop.DurationMs = time.Since(started).Milliseconds()
+		hop.OK = err == nil
+		if err != nil {
+			hop.Error = err.Error()
+			hop.Hint = connectivityHint(hop.Name, err)
+			report.FailedHop = hop.Name
+		}
+		report.Hops = append(report.Hops, hop)
+		return hop.OK
+	}
+
+	ctx, cancel := context.WithTimeout(b.requestContext(), 4*connectivityTimeout)
+	defer cancel()
+
+	proxyURL, err := b.proxyForURL(config, apiURL)
+	if err != nil {
+		record(ConnectivityHop{Name: HopProxyAuth, Target: config.HTTPSProxy}, time.Now(), err)
+		return report, nil
+	}
+
+	// Without a proxy the API host is resolved locally, otherwise the proxy does it
+	dnsHost := apiURL.Hostname()
+	if proxyURL != nil {
+		report.Proxy = (&url.URL{Scheme: proxyURL.Scheme, Host: proxyURL.Host}).String()
+		dnsHost = proxyURL.Hostname()
+	}
+	started := time.Now()
+	dnsCtx, dnsCancel := context.WithTimeout(ctx, connectivityTimeout)
+	_, err = net.DefaultResolver.LookupHost(dnsCtx, dnsHost)
+	dnsCancel()
+	if !record(ConnectivityHop{Name: HopDNS, Target: dnsHost}, started, err) {
+		return report, nil
+	}
+
+	if proxyURL != nil {
+		proxyAddr := proxyURL.Host
+		if proxyURL.Port() == "" {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+			if proxyURL.Scheme == "https" {
+				proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+			}
+		}
+		started = time.Now()
+		dialer := &net.Dialer{Timeout: connectivityTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+		if conn != nil {
+			conn.Close()
+		}
+		if !record(ConnectivityHop{Name: HopProxy, Target: proxyAddr}, started, err) {
+			return report, nil
+		}
+	}
+
+	// One request covers the tunnel, the TLS handshake and the API response;
+	// the trace tells which of them failed
+	var tlsStarted, tlsDone bool
+	var tlsErr error
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { tlsStarted = true },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			tlsDone = true
+			tlsErr = err
+		},
+	}
+	requestCtx, requestCancel := context.WithTimeout(httptrace.WithClientTrace(ctx, trace), connectivityTimeout)
+	defer requestCancel()
+	req, err := http.NewRequestWithContext(requestCtx, http.MethodGet, baseURL+"/api/v2/products", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	started = time.Now()
+	resp, err := client.Do(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if err == nil && resp.StatusCode >= 500 {
+		err = fmt.Errorf("API answered with status %d", resp.StatusCode)
+	}
+
+	apiHost := apiURL.Host
+	switch {
+	case err == nil:
+		if proxyURL != nil && apiURL.Scheme == "https" {
+			record(ConnectivityHop{Name: HopProxyAuth, Target: apiHost}, started, nil)
+		}
+		if apiURL.Scheme == "https" {
+			record(ConnectivityHop{Name: HopTLS, Target: apiHost}, started, nil)
+		}
+		record(ConnectivityHop{Name: HopAPI, Target: baseURL}, started, nil)
+	case proxyURL != nil && apiURL.Scheme == "https" && !tlsStarted:
+		record(ConnectivityHop{Name: HopProxyAuth, Target: apiHost}, started, err)
+		return report, nil
+	case tlsStarted && (!tlsDone || tlsErr != nil) || isCertificateError(err):
+		if proxyURL != nil {
+			record(ConnectivityHop{Name: HopProxyAuth, Target: apiHost}, started, nil)
+		}
+		record(ConnectivityHop{Name: HopTLS, Target: apiHost}, started, err)
+		return report, nil
+	default:
+		record(ConnectivityHop{Name: HopAPI, Target: baseURL}, started, err)
+		return report, nil
+	}
+
+	if token := b.currentToken(); token != "" {
+		started = time.Now()
+		tokenCtx, tokenCancel := context.WithTimeout(ctx, connectivityTimeout)
+		_, _, err = b.exchangeRefreshToken(tokenCtx, client, baseURL, token)
+		tokenCancel()
+		if !record(ConnectivityHop{Name: HopToken, Target: baseURL}, started, err) {
+			return report, nil
+		}
+	}
+
+	report.OK = true
+	return report, nil
+}
+
+// isCertificateError reports whether err comes from verifying a server certificate
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var verification *tls.CertificateVerificationError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &invalid) ||
+		errors.As(err, &hostname) || errors.As(err, &verification)
+}
+
+// connectivityHint suggests what to check when a hop fails
+func connectivityHint(hop string, err error) string {
+	switch hop {
+	case HopDNS:
+		return "The host name could not be resolved; check the proxy address and the DNS settings of this machine"
+	case HopProxy:
+		return "The proxy does not accept connections; check its address and port"
+	case HopProxyAuth:
+		if strings.Contains(err.Error(), "Proxy Authentication Required") {
+			return "The proxy rejected the credentials; check the proxy user name and password"
+		}
+		if errors.Is(err, errTokenLocked) {
+			return "Unlock the API token to decrypt the proxy password"
+		}
+		return "The proxy refused to open a tunnel to the API; check whether the API host must be listed in NO_PROXY"
+	case HopTLS:
+		if isCertificateError(err) {
+			return "The server certificate is not trusted; if your proxy re-signs TLS, add its CA bundle under CA Certificates"
+		}
+		return "The TLS handshake failed"
+	case HopAPI:
+		return "The API could not be reached or is failing; try again later"
+	case HopToken:
+		return "The API token was rejected; generate a new one on the Broadcom Support Portal"
+	}
+	return ""
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNoProxyMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		noProxy string
+		url     string
+		want    bool
+	}{
+		{name: "empty list", noProxy: "", url: "https://network.tanzu.vmware.com", want: false},
+		{name: "wildcard", noProxy: "*", url: "https://network.tanzu.vmware.com", want: true},
+		{name: "localhost is always direct", noProxy: "", url: "http://localhost:8080", want: true},
+		{name: "loopback is always direct", noProxy: "", url: "http://127.0.0.1:8080", want: true},
+
+		{name: "host", noProxy: "mirror.corp", url: "https://mirror.corp/api", want: true},
+		{name: "host matches subdomains", noProxy: "corp", url: "https://mirror.corp/api", want: true},
+		{name: "host is not a suffix match", noProxy: "corp", url: "https://mirrorcorp/api", want: false},
+		{name: "host is case insensitive", noProxy: "Mirror.CORP", url: "https://mirror.corp", want: true},
+		{name: "dot domain matches subdomains", noProxy: ".corp", url: "https://mirror.corp", want: true},
+		{name: "dot domain does not match itself", noProxy: ".corp", url: "https://corp", want: false},
+		{name: "star domain matches subdomains", noProxy: "*.corp", url: "https://a.b.corp", want: true},
+		{name: "star domain does not match itself", noProxy: "*.corp", url: "https://corp", want: false},
+		{name: "separated by spaces and newlines", noProxy: "example.com\n  mirror.corp", url: "https://mirror.corp", want: true},
+		{name: "separated by commas", noProxy: "example.com,mirror.corp", url: "https://mirror.corp", want: true},
+
+		{name: "host and port", noProxy: "mirror.corp:8443", url: "https://mirror.corp:8443", want: true},
+		{name: "host and other port", noProxy: "mirror.corp:8443", url: "https://mirror.corp", want: false},
+		{name: "host and default https port", noProxy: "mirror.corp:443", url: "https://mirror.corp", want: true},
+		{name: "host and default http port", noProxy: "mirror.corp:80", url: "http://mirror.corp", want: true},
+		{name: "dot domain and port", noProxy: ".corp:443", url: "https://mirror.corp", want: true},
+
+		{name: "IP address", noProxy: "10.1.2.3", url: "https://10.1.2.3", want: true},
+		{name: "other IP address", noProxy: "10.1.2.3", url: "https://10.1.2.4", want: false},
+		{name: "IP address and port", noProxy: "10.1.2.3:8443", url: "https://10.1.2.3:8443", want: true},
+		{name: "IPv6 address and port", noProxy: "[fd00::1]:443", url: "https://[fd00::1]", want: true},
+		{name: "CIDR", noProxy: "10.0.0.0/8", url: "https://10.20.30.40", want: true},
+		{name: "outside the CIDR", noProxy: "10.0.0.0/8", url: "https://11.0.0.1", want: false},
+		{name: "CIDR and port", noProxy: "10.0.0.0/8:443", url: "https://10.20.30.40", want: true},
+		{name: "CIDR and other port", noProxy: "10.0.0.0/8:8443", url: "https://10.20.30.40", want: false},
+		{name: "IPv6 CIDR", noProxy: "fd00::/8", url: "https://[fd00::1]", want: true},
+		{name: "CIDR does not match names", noProxy: "10.0.0.0/8", url: "https://mirror.corp", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := noProxyMatch(tt.noProxy, u); got != tt.want {
+				t.Errorf("noProxyMatch(%q, %s) = %v, want %v", tt.noProxy, tt.url, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// defaultDownloadRetries is how often a failed download is retried when
+// neither the job nor the config sets a limit
+const defaultDownloadRetries = 3
+
+var (
+	// omFatalPatterns mark om output of failures that a retry cannot fix.
+	// They are checked first, so they only match status-shaped text and
+	// specific messages; a bare "not found" would catch "host not found".
+	omFatalPatterns = []string{
+		"401 unauthorized",
+		"status code 401",
+		"status 401",
+		"does not have access",
+		"eula",
+		"could not find",
+		"no file matched",
+		"product file not found",
+		"release not found",
+		"product not found",
+		"404 not found",
+		"no space left on device",
+		"permission denied",
+		"unknown flag",
+	}
+	// omRetryablePatterns mark om output of network failures, server errors
+	// and expired signed URLs. The next attempt requests a fresh URL. Status
+	// codes only match status-shaped text, never digits of a byte count.
+	omRetryablePatterns = []string{
+		"connection reset",
+		"connection refused",
+		"broken pipe",
+		"timeout",
+		"timed out",
+		"eof",
+		"tls handshake",
+		"no such host",
+		"host not found",
+		"temporary failure",
+		"too many requests",
+		"status 429",
+		"status code 429",
+		"500 internal server error",
+		"status 500",
+		"status code 500",
+		"bad gateway",
+		"status 502",
+		"status code 502",
+		"service unavailable",
+		"status 503",
+		"status code 503",
+		"gateway timeout",
+		"status 504",
+		"status code 504",
+		"request has expired",
+		"signaturedoesnotmatch",
+		"accessdenied",
+		"stream error",
+	}
+)
+
+// isRetryableOMOutput classifies a failed om download by its output.
+// Unknown failures are fatal.
+func isRetryableOMOutput(output string) bool {
+	output = strings.ToLower(output)
+	for _, pattern := range omFatalPatterns {
+		if strings.Contains(output, pattern) {
+			return false
+		}
+	}
+	for _, pattern := range omRetryablePatterns {
+		if strings.Contains(output, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRetryableStatus reports whether an object store response is worth
+// retrying. Signed URLs expire with 403, so a new attempt fetches a new one.
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return statusCode >= 500
+}
+
+// isRetryableDownload reports whether a failed download may succeed when it
+// is tried again. Unknown failures, such as local file errors, are fatal.
+func isRetryableDownload(err error) bool {
+	var omErr *omDownloadError
+	if errors.As(err, &omErr) {
+		return isRetryableOMOutput(omErr.Output)
+	}
+	var statusErr *downloadStatusError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatus(statusErr.StatusCode)
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		// The corrupt file was quarantined; the next attempt starts clean
+		return true
+	}
+	return isRetryable(err)
+}
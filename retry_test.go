@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestIsRetryableOMOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{name: "unauthorized status", output: "could not execute download: 401 Unauthorized", want: false},
+		{name: "status code 401", output: "request failed: status code 401", want: false},
+		{name: "401 in a byte count", output: "401.20 MiB / 2.00 GiB connection reset by peer", want: true},
+		{name: "missing product file", output: "Error: product file not found for glob *.pivotal", want: false},
+		{name: "missing release", output: "release not found", want: false},
+		{name: "EULA not accepted", output: "user must accept the EULA before downloading", want: false},
+		{name: "no space left", output: "write /tmp/x: no space left on device", want: false},
+		{name: "connection reset with host not found", output: "connection reset by peer; host not found", want: true},
+		{name: "host not found", output: "dial tcp: lookup pivnet: host not found", want: true},
+		{name: "no such host", output: "dial tcp: lookup network.pivotal.io: no such host", want: true},
+		{name: "server error", output: "503 Service Unavailable", want: true},
+		{name: "bad gateway status", output: "unexpected response: status 502", want: true},
+		{name: "status code 504", output: "request failed: status code 504", want: true},
+		{name: "rate limited status", output: "request failed: status code 429", want: true},
+		{name: "server error digits in a byte count", output: "Error: copying 503.42 MiB of 1.50 GiB: disk quota exceeded", want: false},
+		{name: "rate limit digits in a byte count", output: "downloaded 429 bytes: unexpected response", want: false},
+		{name: "gateway digits in a file size", output: "file size 50245 does not match 50246", want: false},
+		{name: "status digits in a file ID", output: "could not download product file 1504", want: false},
+		{name: "expired signed URL", output: "AccessDenied: Request has expired", want: true},
+		{name: "unknown failure", output: "something unexpected happened", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableOMOutput(tt.output); got != tt.want {
+				t.Errorf("isRetryableOMOutput(%q) = %v, want %v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// How the API token is protected at rest
+const (
+	TokenProtectionKeyFile    = "key_file"   // Random key stored next to the config, readable only by the user
+	TokenProtectionPassphrase = "passphrase" // Key derived from a passphrase that is never stored
+)
+
+const (
+	// secretFormatPrefix versions the encoding of encrypted config values
+	secretFormatPrefix = "v1:"
+	// secretKeyFileName holds the machine key used in key file mode
+	secretKeyFileName = "token.key"
+	// pbkdf2Iterations is the PBKDF2-HMAC-SHA256 work factor for passphrases
+	pbkdf2Iterations = 600000
+
+	secretSaltSize = 16
+)
+
+// passphraseEnv supplies the token passphrase to headless runs
+const passphraseEnv = "TILE_DOWNLOADER_PASSPHRASE"
+
+// errTokenLocked is returned while a passphrase-protected token has not been unlocked
+var errTokenLocked = &APIError{Kind: APIErrorUnauthorized, Message: "API token is locked; enter the passphrase to unlock it"}
+
+// errWrongPassphrase is returned when a secret cannot be decrypted
+var errWrongPassphrase = errors.New("incorrect passphrase or corrupt encrypted value")
+
+// hmacSHA256 returns the HMAC-SHA256 of data
+func hmacSHA256(key []byte, data []byte) []byte {
+	var h hash.Hash = hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// loadKeyFile returns the machine key, creating it on first use
+func (b *BroadcomService) loadKeyFile() ([]byte, error) {
+	configDir, err := b.getConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	keyPath := filepath.Join(configDir, secretKeyFileName)
+
+	key, err := os.ReadFile(keyPath)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key file %s is invalid", keyPath)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return nil, fmt.Errorf("failed to create key file: %w", err)
+	}
+	return key, nil
+}
+
+// secretKey derives the AES key for one encrypted value
+func (b *BroadcomService) secretKey(protection string, passphrase string, salt []byte) ([]byte, error) {
+	if protection == TokenProtectionPassphrase {
+		if passphrase == "" {
+			return nil, errTokenLocked
+		}
+		return pbkdf2.Key([]byte(passphrase), salt, pbkdf2Iterations, 32, sha256.New), nil
+	}
+
+	fileKey, err := b.loadKeyFile()
+	if err != nil {
+		return nil, err
+	}
+	return hmacSHA256(fileKey, salt), nil
+}
+
+// encryptSecret encrypts a config value with AES-256-GCM
+func (b *BroadcomService) encryptSecret(plaintext string, protection string, passphrase string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	salt := make([]byte, secretSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := b.secretKey(protection, passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := append(append(salt, nonce...), gcm.Seal(nil, nonce, []byte(plaintext), nil)...)
+	return secretFormatPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret reverses encryptSecret
+func (b *BroadcomService) decryptSecret(encoded string, protection string, passphrase string) (string, error) {
+	if encoded == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(encoded, secretFormatPrefix) {
+		return "", fmt.Errorf("unsupported encrypted value format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encoded, secretFormatPrefix))
+	if err != nil || len(sealed) < secretSaltSize {
+		return "", errWrongPassphrase
+	}
+
+	salt := sealed[:secretSaltSize]
+	key, err := b.secretKey(protection, passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	rest := sealed[secretSaltSize:]
+	if len(rest) < gcm.NonceSize() {
+		return "", errWrongPassphrase
+	}
+
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errWrongPassphrase
+	}
+	return string(plaintext), nil
+}
+
+// revealSecret decrypts a config value with the current protection mode.
+// Results are cached, as deriving a key from a passphrase is slow.
+func (b *BroadcomService) revealSecret(config *Config, encoded string) (string, error) {
+	if encoded == "" {
+		return "", nil
+	}
+
+	b.sessionMutex.Lock()
+	plaintext, cached := b.secretCache[encoded]
+	passphrase := b.passphrase
+	b.sessionMutex.Unlock()
+	if cached {
+		return plaintext, nil
+	}
+
+	plaintext, err := b.decryptSecret(encoded, tokenProtection(config), passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	b.sessionMutex.Lock()
+	if b.secretCache == nil {
+		b.secretCache = make(map[string]string)
+	}
+	b.secretCache[encoded] = plaintext
+	b.sessionMutex.Unlock()
+	return plaintext, nil
+}
+
+// secrets returns the encrypted fields of a profile
+func (p *Profile) secrets() []*string {
+	return []*string{&p.EncryptedAPIToken, &p.EncryptedProxyPassword}
+}
+
+// tokenProtection returns the protection mode of a config, defaulting to the key file
+func tokenProtection(config *Config) string {
+	if config.TokenProtection == TokenProtectionPassphrase {
+		return TokenProtectionPassphrase
+	}
+	return TokenProtectionKeyFile
+}
+
+// missingTokenError explains why no API token is available
+func (b *BroadcomService) missingTokenError() error {
+	if b.IsTokenLocked() {
+		return errTokenLocked
+	}
+	return errAPITokenNotSet
+}
+
+// GetTokenProtection returns how the API token is protected at rest
+func (b *BroadcomService) GetTokenProtection() (string, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return "", err
+	}
+	return tokenProtection(config), nil
+}
+
+// IsTokenLocked reports whether a passphrase-protected token is waiting to be unlocked
+func (b *BroadcomService) IsTokenLocked() bool {
+	b.sessionMutex.Lock()
+	defer b.sessionMutex.Unlock()
+	return b.tokenLocked
+}
+
+// UnlockToken decrypts a passphrase-protected token for this session
+func (b *BroadcomService) UnlockToken(passphrase string) error {
+	config, err := b.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	token, err := b.decryptSecret(config.EncryptedAPIToken, tokenProtection(config), passphrase)
+	if err != nil {
+		return err
+	}
+
+	b.sessionMutex.Lock()
+	b.passphrase = passphrase
+	b.tokenLocked = false
+	b.apiToken = token
+	b.sessionMutex.Unlock()
+	return nil
+}
+
+// SetTokenPassphrase re-encrypts the saved tokens and proxy passwords of all
+// profiles with a passphrase, or with the machine key file when the passphrase
+// is empty
+func (b *BroadcomService) SetTokenPassphrase(passphrase string) error {
+	if b.IsTokenLocked() {
+		return errTokenLocked
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	b.sessionMutex.Lock()
+	oldPassphrase := b.passphrase
+	b.sessionMutex.Unlock()
+
+	oldProtection := tokenProtection(config)
+	protection := TokenProtectionKeyFile
+	if passphrase != "" {
+		protection = TokenProtectionPassphrase
+	}
+
+	// The selected profile is stored from the embedded settings
+	config.Profiles[config.profile] = config.Profile
+	for name, profile := range config.Profiles {
+		for _, secret := range profile.secrets() {
+			plaintext, err := b.decryptSecret(*secret, oldProtection, oldPassphrase)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt the secrets of profile %s: %w", name, err)
+			}
+			if *secret, err = b.encryptSecret(plaintext, protection, passphrase); err != nil {
+				return err
+			}
+		}
+		config.Profiles[name] = profile
+	}
+	config.Profile = config.Profiles[config.profile]
+
+	config.TokenProtection = protection
+	config.APIToken = ""
+	if err := b.saveConfig(config); err != nil {
+		return err
+	}
+
+	// The session keeps the old passphrase until the secrets it protects are gone
+	b.sessionMutex.Lock()
+	b.passphrase = passphrase
+	b.sessionMutex.Unlock()
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func TestPassphraseKeyDerivation(t *testing.T) {
+	// PBKDF2-HMAC-SHA256 vectors from RFC 7914 section 11
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int
+		want       string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2.Key([]byte(tt.password), []byte(tt.salt), tt.iterations, 64, sha256.New))
+		if got != tt.want {
+			t.Errorf("PBKDF2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+
+	// Tokens are encrypted with 600000 iterations
+	t.Setenv("HOME", t.TempDir())
+	b := NewBroadcomService()
+	salt := make([]byte, secretSaltSize)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+	key, err := b.secretKey(TokenProtectionPassphrase, "correct horse", salt)
+	if err != nil {
+		t.Fatalf("secretKey: %v", err)
+	}
+	if want := "96a5904c2e08c8da42305dbcc5d7cf18ead2636d49f59526b606f26696281473"; hex.EncodeToString(key) != want {
+		t.Errorf("passphrase key = %x, want %s", key, want)
+	}
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		protection string
+		passphrase string
+		decryptAs  string
+		wantErr    error
+	}{
+		{name: "key file", protection: TokenProtectionKeyFile},
+		{name: "passphrase", protection: TokenProtectionPassphrase, passphrase: "correct horse", decryptAs: "correct horse"},
+		{name: "wrong passphrase", protection: TokenProtectionPassphrase, passphrase: "correct horse", decryptAs: "battery staple", wantErr: errWrongPassphrase},
+		{name: "locked", protection: TokenProtectionPassphrase, passphrase: "correct horse", wantErr: errTokenLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			b := NewBroadcomService()
+
+			encrypted, err := b.encryptSecret("refresh-token", tt.protection, tt.passphrase)
+			if err != nil {
+				t.Fatalf("encryptSecret: %v", err)
+			}
+			if encrypted == "refresh-token" || encrypted[:len(secretFormatPrefix)] != secretFormatPrefix {
+				t.Fatalf("encrypted value %q", encrypted)
+			}
+
+			plaintext, err := b.decryptSecret(encrypted, tt.protection, tt.decryptAs)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("decryptSecret: got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || plaintext != "refresh-token" {
+				t.Errorf("decryptSecret: %q, %v; want the token", plaintext, err)
+			}
+		})
+	}
+}
+
+func TestSetTokenPassphrase(t *testing.T) {
+	api := newFakeAPI(t)
+	if err := api.b.SetTokenPassphrase("correct horse"); err != nil {
+		t.Fatalf("SetTokenPassphrase: %v", err)
+	}
+
+	// A new session needs the passphrase to read the token
+	b := NewBroadcomService()
+	if err := b.loadToken(); err != nil {
+		t.Fatalf("loadToken: %v", err)
+	}
+	if !b.IsTokenLocked() {
+		t.Fatal("token is not locked in a new session")
+	}
+	if err := b.UnlockToken("battery staple"); !errors.Is(err, errWrongPassphrase) {
+		t.Errorf("UnlockToken with the wrong passphrase: got %v, want %v", err, errWrongPassphrase)
+	}
+	if err := b.UnlockToken("correct horse"); err != nil {
+		t.Fatalf("UnlockToken: %v", err)
+	}
+	if token := b.currentToken(); token != "fake-refresh-token" {
+		t.Errorf("unlocked token %q, want fake-refresh-token", token)
+	}
+}
+
+func TestSetTokenPassphraseKeepsSessionOnSaveFailure(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	b := NewBroadcomService()
+	configPath, err := b.getConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The config cannot be written through a link into a missing directory
+	if err := os.Symlink(filepath.Join(t.TempDir(), "missing", "config.json"), configPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetTokenPassphrase("correct horse"); err == nil {
+		t.Fatal("SetTokenPassphrase succeeded without saving the config")
+	}
+
+	b.sessionMutex.Lock()
+	passphrase := b.passphrase
+	b.sessionMutex.Unlock()
+	if passphrase != "" {
+		t.Errorf("session passphrase changed to %q although the config was not saved", passphrase)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunkSize bounds a single read of a throttled download, so slow
+// caps still advance in small steps
+const throttleChunkSize = 32 * 1024
+
+// errDownloadWindowClosed stops transfers that are still running when the
+// download window closes. They keep their partial file and wait in the queue.
+var errDownloadWindowClosed = errors.New("download window closed")
+
+// rateLimiter is a token bucket shared by every reader it throttles
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int64 // Bytes per second, zero for unlimited
+	tokens float64
+	last   time.Time
+}
+
+// setRate changes the cap; running transfers pick it up with their next read
+func (l *rateLimiter) setRate(bytesPerSecond int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == bytesPerSecond {
+		return
+	}
+	l.rate = bytesPerSecond
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// wait blocks until n more bytes fit within the cap
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if l.last.IsZero() {
+		l.last = now
+	}
+	// Allow at most one second of burst after an idle period
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttledReader reads through one or more rate limiters
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		for _, limiter := range t.limiters {
+			if waitErr := limiter.wait(t.ctx, n); waitErr != nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
+
+// throttle wraps a download body with the global cap and the cap of its job
+func (m *downloadManager) throttle(ctx context.Context, fileID int, body io.Reader) io.Reader {
+	if config, err := m.b.loadConfig(); err == nil {
+		m.bandwidth.setRate(config.BandwidthLimit)
+	}
+
+	m.mu.Lock()
+	var jobLimit int64
+	if job, exists := m.jobs[fileID]; exists {
+		jobLimit = job.BandwidthLimit
+	}
+	limiter, exists := m.jobLimiters[fileID]
+	if !exists {
+		limiter = &rateLimiter{}
+		m.jobLimiters[fileID] = limiter
+	}
+	m.mu.Unlock()
+	limiter.setRate(jobLimit)
+
+	return &throttledReader{ctx: ctx, r: body, limiters: []*rateLimiter{m.bandwidth, limiter}}
+}
+
+// DownloadWindow is a daily period in local time during which queued
+// downloads may run, such as 20:00 to 06:00
+type DownloadWindow struct {
+	Start string `json:"start"` // HH:MM
+	End   string `json:"end"`   // HH:MM; an end before the start runs past midnight
+}
+
+// parseClock reads an HH:MM time of day as minutes since midnight
+func parseClock(value string) (int, error) {
+	hours, minutes, found := strings.Cut(strings.TrimSpace(value), ":")
+	h, err := strconv.Atoi(hours)
+	if err != nil || !found || h < 0 || h > 23 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return h*60 + m, nil
+}
+
+// contains reports whether the window is open at t. A window that starts and
+// ends at the same time is open all day.
+func (w DownloadWindow) contains(t time.Time) bool {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	switch {
+	case start == end:
+		return true
+	case start < end:
+		return now >= start && now < end
+	default:
+		return now >= start || now < end
+	}
+}
+
+// nextClock returns the next time after t at which the clock shows minutes
+func nextClock(t time.Time, minutes int) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), minutes/60, minutes%60, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(t.Year(), t.Month(), t.Day()+1, minutes/60, minutes%60, 0, 0, t.Location())
+	}
+	return next
+}
+
+// downloadWindowState reports whether downloads may run at t and when that
+// may change next. Without windows downloads may always run.
+func downloadWindowState(windows []DownloadWindow, t time.Time) (open bool, nextChange time.Time) {
+	if len(windows) == 0 {
+		return true, time.Time{}
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			open = true
+		}
+		for _, clock := range []string{w.Start, w.End} {
+			minutes, err := parseClock(clock)
+			if err != nil {
+				continue
+			}
+			if next := nextClock(t, minutes); nextChange.IsZero() || next.Before(nextChange) {
+				nextChange = next
+			}
+		}
+	}
+	return open, nextChange
+}
+
+// downloadWindow reports whether the configured windows allow downloads now
+func (m *downloadManager) downloadWindow(t time.Time) (bool, time.Time) {
+	config, err := m.b.loadConfig()
+	if err != nil {
+		return true, time.Time{}
+	}
+	return downloadWindowState(config.DownloadWindows, t)
+}
+
+// GetBandwidthLimit returns the cap in bytes per second shared by all
+// downloads, zero when unlimited
+func (b *BroadcomService) GetBandwidthLimit() (int64, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.BandwidthLimit, nil
+}
+
+// SetBandwidthLimit caps the combined speed of all downloads in bytes per
+// second; zero removes the cap. Running downloads slow down right away.
+func (b *BroadcomService) SetBandwidthLimit(bytesPerSecond int64) error {
+	if bytesPerSecond < 0 {
+		return fmt.Errorf("bandwidth limit cannot be negative")
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+	config.BandwidthLimit = bytesPerSecond
+	if err := b.saveConfig(config); err != nil {
+		return err
+	}
+
+	b.queue.bandwidth.setRate(bytesPerSecond)
+	return nil
+}
+
+// SetDownloadBandwidthLimit caps the speed of one queued download in bytes
+// per second; zero removes the cap. The global cap still applies.
+func (b *BroadcomService) SetDownloadBandwidthLimit(fileID int, bytesPerSecond int64) error {
+	if bytesPerSecond < 0 {
+		return fmt.Errorf("bandwidth limit cannot be negative")
+	}
+
+	m := b.queue
+	m.mu.Lock()
+	job, exists := m.jobs[fileID]
+	if !exists {
+		m.mu.Unlock()
+		return fmt.Errorf("no download found for file ID %d", fileID)
+	}
+	job.BandwidthLimit = bytesPerSecond
+	limiter := m.jobLimiters[fileID]
+	m.changed()
+	m.mu.Unlock()
+
+	if limiter != nil {
+		limiter.setRate(bytesPerSecond)
+	}
+	return nil
+}
+
+// GetDownloadWindows returns the daily periods in which queued downloads run
+func (b *BroadcomService) GetDownloadWindows() ([]DownloadWindow, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.DownloadWindows == nil {
+		return []DownloadWindow{}, nil
+	}
+	return config.DownloadWindows, nil
+}
+
+// SetDownloadWindows restricts queued downloads to daily periods in local
+// time. Outside them jobs wait in the queue; transfers still running when a
+// window closes are stopped and resumed from their partial file when the next
+// one opens. An empty list lets downloads run at any time.
+func (b *BroadcomService) SetDownloadWindows(windows []DownloadWindow) error {
+	for _, w := range windows {
+		if _, err := parseClock(w.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(w.End); err != nil {
+			return err
+		}
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+	config.DownloadWindows = windows
+	if err := b.saveConfig(config); err != nil {
+		return err
+	}
+
+	b.queue.mu.Lock()
+	defer b.queue.mu.Unlock()
+	b.queue.schedule()
+	return nil
+}
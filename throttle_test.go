@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadWindowState(t *testing.T) {
+	day := func(hour, minute int) time.Time {
+		return time.Date(2025, time.March, 10, hour, minute, 0, 0, time.Local)
+	}
+	nextDay := func(hour, minute int) time.Time {
+		return time.Date(2025, time.March, 11, hour, minute, 0, 0, time.Local)
+	}
+	office := DownloadWindow{Start: "09:00", End: "17:00"}
+	overnight := DownloadWindow{Start: "20:00", End: "06:00"}
+
+	tests := []struct {
+		name     string
+		windows  []DownloadWindow
+		now      time.Time
+		wantOpen bool
+		wantNext time.Time
+	}{
+		{name: "no windows", now: day(12, 0), wantOpen: true},
+		{name: "inside a day window", windows: []DownloadWindow{office}, now: day(12, 0), wantOpen: true, wantNext: day(17, 0)},
+		{name: "at the start", windows: []DownloadWindow{office}, now: day(9, 0), wantOpen: true, wantNext: day(17, 0)},
+		{name: "at the end", windows: []DownloadWindow{office}, now: day(17, 0), wantOpen: false, wantNext: nextDay(9, 0)},
+		{name: "before a day window", windows: []DownloadWindow{office}, now: day(7, 30), wantOpen: false, wantNext: day(9, 0)},
+		{name: "overnight before midnight", windows: []DownloadWindow{overnight}, now: day(23, 0), wantOpen: true, wantNext: nextDay(6, 0)},
+		{name: "overnight after midnight", windows: []DownloadWindow{overnight}, now: day(3, 0), wantOpen: true, wantNext: day(6, 0)},
+		{name: "between overnight windows", windows: []DownloadWindow{overnight}, now: day(12, 0), wantOpen: false, wantNext: day(20, 0)},
+		{name: "all day", windows: []DownloadWindow{{Start: "00:00", End: "00:00"}}, now: day(12, 0), wantOpen: true, wantNext: nextDay(0, 0)},
+		{name: "second window opens first", windows: []DownloadWindow{overnight, {Start: "12:30", End: "13:30"}}, now: day(12, 0), wantOpen: false, wantNext: day(12, 30)},
+		{name: "invalid window ignored", windows: []DownloadWindow{{Start: "25:00", End: "x"}, office}, now: day(12, 0), wantOpen: true, wantNext: day(17, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, next := downloadWindowState(tt.windows, tt.now)
+			if open != tt.wantOpen || !next.Equal(tt.wantNext) {
+				t.Errorf("got open %v, next %v; want open %v, next %v", open, next, tt.wantOpen, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		rates []int64 // Limiters the reader passes through
+		size  int
+		want  time.Duration
+	}{
+		{name: "unlimited", rates: []int64{0}, size: 256 << 10, want: 0},
+		{name: "one cap", rates: []int64{1 << 20}, size: 256 << 10, want: 250 * time.Millisecond},
+		{name: "slower cap wins", rates: []int64{4 << 20, 512 << 10}, size: 128 << 10, want: 250 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var limiters []*rateLimiter
+			for _, rate := range tt.rates {
+				limiter := &rateLimiter{}
+				limiter.setRate(rate)
+				limiters = append(limiters, limiter)
+			}
+			r := &throttledReader{ctx: context.Background(), r: bytes.NewReader(make([]byte, tt.size)), limiters: limiters}
+
+			start := time.Now()
+			n, err := io.Copy(io.Discard, r)
+			elapsed := time.Since(start)
+			if err != nil || n != int64(tt.size) {
+				t.Fatalf("read %d bytes, %v; want %d", n, err, tt.size)
+			}
+			if elapsed < tt.want*8/10 || elapsed > tt.want*2+100*time.Millisecond {
+				t.Errorf("took %v, want about %v", elapsed, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterStopsWithContext(t *testing.T) {
+	limiter := &rateLimiter{}
+	limiter.setRate(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.wait(ctx, 1<<20); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestDownloadWindowClosingKeepsPartialFile(t *testing.T) {
+	api := newFakeAPI(t)
+	api.b.queue.start()
+
+	// A cap of one byte per second holds the transfer until the window closes
+	dir := t.TempDir()
+	err := api.b.EnqueueDownload(DownloadRequest{
+		ProductSlug:    "p-rabbitmq",
+		ReleaseID:      4001,
+		FileID:         40011,
+		FileName:       "p-rabbitmq-10.0.3-build.12.pivotal",
+		OutputDir:      dir,
+		BandwidthLimit: 1,
+	})
+	if err != nil {
+		t.Fatalf("EnqueueDownload: %v", err)
+	}
+	destPath := filepath.Join(dir, "p-rabbitmq-10.0.3-build.12.pivotal")
+	waitFor(t, "the transfer to start", func() bool {
+		_, err := os.Stat(destPath + partialSuffix)
+		return err == nil
+	})
+
+	start := time.Now().Add(2 * time.Hour)
+	err = api.b.SetDownloadWindows([]DownloadWindow{{Start: start.Format("15:04"), End: start.Add(time.Hour).Format("15:04")}})
+	if err != nil {
+		t.Fatalf("SetDownloadWindows: %v", err)
+	}
+	var job DownloadJob
+	waitFor(t, "the transfer to stop", func() bool {
+		api.b.queue.mu.Lock()
+		defer api.b.queue.mu.Unlock()
+		job = *api.b.queue.jobs[40011]
+		return api.b.queue.running == 0
+	})
+	if job.State != JobQueued || job.PartialPath != destPath+partialSuffix {
+		t.Fatalf("deferred job is %s with partial file %q, want %s with %s", job.State, job.PartialPath, JobQueued, destPath+partialSuffix)
+	}
+	if _, err := os.Stat(job.PartialPath); err != nil {
+		t.Fatalf("partial file of the deferred job: %v", err)
+	}
+
+	// Once the window opens again the transfer resumes from the partial file
+	if err := api.b.SetDownloadBandwidthLimit(40011, 0); err != nil {
+		t.Fatalf("SetDownloadBandwidthLimit: %v", err)
+	}
+	if err := api.b.SetDownloadWindows(nil); err != nil {
+		t.Fatalf("SetDownloadWindows: %v", err)
+	}
+	waitFor(t, "the download to finish", func() bool {
+		api.b.queue.mu.Lock()
+		defer api.b.queue.mu.Unlock()
+		return api.b.queue.jobs[40011].State == JobDone
+	})
+	api.mu.Lock()
+	ranges := len(api.ranges)
+	api.mu.Unlock()
+	if downloads := api.fake.Downloads(); len(downloads)-ranges != 1 {
+		t.Errorf("object store served %d requests, %d of them ranged; want only the first from the start", len(downloads), ranges)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
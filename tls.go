@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// caBundleFileName is the combined PEM bundle handed to child processes
+const caBundleFileName = "ca-bundle.pem"
+
+// systemCertFiles are the usual locations of the system roots on Unix,
+// checked in the same order as crypto/x509
+var systemCertFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// insecureWarnings remembers which components already logged that
+// certificate verification is off
+var insecureWarnings sync.Map
+
+// warnInsecureTLS logs, once per component, that TLS certificates are not verified
+func warnInsecureTLS(component string) {
+	if _, logged := insecureWarnings.LoadOrStore(component, true); logged {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "WARNING: TLS certificate verification is DISABLED for %s (insecure_skip_verify). "+
+		"Connections can be intercepted; configure a CA bundle instead.\n", component)
+}
+
+// caBundlePaths returns the PEM bundles trusted on top of the system roots
+func caBundlePaths(config *Config) []string {
+	var paths []string
+	if config.ProxyCAFile != "" {
+		paths = append(paths, config.ProxyCAFile)
+	}
+	return append(paths, config.CACertFiles...)
+}
+
+// loadCertPool returns the system roots plus the certificates of PEM bundles
+func loadCertPool(paths ...string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", path)
+		}
+	}
+	return pool, nil
+}
+
+// tlsConfig returns the TLS settings for outbound connections, or nil when
+// the defaults apply
+func tlsConfig(config *Config) (*tls.Config, error) {
+	paths := caBundlePaths(config)
+	if len(paths) == 0 && !config.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConf := &tls.Config{}
+	if len(paths) > 0 {
+		pool, err := loadCertPool(paths...)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.RootCAs = pool
+	}
+	if config.InsecureSkipVerify {
+		tlsConf.InsecureSkipVerify = true
+	}
+	return tlsConf, nil
+}
+
+// transportCache holds the transport built from the proxy and TLS settings,
+// so connections are reused and CA bundles are read once until the settings change
+type transportCache struct {
+	mu        sync.Mutex
+	built     bool
+	transport *http.Transport // nil when nothing is configured
+	insecure  bool            // TLS certificate verification is off
+}
+
+// createTransport returns the transport with the proxy and TLS settings of
+// the selected profile. It returns nil when nothing is configured, so callers
+// can keep using the shared default transport. The transport is shared; use
+// Clone before changing it.
+func (b *BroadcomService) createTransport(component string) (*http.Transport, error) {
+	cache := &b.transports
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if !cache.built {
+		config, err := b.loadConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load connection settings: %w", err)
+		}
+		transport, err := b.buildTransport(config)
+		if err != nil {
+			return nil, err
+		}
+		cache.transport = transport
+		cache.insecure = config.InsecureSkipVerify
+		cache.built = true
+	}
+
+	if cache.insecure {
+		warnInsecureTLS(component)
+	}
+	return cache.transport, nil
+}
+
+// resetTransport makes the next request build a new transport. Setters of
+// proxy and TLS settings call it after saving them.
+func (b *BroadcomService) resetTransport() {
+	cache := &b.transports
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.transport != nil {
+		cache.transport.CloseIdleConnections()
+	}
+	cache.transport = nil
+	cache.built = false
+}
+
+// saveConnectionConfig saves changed proxy or TLS settings and drops the
+// transport built from the old ones
+func (b *BroadcomService) saveConnectionConfig(config *Config) error {
+	err := b.saveConfig(config)
+	b.resetTransport()
+	return err
+}
+
+// buildTransport builds a transport for the proxy and TLS settings of config,
+// or returns nil when nothing is configured
+func (b *BroadcomService) buildTransport(config *Config) (*http.Transport, error) {
+	tlsConf, err := tlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.HTTPProxy == "" && config.HTTPSProxy == "" && tlsConf == nil {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.HTTPProxy != "" || config.HTTPSProxy != "" {
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return b.proxyForURL(config, req.URL)
+		}
+	}
+	if tlsConf != nil {
+		transport.TLSClientConfig = tlsConf
+	}
+	return transport, nil
+}
+
+// aiModelTransport returns a copy of the transport for AI model downloads,
+// which the Hub client sets its own timeouts on
+func (b *BroadcomService) aiModelTransport() (*http.Transport, error) {
+	transport, err := b.createTransport("AI model downloads")
+	if err != nil || transport == nil {
+		return nil, err
+	}
+	return transport.Clone(), nil
+}
+
+// systemCertBundle returns the PEM roots child processes would otherwise use
+func systemCertBundle() []byte {
+	candidates := systemCertFiles
+	if current := os.Getenv("SSL_CERT_FILE"); current != "" {
+		candidates = append([]string{current}, candidates...)
+	}
+	for _, path := range candidates {
+		if data, err := os.ReadFile(path); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// setTLSEnv points a child process at a bundle of the system roots plus the
+// configured CA bundles through SSL_CERT_FILE. Insecure mode is a command line
+// flag of om and is not handled here.
+func (b *BroadcomService) setTLSEnv(cmd *exec.Cmd) error {
+	config, err := b.loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load TLS settings: %w", err)
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+
+	paths := caBundlePaths(config)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	var bundle bytes.Buffer
+	bundle.Write(systemCertBundle())
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		bundle.WriteString("\n")
+		bundle.Write(data)
+	}
+
+	configDir, err := b.getConfigDir()
+	if err != nil {
+		return err
+	}
+	bundlePath := filepath.Join(configDir, caBundleFileName)
+	if err := os.WriteFile(bundlePath, bundle.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write CA bundle: %w", err)
+	}
+	cmd.Env = append(cmd.Env, "SSL_CERT_FILE="+bundlePath)
+	return nil
+}
+
+// GetCACertFiles returns the extra PEM CA bundles trusted for all connections
+func (b *BroadcomService) GetCACertFiles() ([]string, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.CACertFiles == nil {
+		return []string{}, nil
+	}
+	return config.CACertFiles, nil
+}
+
+// SetCACertFiles sets PEM CA bundles that are trusted in addition to the
+// system roots by the API client, om and AI model downloads
+func (b *BroadcomService) SetCACertFiles(paths []string) error {
+	var cleaned []string
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path != "" {
+			cleaned = append(cleaned, path)
+		}
+	}
+	if _, err := loadCertPool(cleaned...); err != nil {
+		return err
+	}
+
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+
+	config.CACertFiles = cleaned
+	return b.saveConnectionConfig(config)
+}
+
+// GetInsecureSkipVerify reports whether TLS certificate verification is disabled
+func (b *BroadcomService) GetInsecureSkipVerify() (bool, error) {
+	config, err := b.loadConfig()
+	if err != nil {
+		return false, err
+	}
+	return config.InsecureSkipVerify, nil
+}
+
+// SetInsecureSkipVerify turns TLS certificate verification off or back on.
+// This is an escape hatch for broken environments and is logged on use.
+func (b *BroadcomService) SetInsecureSkipVerify(insecure bool) error {
+	config, err := b.loadConfig()
+	if err != nil {
+		config = b.getDefaultConfig()
+	}
+
+	if insecure {
+		fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification has been DISABLED for all connections")
+	} else if config.InsecureSkipVerify {
+		insecureWarnings.Range(func(key, _ interface{}) bool {
+			insecureWarnings.Delete(key)
+			return true
+		})
+	}
+	config.InsecureSkipVerify = insecure
+	return b.saveConnectionConfig(config)
+}
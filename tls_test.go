@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCA writes a self-signed CA certificate as a PEM bundle
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCreateTransportIsCachedUntilSettingsChange(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	b := NewBroadcomService()
+
+	if transport, err := b.createTransport("tests"); err != nil || transport != nil {
+		t.Fatalf("without settings got %v, %v; want the default transport", transport, err)
+	}
+
+	caPath := writeTestCA(t)
+	if err := b.SetCACertFiles([]string{caPath}); err != nil {
+		t.Fatalf("SetCACertFiles: %v", err)
+	}
+	first, err := b.createTransport("tests")
+	if err != nil || first == nil {
+		t.Fatalf("createTransport: %v, %v", first, err)
+	}
+
+	// The cached transport does not read the CA bundle again
+	if err := os.Remove(caPath); err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.createTransport("tests")
+	if err != nil {
+		t.Fatalf("createTransport after the bundle was read: %v", err)
+	}
+	if second != first {
+		t.Error("transport was rebuilt without a settings change")
+	}
+
+	if err := b.SetCACertFiles(nil); err != nil {
+		t.Fatalf("SetCACertFiles: %v", err)
+	}
+	if err := b.SetHTTPSProxy("http://proxy.example:3128"); err != nil {
+		t.Fatalf("SetHTTPSProxy: %v", err)
+	}
+	third, err := b.createTransport("tests")
+	if err != nil || third == nil {
+		t.Fatalf("createTransport after a proxy change: %v, %v", third, err)
+	}
+	if third == first || (third.TLSClientConfig != nil && third.TLSClientConfig.RootCAs != nil) {
+		t.Error("transport was not rebuilt after the settings changed")
+	}
+}
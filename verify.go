@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// quarantineDirName is the directory, next to the downloads, that receives corrupt files
+const quarantineDirName = ".quarantine"
+
+// errChecksumMismatch is returned when a download does not match the API checksum
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// hashFile streams a file through the given hash and returns the hex digest
+func hashFile(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// expectedChecksum picks the strongest checksum the API provides for a file
+func expectedChecksum(file ProductFile) (algorithm string, expected string, h hash.Hash) {
+	if file.SHA256 != "" {
+		return "sha256", strings.ToLower(file.SHA256), sha256.New()
+	}
+	if file.MD5 != "" {
+		return "md5", strings.ToLower(file.MD5), md5.New()
+	}
+	return "", "", nil
+}
+
+// quarantineFile moves a corrupt download out of the download directory
+func quarantineFile(path string) (string, error) {
+	quarantineDir := filepath.Join(filepath.Dir(path), quarantineDirName)
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(quarantineDir, filepath.Base(path))
+	if err := os.Rename(path, target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// verifyDownload compares a completed download against the checksum from the API.
+// Files that do not match are quarantined and reported with a download-corrupt event.
+func (b *BroadcomService) verifyDownload(path string, file ProductFile) error {
+	algorithm, expected, h := expectedChecksum(file)
+	if h == nil {
+		// Nothing to compare against
+		return nil
+	}
+
+	// A file hashed before and unchanged since is not read again
+	actual := ""
+	if algorithm == "sha256" {
+		actual = b.cachedChecksum(path)
+	}
+	if actual == "" {
+		b.emit("download-progress", map[string]interface{}{
+			"fileID":   file.ID,
+			"progress": 100,
+			"status":   "Verifying checksum...",
+		})
+
+		var err error
+		actual, err = hashFile(path, h)
+		if err != nil {
+			return fmt.Errorf("failed to verify %s: %w", path, err)
+		}
+	}
+
+	if actual != expected {
+		quarantinedPath, qErr := quarantineFile(path)
+		if qErr != nil {
+			quarantinedPath = ""
+		}
+
+		b.emit("download-corrupt", map[string]interface{}{
+			"fileID":          file.ID,
+			"path":            path,
+			"algorithm":       algorithm,
+			"expected":        expected,
+			"actual":          actual,
+			"quarantinedPath": quarantinedPath,
+		})
+
+		if qErr != nil {
+			return fmt.Errorf("%w for %s (%s expected %s, got %s); quarantine failed: %v", errChecksumMismatch, filepath.Base(path), algorithm, expected, actual, qErr)
+		}
+		return fmt.Errorf("%w for %s (%s expected %s, got %s); moved to %s", errChecksumMismatch, filepath.Base(path), algorithm, expected, actual, quarantinedPath)
+	}
+
+	if algorithm == "sha256" {
+		b.rememberChecksum(path, actual)
+	}
+
+	b.emit("download-verified", map[string]interface{}{
+		"fileID":    file.ID,
+		"path":      path,
+		"algorithm": algorithm,
+		"checksum":  actual,
+	})
+
+	return nil
+}